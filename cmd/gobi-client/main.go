@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,6 +11,9 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/client"
@@ -16,25 +21,39 @@ import (
 	"github.com/Michaelpalacce/gobi/pkg/gobi-client/auth"
 	"github.com/Michaelpalacce/gobi/pkg/gobi-client/connection"
 	"github.com/Michaelpalacce/gobi/pkg/gobi-client/settings"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/supervisor"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
 	"github.com/Michaelpalacce/gobi/pkg/logger"
+	"github.com/Michaelpalacce/gobi/pkg/messages"
 	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
 	"github.com/Michaelpalacce/gobi/pkg/storage"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// minReconnectDelay is the delay before the first reconnect attempt, before jitter
+	minReconnectDelay = time.Second
+	// maxReconnectDelay is the highest delay between reconnect attempts, before jitter
+	maxReconnectDelay = 2 * time.Minute
+)
+
 func main() {
 	logger.ConfigureLogging()
 
 	// Define command-line flags for username and password
 	var (
-		username     string
-		host         string
-		password     string
-		vaultName    string
-		vaultPath    string
-		syncStrategy int
-		gobiClient   *connection.ClientConnection
+		username      string
+		host          string
+		password      string
+		vaultName     string
+		vaultPath     string
+		syncStrategy  int
+		syncDirection int
+		include       string
+		exclude       string
+		pingInterval  int
+		encoding      string
 	)
 
 	flag.StringVar(&host, "host", "localhost:8080", "Target host")
@@ -43,80 +62,130 @@ func main() {
 	flag.StringVar(&vaultName, "vaultName", "testVault", "The name of the vault to connect to")
 	flag.StringVar(&vaultPath, "vaultPath", ".dev/clientFolder", "The path to the vault to watch")
 	flag.IntVar(&syncStrategy, "syncStrategy", 1, "The sync strategy to use. Available: 1 (default): lastModified")
+	flag.StringVar(&include, "include", "", "Comma separated list of path prefixes to sync. Syncs everything if empty. Replaces the persisted list when given")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated list of path prefixes to never sync. Replaces the persisted list when given")
+	flag.IntVar(&syncDirection, "syncDirection", 1, "The direction to sync in. Available: 1 (default): bidirectional, 2: download-only, 3: upload-only")
+	flag.StringVar(&encoding, "encoding", "cbor", "The encoding to prefer for websocket messages. Available: cbor (default), json. Falls back to json if the server does not support it")
+	flag.IntVar(&pingInterval, "pingInterval", 30, "Seconds between pings to the server. The connection is dropped if the server stays silent for 2.5 times that. 0 disables pings")
 
 	// Parse command-line flags
 	flag.Parse()
 
-	go interrupt(gobiClient)
-
-out:
-	for {
-		closeChan := make(chan error, 1)
-		defer close(closeChan)
-
-		var (
-			conn *websocket.Conn
-			err  error
-		)
-
-		options := gobiclient.Options{
-			Username:  username,
-			Password:  password,
-			Host:      host,
-			VaultName: vaultName,
-			VaultPath: vaultPath,
-			// This is intenionally hardcoded to 1
-			// We want to always use the latest :)
-			WebsocketVersion: 1,
-		}
+	if _, ok := messages.CodecFor(messages.Encoding(encoding)); !ok {
+		slog.Error("Unknown encoding", "encoding", encoding)
+		os.Exit(1)
+	}
 
-		if conn, err = establishConn(options); err != nil {
-			slog.Error("Error while trying to establish connection to server. Since no initial connection could be established, closing.", "error", err)
-			break out
-		}
+	options := gobiclient.Options{
+		Username:      username,
+		Password:      password,
+		Host:          host,
+		VaultName:     vaultName,
+		VaultPath:     vaultPath,
+		SyncStrategy:  syncStrategy,
+		SyncDirection: syncDirection,
+		Subscription: client.Subscription{
+			Include: splitPaths(include),
+			Exclude: splitPaths(exclude),
+		},
+		// This is the version of the API in the URLs, intentionally hardcoded to 1
+		// The version of the websocket protocol is agreed on with the server in the hello
+		WebsocketVersion: 1,
+		Encoding:         messages.Encoding(encoding),
+		Overrides:        make(map[string]bool),
+	}
 
-		settingsStore, err := settings.NewStore(options)
-		if err != nil {
-			slog.Error("Error creating settings store", "error", err)
-			break out
-		}
+	// Flags given on the command line replace the settings persisted in the vault
+	flag.Visit(func(f *flag.Flag) {
+		options.Overrides[f.Name] = true
+	})
 
-		// Create a new storage driver
-		storageDriver, err := storage.NewLocalDriver(options.VaultName)
-		if err != nil {
-			slog.Error("Error creating storage driver", "error", err)
-			break out
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reconnectSupervisor := supervisor.New(minReconnectDelay, maxReconnectDelay)
+	if err := reconnectSupervisor.Run(ctx, func(ctx context.Context) (bool, error) {
+		return connect(ctx, options, socket.NewHeartbeat(time.Duration(pingInterval)*time.Second))
+	}); err != nil {
+		slog.Error("Giving up on connecting to the server", "error", err)
+		os.Exit(1)
+	}
+}
+
+// connect will establish a connection to the server and sync until the connection is lost or the context is done.
+// Returns if the connection was established and why it ended. Errors that reconnecting will not fix are marked as fatal
+func connect(ctx context.Context, options gobiclient.Options, heartbeat socket.Heartbeat) (bool, error) {
+	conn, err := establishConn(options)
+	if err != nil {
+		return false, err
+	}
+
+	settingsStore, err := settings.NewStore(options)
+	if err != nil {
+		conn.Close()
+		return false, supervisor.Fatal(fmt.Errorf("error creating settings store: %w", err))
+	}
 
-		gobiClient = &connection.ClientConnection{
-			LocalSettings: settingsStore,
-			WebsocketClient: &socket.WebsocketClient{
-				Client: client.ClientMetadata{
-					Version:      settingsStore.Settings.WebsocketVersion,
-					VaultName:    settingsStore.Settings.VaultName,
-					LastSync:     settingsStore.Sync.LastSync,
-					SyncStrategy: settingsStore.Settings.SyncStrategy,
-				},
-				Conn:          conn,
-				StorageDriver: storageDriver,
-				User: models.User{
-					Username: options.Username,
-					Password: options.Password,
-				},
+	// Create a new storage driver
+	storageDriver, err := storage.NewLocalDriver(options.VaultName)
+	if err != nil {
+		conn.Close()
+		return false, supervisor.Fatal(fmt.Errorf("error creating storage driver: %w", err))
+	}
+
+	gobiClient := &connection.ClientConnection{
+		LocalSettings: settingsStore,
+		Transfer:      transfer.NewClient(options),
+		Heartbeat:     heartbeat,
+		Encoding:      options.Encoding,
+		WebsocketClient: &socket.WebsocketClient{
+			Client: client.ClientMetadata{
+				VaultName:     settingsStore.Settings.VaultName,
+				LastSync:      settingsStore.Sync.LastSync,
+				Sequence:      settingsStore.Sync.Sequence,
+				SyncStrategy:  settingsStore.Settings.SyncStrategy,
+				SyncDirection: settingsStore.Settings.SyncDirection,
+				Subscription:  settingsStore.Settings.Subscription,
 			},
-		}
+			Conn:          conn,
+			StorageDriver: storageDriver,
+			User: models.User{
+				Username: options.Username,
+				Password: options.Password,
+			},
+		},
+	}
 
-		go gobiClient.Listen(closeChan)
+	// Listen may still report why it stopped after we returned, so the channel is never closed
+	closeChan := make(chan error, 1)
+	go gobiClient.Listen(closeChan)
 
-		err = <-closeChan
-		if err != nil {
-			slog.Error("Closing connection due to error with server", "error", err)
-			gobiClient.Close(err.Error())
-		}
+	select {
+	case <-ctx.Done():
+		gobiClient.Close("os.Interrupt received. Closing connection.")
+		return true, nil
+	case err = <-closeChan:
+	}
 
+	switch {
+	case errors.Is(err, connection.ErrServerShutdown):
 		gobiClient.Close("")
-		time.Sleep(5 * time.Second)
+		return true, supervisor.RetryAfter(err, gobiClient.ReconnectAfter)
+	case errors.Is(err, connection.ErrUnknownVault):
+		gobiClient.Close(err.Error())
+		return true, supervisor.Fatal(fmt.Errorf("%w, create it with POST /api/v1/vaults first", err))
+	case errors.Is(err, connection.ErrUnsupportedVersion):
+		gobiClient.Close(err.Error())
+		return true, supervisor.Fatal(err)
+	case err != nil:
+		slog.Error("Closing connection due to error with server", "error", err)
+		gobiClient.Close(err.Error())
+		return true, err
 	}
+
+	gobiClient.Close("")
+
+	return true, fmt.Errorf("connection closed by the server")
 }
 
 // establishConn establish a connection to the server using the given option.
@@ -128,22 +197,22 @@ func establishConn(options gobiclient.Options) (*websocket.Conn, error) {
 		Path:   fmt.Sprintf("/api/v%d/ws/", options.WebsocketVersion),
 	}
 
-	header := http.Header{"Authorization": []string{auth.BasicAuth(options.Username, options.Password)}}
+	header := http.Header{
+		"Authorization": []string{auth.BasicAuth(options.Username, options.Password)},
+		"User-Agent":    []string{gobiclient.UserAgent()},
+	}
 	dialer := websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment,
 		HandshakeTimeout: 45 * time.Second,
+		// Messages are compressed with permessage-deflate, if the server supports it
+		EnableCompression: true,
 	}
 
 	// Establish a WebSocket connection with headers
 	conn, resp, websocketErr := dialer.Dial(url.String(), header)
 	if websocketErr != nil {
 		if resp != nil {
-			// Read and print the body content
-			body, err := io.ReadAll(io.Reader(resp.Body))
-			if err != nil {
-				return nil, fmt.Errorf("error connecting to WebSocket: %w", websocketErr)
-			}
-			return nil, fmt.Errorf("error connecting to WebSocket: %w, response was %s", websocketErr, body)
+			return nil, handshakeError(websocketErr, resp)
 		}
 
 		return nil, fmt.Errorf("error connecting to WebSocket: %w", websocketErr)
@@ -152,17 +221,31 @@ func establishConn(options gobiclient.Options) (*websocket.Conn, error) {
 	return conn, nil
 }
 
-func interrupt(gobiClient *connection.ClientConnection) {
-	// Set up a channel to handle signals for graceful shutdown
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	defer close(interrupt)
+// handshakeError classifies the response of a failed handshake. Bad credentials, disabled users and unknown API versions
+// are fatal. If the server is busy or shutting down, the delay it asks for is honoured
+func handshakeError(websocketErr error, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("error connecting to WebSocket: %w, response was %s", websocketErr, body)
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return supervisor.Fatal(err)
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return supervisor.RetryAfter(err, time.Duration(seconds)*time.Second)
+	}
 
-	<-interrupt
+	return err
+}
 
-	if gobiClient != nil {
-		gobiClient.Close("os.Interrupt received. Closing connection.")
+// splitPaths splits a comma separated list of paths, ignoring empty entries
+func splitPaths(paths string) []string {
+	result := make([]string, 0)
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			result = append(result, path)
+		}
 	}
 
-	os.Exit(1)
+	return result
 }
@@ -1,16 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/Michaelpalacce/gobi/internal/gobi/handlers"
 	"github.com/Michaelpalacce/gobi/internal/gobi/routes"
 	"github.com/Michaelpalacce/gobi/internal/gobi/services"
 	"github.com/Michaelpalacce/gobi/pkg/database"
 	"github.com/Michaelpalacce/gobi/pkg/logger"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/redis"
 )
 
+// userDeletionRetryInterval is how often user deletions that did not finish are retried
+const userDeletionRetryInterval = 10 * time.Minute
+
+// reconnectDelay is how long clients are told to wait at least before reconnecting, when the server shuts down
+const reconnectDelay = 5 * time.Second
+
+// defaultShutdownTimeout is how long in-flight requests and connected clients get to finish when the server shuts down
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	logger.ConfigureLogging()
 
@@ -26,23 +45,149 @@ func main() {
 
 	defer db.Disconnect()
 
+	usersService := services.NewUsersService(db)
+
+	var admin *models.User
+	if adminUsername := os.Getenv("GOBI_ADMIN_USERNAME"); adminUsername != "" {
+		if err := usersService.EnsureAdmin(adminUsername, os.Getenv("GOBI_ADMIN_PASSWORD")); err != nil {
+			log.Fatalf("Error while creating the admin user: %s", err)
+		}
+
+		if admin, err = usersService.GetUserByName(adminUsername); err != nil {
+			log.Fatalf("Error while fetching the admin user: %s", err)
+		}
+	}
+
+	vaultsService := services.NewVaultsService(db)
+	quotaService := services.NewQuotaService(db)
+	auditService := services.NewAuditService(db)
+	eventService := services.NewEventService(db)
+	itemService := services.NewItemService(db, quotaService, auditService, eventService)
+
+	if err := services.MigrateVaultStorage(vaultsService, itemService, admin); err != nil {
+		log.Fatalf("Error while migrating vault storage: %s", err)
+	}
+
+	if err := services.RepairItemMetadata(itemService); err != nil {
+		slog.Error("Error while repairing item metadata", "error", err)
+	}
+
+	websocketService := services.NewWebsocketService(vaultsService, itemService, auditService)
+
+	userDeletionService := services.NewUserDeletionService(usersService, vaultsService, websocketService)
+	userDeletionService.Resume(userDeletionRetryInterval)
+
 	usersHandler := *handlers.NewUsersHandler(
-		services.NewUsersService(db),
+		usersService,
+		userDeletionService,
+		websocketService,
+		auditService,
+		os.Getenv("GOBI_OPEN_REGISTRATION") != "false",
+	)
+
+	vaultsHandler := *handlers.NewVaultsHandler(
+		vaultsService,
 	)
 
 	websocketHandler := *handlers.NewWebsocketHandler(
-		services.NewWebsocketService(),
+		websocketService,
 	)
 
 	itemHandler := *handlers.NewItemHandler(
-		services.NewItemService(),
+		itemService,
+	)
+
+	quotaHandler := *handlers.NewQuotaHandler(
+		quotaService,
+	)
+
+	adminHandler := *handlers.NewAdminHandler(
+		usersService,
+		userDeletionService,
+		websocketService,
+		auditService,
+	)
+
+	auditHandler := *handlers.NewAuditHandler(
+		auditService,
 	)
 
 	r := routes.SetupRouter(
 		usersHandler,
+		vaultsHandler,
 		websocketHandler,
 		itemHandler,
+		quotaHandler,
+		adminHandler,
+		auditHandler,
+		services.NewAuthLimiter(),
 	)
 
-	r.Run() // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
+	server := &http.Server{
+		Addr:    listenAddress(),
+		Handler: r,
+	}
+
+	go func() {
+		slog.Info("Listening", "address", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error while listening: %s", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	<-ctx.Done()
+	stop()
+
+	shutdown(server, websocketService, auditService)
+}
+
+// shutdown will stop accepting new connections and tell the connected clients to come back later. In-flight requests and
+// clients get until the shutdown timeout to finish. Queued audit entries are written and Redis is closed afterwards,
+// the database is disconnected by main
+func shutdown(server *http.Server, websocketService *services.WebsocketService, auditService *services.AuditService) {
+	timeout := shutdownTimeout()
+	slog.Info("Shutting down", "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	websocketsClosed := make(chan struct{})
+	go func() {
+		websocketService.Shutdown(ctx, reconnectDelay)
+		close(websocketsClosed)
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Error while waiting for requests to finish", "error", err)
+	}
+
+	<-websocketsClosed
+
+	auditService.Close(ctx)
+
+	if err := redis.Close(); err != nil {
+		slog.Error("Error while closing redis", "error", err)
+	}
+}
+
+// listenAddress returns the address to listen on, the same way gin does it. Defaults to :8080
+func listenAddress() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+
+	return ":8080"
+}
+
+// shutdownTimeout returns how long the server waits for requests and clients to finish when shutting down
+func shutdownTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("GOBI_SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return defaultShutdownTimeout
+	}
+
+	return time.Duration(seconds) * time.Second
 }
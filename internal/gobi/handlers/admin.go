@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AdminHandler is the handler for the routes used by server operators. All routes require the admin role
+type AdminHandler struct {
+	UsersService     *services.UsersService
+	DeletionService  *services.UserDeletionService
+	WebsocketService *services.WebsocketService
+	AuditService     *services.AuditService
+}
+
+// NewAdminHandler will instantiate a new AdminHandler given the services it operates on
+func NewAdminHandler(
+	usersService *services.UsersService,
+	deletionService *services.UserDeletionService,
+	websocketService *services.WebsocketService,
+	auditService *services.AuditService,
+) *AdminHandler {
+	return &AdminHandler{
+		UsersService:     usersService,
+		DeletionService:  deletionService,
+		WebsocketService: websocketService,
+		AuditService:     auditService,
+	}
+}
+
+// userUpdate contains the fields an admin can change on a user. Fields that are not set are not changed
+type userUpdate struct {
+	Disabled *bool            `json:"disabled"`
+	Role     *models.UserRole `json:"role"`
+}
+
+// userCreation contains the fields an admin creates a user with. Users without a role are regular users
+type userCreation struct {
+	Username string          `json:"username" binding:"required"`
+	Password string          `json:"password" binding:"required"`
+	Role     models.UserRole `json:"role"`
+}
+
+// passwordReset contains the new password of a user
+type passwordReset struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// GetUsers will return the profiles of all users. Password hashes are never returned
+func (h AdminHandler) GetUsers(c *gin.Context) {
+	users, err := h.UsersService.GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to fetch users: %w", err).Error()})
+		return
+	}
+
+	profiles := make([]models.Profile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, user.Profile())
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+// CreateUser will create a user with the given role. Works even if open registration is disabled
+// Returns 201 with the profile of the user if the user is created successfully
+func (h AdminHandler) CreateUser(c *gin.Context) {
+	var request userCreation
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind user: %w", err).Error()})
+		return
+	}
+
+	user := &models.User{
+		Username: request.Username,
+		Password: request.Password,
+		Role:     request.Role,
+	}
+
+	if err := h.UsersService.CreateUser(user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to create user: %w", err).Error()})
+		return
+	}
+
+	h.AuditService.Record(accountEntry(actorOf(c), models.AuditActionUserCreated, user.Username))
+
+	c.JSON(http.StatusCreated, user.Profile())
+}
+
+// UpdateUser will disable, enable or change the role of the user given by the `username` path parameter.
+// Disabled users are disconnected. Admins cannot disable or demote themselves
+func (h AdminHandler) UpdateUser(c *gin.Context) {
+	username := c.Param("username")
+
+	var update userUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind update: %w", err).Error()})
+		return
+	}
+
+	if h.isSelf(c, username) && ((update.Disabled != nil && *update.Disabled) || (update.Role != nil && *update.Role != models.UserRoleAdmin)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admins cannot disable or demote themselves"})
+		return
+	}
+
+	if update.Role != nil {
+		if err := h.UsersService.SetRole(username, *update.Role); err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		entry := accountEntry(actorOf(c), models.AuditActionUserUpdated, username)
+		entry.Details += fmt.Sprintf(", role set to %s", *update.Role)
+		h.AuditService.Record(entry)
+	}
+
+	if update.Disabled != nil {
+		if err := h.UsersService.SetDisabled(username, *update.Disabled); err != nil {
+			respondUserError(c, err)
+			return
+		}
+
+		entry := accountEntry(actorOf(c), models.AuditActionUserUpdated, username)
+		entry.Details += fmt.Sprintf(", disabled set to %t", *update.Disabled)
+		h.AuditService.Record(entry)
+
+		if *update.Disabled {
+			h.WebsocketService.DisconnectUser(username, "User was disabled")
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// DeleteUser will disconnect and delete the user given by the `username` path parameter. Admins cannot delete themselves
+// Returns 202, since the data of the user is removed in the background
+func (h AdminHandler) DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	if h.isSelf(c, username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admins cannot delete themselves"})
+		return
+	}
+
+	user, err := h.UsersService.GetUserByName(username)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	if err := h.DeletionService.DeleteUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to delete user: %w", err).Error()})
+		return
+	}
+
+	h.AuditService.Record(accountEntry(actorOf(c), models.AuditActionUserDeleted, username))
+
+	c.JSON(http.StatusAccepted, gin.H{})
+}
+
+// ResetPassword will set a new password for the user given by the `username` path parameter and revoke all of their sessions
+func (h AdminHandler) ResetPassword(c *gin.Context) {
+	username := c.Param("username")
+
+	var reset passwordReset
+	if err := c.ShouldBindJSON(&reset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind password: %w", err).Error()})
+		return
+	}
+
+	if err := h.UsersService.SetPassword(username, reset.Password); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	h.AuditService.Record(accountEntry(actorOf(c), models.AuditActionPasswordReset, username))
+
+	if err := h.WebsocketService.RevokeUser(username, "Password was reset"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to revoke sessions: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// GetSessions will return all connected clients. Pass the `username` query parameter to only get the clients of one user
+func (h AdminHandler) GetSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, h.WebsocketService.GetSessions(c.Query("username")))
+}
+
+// DisconnectSession will force the client with the session given by the `session` path parameter to disconnect
+// Returns 404 if no such client is connected
+func (h AdminHandler) DisconnectSession(c *gin.Context) {
+	if !h.WebsocketService.DisconnectSession(c.Param("session"), "Disconnected by an admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// isSelf returns true if the given username is the one of the admin making the request
+func (h AdminHandler) isSelf(c *gin.Context, username string) bool {
+	user, ok := c.MustGet("user").(*models.User)
+
+	return ok && user.Username == username
+}
+
+// respondUserError will respond with 404 if the user does not exist and 400 otherwise
+func respondUserError(c *gin.Context, err error) {
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
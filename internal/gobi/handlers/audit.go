@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/messages/v1/rest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler is the handler for reading the audit log
+type AuditHandler struct {
+	Service *services.AuditService
+}
+
+// NewAuditHandler will instantiate a new AuditHandler given the AuditService
+func NewAuditHandler(service *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		Service: service,
+	}
+}
+
+// GetVaultEntries will return the audit log of the vault, newest first.
+// Can be filtered with the `username`, `action`, `path`, `since`, `until` and `limit` query parameters
+func (h *AuditHandler) GetVaultEntries(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+
+	filter.VaultId = vault.ID.Hex()
+
+	h.respond(c, filter)
+}
+
+// GetEntries will return the whole audit log, including account events, newest first. Only for admins.
+// Can be filtered with the same query parameters as GetVaultEntries, as well as `vault_id`
+func (h *AuditHandler) GetEntries(c *gin.Context) {
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+
+	filter.VaultId = c.Query("vault_id")
+
+	h.respond(c, filter)
+}
+
+// respond will respond with the entries matching the filter
+func (h *AuditHandler) respond(c *gin.Context, filter services.AuditFilter) {
+	entries, err := h.Service.Find(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to fetch audit log: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// auditFilter will build the filter from the query parameters. Responds with 400 and returns false if they are invalid
+func auditFilter(c *gin.Context) (services.AuditFilter, bool) {
+	filter := services.AuditFilter{
+		Username: c.Query("username"),
+		Action:   models.AuditAction(c.Query("action")),
+		Path:     c.Query("path"),
+	}
+
+	for param, value := range map[string]*int64{"since": &filter.Since, "until": &filter.Until, "limit": &filter.Limit} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", param)})
+			return filter, false
+		}
+
+		*value = parsed
+	}
+
+	return filter, true
+}
+
+// accountEntry returns the audit entry for a change the actor made to the account of the user with the given username
+func accountEntry(actor models.Actor, action models.AuditAction, username string) models.AuditEntry {
+	entry := actor.Entry(action)
+	entry.Details = fmt.Sprintf("user %s", username)
+
+	return entry
+}
+
+// actorOf returns who is making the request: the authenticated user, the device from the User-Agent header and the
+// websocket session from the session header, if the client sent one
+func actorOf(c *gin.Context) models.Actor {
+	user := c.MustGet("user").(*models.User)
+
+	return models.ActorOf(*user, c.Request.UserAgent(), c.GetHeader(rest.SessionHeader), c.ClientIP())
+}
@@ -1,15 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
 
 	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/compression"
+	"github.com/Michaelpalacce/gobi/pkg/iops"
+	"github.com/Michaelpalacce/gobi/pkg/messages/v1/rest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// maxReplacedBytes is how much bigger than the remaining quota an upload request can be.
+// Replacing an item only counts the difference in size, so the request itself can be bigger than what is left
+const maxReplacedBytes = 64 << 20
+
 // ItemHandler is the handler for the item routes
-// @TODO: Implement
+// All routes are scoped to the vault set by the VaultAccess middleware
 type ItemHandler struct {
 	Service *services.ItemService
 }
@@ -21,29 +38,559 @@ func NewItemHandler(service *services.ItemService) *ItemHandler {
 	}
 }
 
-// GetItem will retrieve the item from the database
+// ListItems will return the items and subdirectories in the directory given by the `path` query parameter.
+// Pass `recursive=true` to get all the items under the directory instead
+func (h *ItemHandler) ListItems(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	var dir iops.VaultPath
+	if c.Query("path") != "" {
+		var ok bool
+		if dir, ok = queryPath(c); !ok {
+			return
+		}
+	}
+
+	items, directories, err := h.Service.ListItems(vault, dir, c.Query("recursive") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to list items: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": c.Query("path"), "directories": directories, "items": items})
+}
+
+// StatItem will return the metadata of the item given by the `path` query parameter
+// Returns 404 if the item does not exist
+func (h *ItemHandler) StatItem(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	itemPath, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	item, err := h.Service.GetItem(vault, itemPath)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to fetch item: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// ListCollisions will return the groups of items whose paths only differ in case or unicode normalization.
+// These cannot all exist on case-insensitive filesystems and should be renamed
+func (h *ItemHandler) ListCollisions(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	collisions, err := h.Service.GetCollisions(vault)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to find collisions: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"collisions": collisions})
+}
+
+// GetItem will stream the contents of the item given by the `path` query parameter
+// Supports Range requests. Returns 404 if the item does not exist and 400 if it is a directory or a symlink.
+// If the client accepts zstd, files that are worth it are compressed. Range requests are never compressed
 func (h *ItemHandler) GetItem(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	itemPath, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	item, reader, err := h.Service.OpenItem(vault, itemPath)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	if err == services.ErrNotAFile {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only files can be downloaded"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to open item: %w", err).Error()})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Vary", "Accept-Encoding")
+
+	if compression.Accepts(c.GetHeader("Accept-Encoding")) && c.GetHeader("Range") == "" && compression.ShouldCompress(item.ServerPath, int64(item.Size)) {
+		streamCompressed(c, item, reader)
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf(`"%s"`, item.SHA256))
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, path.Base(item.ServerPath), time.Unix(item.ServerMTime, 0), seeker)
+		return
+	}
+
+	c.Header("Content-Length", strconv.Itoa(item.Size))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		slog.Error("Error streaming item", "error", err)
+	}
+}
+
+// streamCompressed will stream the contents of the item compressed with zstd
+func streamCompressed(c *gin.Context, item *models.Item, reader io.Reader) {
+	c.Header("ETag", fmt.Sprintf(`"%s-%s"`, item.SHA256, compression.Zstd))
+	c.Header("Content-Encoding", compression.Zstd)
+	c.Status(http.StatusOK)
+
+	writer, err := compression.NewWriter(c.Writer)
+	if err != nil {
+		slog.Error("Error compressing item", "error", err)
+		return
+	}
+
+	size, err := io.Copy(writer, reader)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		slog.Error("Error streaming item", "error", err)
+		return
+	}
+
+	slog.Debug("Streamed compressed item", "path", item.ServerPath, "bytes", size, "wireBytes", c.Writer.Size())
 }
 
-// CreateItem will insert the given item in the database.
-// Returns 201 if the item is created successfully
-// @TODO: We need to make sure that when the user is uploading a file, we are saving it in the correct location and they have permission to do so
+// CreateItem will store the uploaded files in the vault and record their metadata.
+// The `item` multipart field contains the files. If a single file is uploaded, the `path` field can be used to set where
+// it is stored, otherwise the file name is used. The `mtime` field can be used to set the modification time in unix seconds
+// and the `mode` field the permission bits in octal.
+// A single file can be compressed with zstd by setting the `encoding` field to `zstd` and the `size` field to its
+// uncompressed size, which is what counts against the quota. Returns 400 if the contents are not that size.
+// Directories and symlinks are created by setting the `kind` field to `dir` or `symlink` together with the `path` field,
+// without uploading any files. Symlinks need the `target` field, relative to the symlink and inside of the vault.
+// Items whose path collides with an existing item, differing only in case or unicode normalization, are renamed with a
+// suffix, so the returned paths can differ from the uploaded ones. Connected clients of the vault are notified of the change.
+// The `base_sequence` field is the version of the item the upload is based on. Returns 409 with the current item if it changed since
+// Returns 201 if the items are created successfully. When uploading more than one file, every file is saved on its own and
+// 207 is returned with the result of each file if any of them failed
 func (h *ItemHandler) CreateItem(c *gin.Context) {
-	form, _ := c.MultipartForm()
+	vault := c.MustGet("vault").(*models.Vault)
+
+	if err := h.limitBody(c, vault); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to check quota: %w", err).Error()})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		quotaExceeded(c, services.ErrQuotaExceeded)
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to parse form: %w", err).Error()})
+		return
+	}
+
+	metadata := models.Item{
+		ServerMTime: time.Now().Unix(),
+		Kind:        models.ItemKind(c.PostForm("kind")),
+		LinkTarget:  c.PostForm("target"),
+	}
+
+	if formMTime := c.PostForm("mtime"); formMTime != "" {
+		if metadata.ServerMTime, err = strconv.ParseInt(formMTime, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mtime"})
+			return
+		}
+	}
+
+	if formSequence := c.PostForm("base_sequence"); formSequence != "" {
+		if metadata.Sequence, err = strconv.ParseInt(formSequence, 10, 64); err != nil || metadata.Sequence < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid base_sequence"})
+			return
+		}
+	}
+
+	if formMode := c.PostForm("mode"); formMode != "" {
+		mode, err := strconv.ParseUint(formMode, 8, 32)
+		if err != nil || mode > 0o777 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode"})
+			return
+		}
+
+		metadata.Mode = uint32(mode)
+	}
+
+	if !metadata.Kind.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid kind"})
+		return
+	}
+
+	if !metadata.IsFile() {
+		h.createEmptyItem(c, vault, metadata, len(form.File["item"]))
+		return
+	}
+
 	files := form.File["item"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No items uploaded"})
+		return
+	}
+
+	itemPath := c.PostForm("path")
+	if itemPath != "" && len(files) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path can only be set when uploading a single item"})
+		return
+	}
+
+	metadata.SHA256 = c.PostForm("sha256")
+	if metadata.SHA256 != "" && len(files) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SHA256 can only be set when uploading a single item"})
+		return
+	}
+
+	encoding := c.PostForm("encoding")
+	var size int64
+	if encoding != "" {
+		if encoding != compression.Zstd {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported encoding: %s", encoding)})
+			return
+		}
+
+		if len(files) > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only a single item can be uploaded compressed"})
+			return
+		}
+
+		if size, err = strconv.ParseInt(c.PostForm("size"), 10, 64); err != nil || size < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+			return
+		}
+	}
 
+	paths := make([]iops.VaultPath, 0, len(files))
 	for _, file := range files {
-		slog.Info("Uploading file", "filename", file.Filename)
-		if err := c.SaveUploadedFile(file, file.Filename); err != nil {
-			slog.Error("Error saving file", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file"})
+		filePath := itemPath
+		if filePath == "" {
+			filePath = file.Filename
+		}
+
+		vaultPath, err := iops.NewVaultPath(filePath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+
+		paths = append(paths, vaultPath)
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "File uploaded successfully"})
+	// A single file is answered with the status of saving it
+	if len(files) == 1 {
+		result := h.saveFile(c, vault, paths[0], files[0], metadata, encoding, size)
+		if result.Item == nil {
+			c.JSON(result.Status, result.body())
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"items": []*models.Item{result.Item}})
+		return
+	}
+
+	// Every file is saved on its own, so one failing does not stop the rest and the results say which ones were stored
+	items := make([]*models.Item, 0, len(files))
+	results := make([]uploadResult, 0, len(files))
+	failed := false
+
+	for index, file := range files {
+		result := h.saveFile(c, vault, paths[index], file, metadata, encoding, size)
+		if result.Item != nil {
+			items = append(items, result.Item)
+		} else {
+			failed = true
+		}
+
+		results = append(results, result)
+	}
+
+	if failed {
+		c.JSON(http.StatusMultiStatus, gin.H{"items": items, "results": results})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"items": items})
+}
+
+// uploadResult is the outcome of saving one of the uploaded files. Item is only set if it was stored.
+// Current is the item on the server, if the upload was refused because the item changed since the version it is based on
+type uploadResult struct {
+	Path    string       `json:"path"`
+	Status  int          `json:"status"`
+	Error   string       `json:"error,omitempty"`
+	Code    string       `json:"code,omitempty"`
+	Item    *models.Item `json:"item,omitempty"`
+	Current *models.Item `json:"current,omitempty"`
+}
+
+// body returns the response body for a single file that failed
+func (r uploadResult) body() gin.H {
+	body := gin.H{"error": r.Error}
+	if r.Code != "" {
+		body["code"] = r.Code
+	}
+
+	if r.Current != nil {
+		body["item"] = r.Current
+	}
+
+	return body
+}
+
+// saveFile will store a single uploaded file at the given path and return the outcome
+func (h *ItemHandler) saveFile(c *gin.Context, vault *models.Vault, itemPath iops.VaultPath, file *multipart.FileHeader, metadata models.Item, encoding string, size int64) uploadResult {
+	slog.Info("Uploading file", "filename", file.Filename)
+
+	result := uploadResult{Path: itemPath.String()}
+
+	fileMetadata := metadata
+	fileMetadata.Size = int(file.Size)
+
+	var reader io.ReadCloser
+	var err error
+	if encoding == compression.Zstd {
+		fileMetadata.Size = int(size)
+		reader, err = openCompressed(file, size)
+	} else {
+		reader, err = file.Open()
+	}
+
+	if errors.Is(err, compression.ErrSizeMismatch) {
+		result.Status, result.Error = http.StatusBadRequest, "Contents do not match the size field"
+		return result
+	}
+
+	if err != nil {
+		slog.Error("Error reading file", "error", err)
+		result.Status, result.Error = http.StatusInternalServerError, "Error reading file"
+		return result
+	}
+
+	item, err := h.Service.SaveItem(vault, itemPath, fileMetadata, reader, actorOf(c))
+	reader.Close()
+
+	switch {
+	case errors.Is(err, services.ErrQuotaExceeded):
+		result.Status, result.Error, result.Code = http.StatusRequestEntityTooLarge, err.Error(), rest.QuotaExceededCode
+	case errors.Is(err, services.ErrContentsMismatch):
+		result.Status, result.Error = http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrItemExists):
+		result.Status, result.Error = http.StatusConflict, err.Error()
+	case errors.Is(err, services.ErrItemChanged):
+		result.Status, result.Error, result.Current = http.StatusConflict, err.Error(), item
+	case err != nil:
+		slog.Error("Error saving file", "error", err)
+		result.Status, result.Error = http.StatusInternalServerError, "Error saving file"
+	default:
+		result.Status, result.Item = http.StatusCreated, item
+	}
+
+	return result
+}
+
+// openCompressed will decompress the uploaded file into a temporary file and open that.
+// Decompressing before anything is written to the vault means corrupt or oversized contents never replace the stored item
+func openCompressed(file *multipart.FileHeader, size int64) (io.ReadCloser, error) {
+	compressed, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer compressed.Close()
+
+	decompressor, err := compression.NewReader(compressed, size)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	tempFile, err := os.CreateTemp("", "gobi-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed := &tempFileReader{File: tempFile}
+
+	written, err := io.Copy(tempFile, decompressor)
+	if err == nil && written != size {
+		err = fmt.Errorf("%w: %d bytes, expected %d", compression.ErrSizeMismatch, written, size)
+	}
+
+	if err == nil {
+		_, err = tempFile.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		decompressed.Close()
+		return nil, err
+	}
+
+	slog.Debug("Decompressed upload", "filename", file.Filename, "bytes", written, "wireBytes", file.Size)
+
+	return decompressed, nil
+}
+
+// tempFileReader removes the temporary file once it is closed
+type tempFileReader struct {
+	*os.File
 }
 
-// DeleteItem will delete the item if it exists. If it does not exist, it will do nothing, but still return 200
+func (r *tempFileReader) Close() error {
+	err := r.File.Close()
+	_ = os.Remove(r.File.Name())
+
+	return err
+}
+
+// createEmptyItem will create a directory or a symlink at the `path` field. These items have no contents, so no files
+// can be uploaded with them
+func (h *ItemHandler) createEmptyItem(c *gin.Context, vault *models.Vault, metadata models.Item, files int) {
+	if files > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Files cannot be uploaded for a %s", metadata.Kind)})
+		return
+	}
+
+	itemPath, err := iops.NewVaultPath(c.PostForm("path"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if metadata.IsSymlink() {
+		if _, err := iops.ResolveLinkTarget(itemPath, metadata.LinkTarget); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	item, err := h.Service.SaveItem(vault, itemPath, metadata, nil, actorOf(c))
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		quotaExceeded(c, err)
+		return
+	}
+
+	if errors.Is(err, services.ErrItemExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if errors.Is(err, services.ErrItemChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "item": item})
+		return
+	}
+
+	if err != nil {
+		slog.Error("Error saving item", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving item"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"items": []*models.Item{item}})
+}
+
+// DeleteItem will delete the item given by the `path` query parameter and leave a tombstone, so the deletion is synced.
+// If it does not exist, it will do nothing, but still return 200
 func (h *ItemHandler) DeleteItem(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	itemPath, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteItem(vault, itemPath, actorOf(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to delete item: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// RenameItem will move the item given by the `path` query parameter to the one given by the `to` query parameter.
+// A tombstone is left at the old path. Directories cannot be renamed, their items have to be renamed instead
+// Returns 404 if the item does not exist and 409 if the new path is taken
+func (h *ItemHandler) RenameItem(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	from, ok := queryPath(c)
+	if !ok {
+		return
+	}
+
+	to, err := iops.NewVaultPath(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, err := h.Service.RenameItem(vault, from, to, actorOf(c))
+	switch {
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+	case errors.Is(err, services.ErrItemExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRenameDir):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to rename item: %w", err).Error()})
+	default:
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// limitBody will limit the size of the request body to what is left of the quotas, so uploads that cannot fit are
+// rejected before they are written to the disk. Some room is left for the multipart encoding and for replaced items
+func (h *ItemHandler) limitBody(c *gin.Context, vault *models.Vault) error {
+	if h.Service.Quota == nil || !h.Service.Quota.Enabled() {
+		return nil
+	}
+
+	usage, err := h.Service.Quota.GetUsage(vault)
+	if err != nil {
+		return err
+	}
+
+	if remaining := usage.Remaining(); remaining != -1 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, remaining+maxReplacedBytes)
+	}
+
+	return nil
+}
+
+// quotaExceeded will respond with 413 and the code clients use to recognize that the quota is full
+func quotaExceeded(c *gin.Context, err error) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "code": rest.QuotaExceededCode})
+}
+
+// queryPath will validate the `path` query parameter and return it as a VaultPath.
+// Responds with 400 and returns false if the path is not valid
+func queryPath(c *gin.Context) (iops.VaultPath, bool) {
+	itemPath, err := iops.NewVaultPath(c.Query("path"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	return itemPath, true
 }
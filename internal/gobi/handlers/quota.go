@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaHandler is the handler for the storage usage routes
+type QuotaHandler struct {
+	Service *services.QuotaService
+}
+
+// NewQuotaHandler will instantiate a new QuotaHandler given the QuotaService
+func NewQuotaHandler(service *services.QuotaService) *QuotaHandler {
+	return &QuotaHandler{
+		Service: service,
+	}
+}
+
+// GetUsage will return how many bytes the vault and its owner use, together with their quotas
+func (h *QuotaHandler) GetUsage(c *gin.Context) {
+	vault := c.MustGet("vault").(*models.Vault)
+
+	usage, err := h.Service.GetUsage(vault)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to calculate usage: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
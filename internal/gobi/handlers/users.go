@@ -1,47 +1,135 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/Michaelpalacce/gobi/internal/gobi/services"
 	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type UsersHandler struct {
-	Service *services.UsersService
+	Service          *services.UsersService
+	DeletionService  *services.UserDeletionService
+	WebsocketService *services.WebsocketService
+	AuditService     *services.AuditService
+
+	// OpenRegistration allows anyone to register. If disabled, only admins can create users
+	OpenRegistration bool
+}
+
+// registration contains the fields a user registers with
+type registration struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// passwordChange contains the current password of the user and the one it should be changed to
+type passwordChange struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
 }
 
-// NewUsersHandler will instantiate a new UsersHandler given the UserService
-func NewUsersHandler(service *services.UsersService) *UsersHandler {
+// NewUsersHandler will instantiate a new UsersHandler given the services it uses and whether anyone can register
+func NewUsersHandler(
+	service *services.UsersService,
+	deletionService *services.UserDeletionService,
+	websocketService *services.WebsocketService,
+	auditService *services.AuditService,
+	openRegistration bool,
+) *UsersHandler {
 	return &UsersHandler{
-		Service: service,
+		Service:          service,
+		DeletionService:  deletionService,
+		WebsocketService: websocketService,
+		AuditService:     auditService,
+		OpenRegistration: openRegistration,
 	}
 }
 
 // CreateUser will insert the given user in the database.
-// Returns 201 if the user is created successfully
-// The password will be hashed, so we don't store it
+// Returns 201 with the profile of the user if the user is created successfully and 403 if open registration is disabled
+// The password will be hashed, so we don't store it. Registered users are always regular users
 func (h UsersHandler) CreateUser(c *gin.Context) {
-	user := &models.User{}
+	if !h.OpenRegistration {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled, ask an admin to create a user"})
+		return
+	}
 
-	if err := c.ShouldBindJSON(user); err != nil {
+	var request registration
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind user: %w", err).Error()})
 		return
 	}
 
+	user := &models.User{
+		Username: request.Username,
+		Password: request.Password,
+		Role:     models.UserRoleUser,
+	}
+
 	if err := h.Service.CreateUser(user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to create user: %w", err).Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, bson.D{{Key: "_id", Value: user.ID}})
+	actor := models.ActorOf(*user, c.Request.UserAgent(), "", c.ClientIP())
+	h.AuditService.Record(accountEntry(actor, models.AuditActionUserCreated, user.Username))
+
+	c.JSON(http.StatusCreated, user.Profile())
+}
+
+// GetProfile will return the profile of the authenticated user. The password hash is never returned
+func (h UsersHandler) GetProfile(c *gin.Context) {
+	user, ok := c.MustGet("user").(*models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// ChangePassword will change the password of the authenticated user. The current password must be given as well.
+// All websocket sessions of the user are revoked, so clients have to reconnect with the new password
+// Returns 403 if the current password does not match
+func (h UsersHandler) ChangePassword(c *gin.Context) {
+	user, ok := c.MustGet("user").(*models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	var change passwordChange
+	if err := c.ShouldBindJSON(&change); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind password: %w", err).Error()})
+		return
+	}
+
+	if err := h.Service.ChangePassword(user, change.CurrentPassword, change.NewPassword); err != nil {
+		if errors.Is(err, services.ErrInvalidPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is invalid"})
+			return
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to change password: %w", err).Error()})
+		return
+	}
+
+	h.AuditService.Record(accountEntry(actorOf(c), models.AuditActionPasswordChanged, user.Username))
+
+	if err := h.WebsocketService.RevokeUser(user.Username, "Password was changed"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to revoke sessions: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
 }
 
-// DeleteUser will delete the user if it exists. If it does not exist, it will do nothing, but still return 200
-// TODO: Delete user files and metadata in the future
+// DeleteUser will delete the user together with their sessions, the vaults they own and all of their items
+// Returns 202, since the data is removed in the background
 func (h UsersHandler) DeleteUser(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -56,10 +144,12 @@ func (h UsersHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := h.Service.DeleteUser(userObject.ID.Hex()); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to delete user: %w", err).Error()})
+	if err := h.DeletionService.DeleteUser(userObject); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to delete user: %w", err).Error()})
 		return
 	}
 
-	c.Data(http.StatusOK, "application/json", []byte{})
+	h.AuditService.Record(accountEntry(actorOf(c), models.AuditActionUserDeleted, userObject.Username))
+
+	c.JSON(http.StatusAccepted, gin.H{})
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+type VaultsHandler struct {
+	Service *services.VaultsService
+}
+
+// NewVaultsHandler will instantiate a new VaultsHandler given the VaultsService
+func NewVaultsHandler(service *services.VaultsService) *VaultsHandler {
+	return &VaultsHandler{
+		Service: service,
+	}
+}
+
+// GetVaults will return all the vaults the user is a member of
+func (h VaultsHandler) GetVaults(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Assert the user type
+	userObject, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	vaults, err := h.Service.GetVaults(*userObject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error while trying to fetch vaults: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, vaults)
+}
+
+// CreateVault will create a new vault owned by the user.
+// Returns 201 if the vault is created successfully
+func (h VaultsHandler) CreateVault(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Assert the user type
+	userObject, ok := user.(*models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	request := &models.Vault{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind vault: %w", err).Error()})
+		return
+	}
+
+	vault, err := h.Service.CreateVault(*userObject, request.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to create vault: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, vault)
+}
+
+// AddMember will add a user to the vault with the given role, or update the role if they are already a member.
+// Expects the VaultAccess middleware to have set the vault
+func (h VaultsHandler) AddMember(c *gin.Context) {
+	vault, ok := c.MustGet("vault").(*models.Vault)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vault"})
+		return
+	}
+
+	member := &models.VaultMember{}
+	if err := c.ShouldBindJSON(member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to bind member: %w", err).Error()})
+		return
+	}
+
+	if err := h.Service.AddMember(vault, member.Username, member.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to add member: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// RemoveMember will remove a user from the vault. If they are not a member, it will do nothing, but still return 200
+// Expects the VaultAccess middleware to have set the vault
+func (h VaultsHandler) RemoveMember(c *gin.Context) {
+	vault, ok := c.MustGet("vault").(*models.Vault)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vault"})
+		return
+	}
+
+	if err := h.Service.RemoveMember(vault, c.Param("username")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error while trying to remove member: %w", err).Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
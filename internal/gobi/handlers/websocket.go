@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/Michaelpalacce/gobi/internal/gobi/services"
 	"github.com/Michaelpalacce/gobi/pkg/models"
@@ -13,15 +14,17 @@ import (
 type WebsocketHandler struct {
 	// Upgrader is used to upgrade a normal connection to a websocket
 	upgrader websocket.Upgrader
-	service  services.WebsocketService
+	service  *services.WebsocketService
 }
 
 // NewWebsocketHandler will instantiate a new WebsocketHandler
-func NewWebsocketHandler(service services.WebsocketService) *WebsocketHandler {
+func NewWebsocketHandler(service *services.WebsocketService) *WebsocketHandler {
 	return &WebsocketHandler{
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			// Messages are compressed with permessage-deflate, if the client supports it. Large manifests compress well
+			EnableCompression: true,
 		},
 		service: service,
 	}
@@ -42,6 +45,12 @@ func (h *WebsocketHandler) Establish(c *gin.Context) {
 		return
 	}
 
+	if reconnectDelay, shuttingDown := h.service.ShuttingDown(); shuttingDown {
+		c.Header("Retry-After", strconv.Itoa(int(reconnectDelay.Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error trying to upgrade connection to websocket: %w", err).Error()})
@@ -49,5 +58,5 @@ func (h *WebsocketHandler) Establish(c *gin.Context) {
 	}
 
 	// Handle the WebSocket connection (e.g., register the connection, manage clients, etc.)
-	go h.service.HandleConnection(conn, *userObject)
+	go h.service.HandleConnection(conn, *userObject, c.Request.UserAgent())
 }
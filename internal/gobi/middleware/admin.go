@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminOnly will only allow users with the admin role through. Must be used after the Auth middleware
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		userObject, ok := user.(*models.User)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			c.Abort()
+			return
+		}
+
+		if !userObject.IsAdmin() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can do this"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -1,22 +1,33 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
-	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Michaelpalacce/gobi/internal/gobi/services"
 	"github.com/Michaelpalacce/gobi/pkg/digest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// invalidCredentials is returned for every failed login, so responses do not reveal whether an account exists
+const invalidCredentials = "Invalid username or password"
+
 // This represents the current Authentication Strategy
-func Auth(userService *services.UsersService) gin.HandlerFunc {
-	return BasicAuth(userService)
+func Auth(userService *services.UsersService, limiter *services.AuthLimiter, auditService *services.AuditService) gin.HandlerFunc {
+	return BasicAuth(userService, limiter, auditService)
 }
 
-func BasicAuth(userService *services.UsersService) gin.HandlerFunc {
+// BasicAuth authenticates the user with the Authorization header.
+// Failed logins are throttled per IP and per username on the IP, locked out clients get 429 with a Retry-After header.
+// Every failed login is audited in the background, so failing logins cannot slow down the database
+func BasicAuth(userService *services.UsersService, limiter *services.AuthLimiter, auditService *services.AuditService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extract Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -51,17 +62,52 @@ func BasicAuth(userService *services.UsersService) gin.HandlerFunc {
 			return
 		}
 
+		ip := c.ClientIP()
+
+		// Locked out clients are rejected before touching the database
+		if retryAfter, locked := limiter.Locked(ip, credentials[0]); locked {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later"})
+			c.Abort()
+			return
+		}
+
 		user, err := userService.GetUserByName(credentials[0])
-		// Check if the user exists
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Error fetching user by that name %s", credentials[0])})
+		if err != nil && err != mongo.ErrNoDocuments {
+			slog.Error("Error while fetching user", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while authenticating"})
+			c.Abort()
+			return
+		}
+
+		// The hash is always compared, so a missing user takes as long as a wrong password
+		hash := ""
+		if user != nil {
+			hash = user.Password
+		}
+
+		matches := subtle.ConstantTimeCompare([]byte(digest.SHA256(credentials[1])), []byte(hash)) == 1
+		if !matches || user.Username != credentials[0] || user.Deleting {
+			limiter.Fail(ip, credentials[0])
+			slog.Warn("Failed login", "username", credentials[0], "ip", ip, "path", c.Request.URL.Path)
+
+			auditService.RecordAsync(models.AuditEntry{
+				Action:     models.AuditActionLoginFailed,
+				Username:   credentials[0],
+				Device:     c.Request.UserAgent(),
+				RemoteAddr: ip,
+				Path:       c.Request.URL.Path,
+			})
+
+			c.JSON(http.StatusUnauthorized, gin.H{"error": invalidCredentials})
 			c.Abort()
 			return
 		}
 
-		// Check if the provided credentials match the valid credentials
-		if credentials[0] != user.Username || digest.SHA256(credentials[1]) != user.Password {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		limiter.Succeed(ip, user.Username)
+
+		if user.Disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is disabled"})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
+	"github.com/Michaelpalacce/gobi/pkg/messages/v1/rest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Session will load the websocket session the request belongs to, if the client sent the session header.
+// Requests without the header are allowed, so the REST API can be used without a websocket connection.
+// Must be used after the VaultAccess middleware. Sets `session` in the context if one was found
+func Session() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionId := c.GetHeader(rest.SessionHeader)
+		if sessionId == "" {
+			c.Next()
+			return
+		}
+
+		user := c.MustGet("user").(*models.User)
+		vault := c.MustGet("vault").(*models.Vault)
+
+		s, err := session.GetSession(user.Username, vault.Name, sessionId)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+			c.Abort()
+			return
+		}
+
+		c.Set("session", s)
+
+		c.Next()
+	}
+}
+
+// AllowsUpload will reject requests coming from sessions that negotiated a download-only sync direction
+// Requests without the session header are rejected as well while the user holds a download-only session in the vault, so leaving
+// the header out does not get around the direction. The session ID is issued by the server, unlike headers such as the User-Agent
+// Must be used after the Session middleware
+func AllowsUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s, exists := c.Get("session"); exists {
+			if !s.(*session.Session).Client.CanUpload() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Uploads are not allowed from download-only clients"})
+				c.Abort()
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		user := c.MustGet("user").(*models.User)
+		vault := c.MustGet("vault").(*models.Vault)
+
+		downloadOnly, err := session.HasDownloadOnlySession(user.Username, vault.Name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sessions"})
+			c.Abort()
+			return
+		}
+
+		if downloadOnly {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User has a download-only session in the vault, send the %s header of a session that can upload", rest.SessionHeader)})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
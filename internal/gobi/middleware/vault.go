@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// VaultAccess will resolve the vault the request is for and make sure the user has the needed permissions in it.
+// The vault name is taken from the `vault` path parameter or, if missing, the `vault` query parameter.
+// Must be used after the Auth middleware. Sets `vault` and `role` in the context
+func VaultAccess(vaultsService *services.VaultsService, permission func(models.Role) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		userObject, ok := user.(*models.User)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			c.Abort()
+			return
+		}
+
+		vaultName := c.Param("vault")
+		if vaultName == "" {
+			vaultName = c.Query("vault")
+		}
+
+		if vaultName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing vault"})
+			c.Abort()
+			return
+		}
+
+		vault, err := vaultsService.GetVault(*userObject, vaultName)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vault not found"})
+			c.Abort()
+			return
+		}
+
+		role, _ := vault.RoleOf(userObject.ID.Hex())
+		if permission != nil && !permission(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions for this vault"})
+			c.Abort()
+			return
+		}
+
+		c.Set("vault", vault)
+		c.Set("role", role)
+
+		c.Next()
+	}
+}
+
+// CanRead allows every member of the vault
+func CanRead(models.Role) bool {
+	return true
+}
+
+// CanWrite allows only members that can modify the items of the vault
+func CanWrite(role models.Role) bool {
+	return role.CanWrite()
+}
+
+// CanManage allows only members that can manage the vault
+func CanManage(role models.Role) bool {
+	return role.CanManage()
+}
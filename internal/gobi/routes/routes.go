@@ -1,29 +1,79 @@
 package routes
 
 import (
+	"log/slog"
+	"os"
+	"strings"
+
 	"github.com/Michaelpalacce/gobi/internal/gobi/handlers"
 	"github.com/Michaelpalacce/gobi/internal/gobi/middleware"
+	"github.com/Michaelpalacce/gobi/internal/gobi/services"
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter configures the application routes.
 func SetupRouter(
 	userHandler handlers.UsersHandler,
+	vaultsHandler handlers.VaultsHandler,
 	websocketHandler handlers.WebsocketHandler,
 	itemHandler handlers.ItemHandler,
+	quotaHandler handlers.QuotaHandler,
+	adminHandler handlers.AdminHandler,
+	auditHandler handlers.AuditHandler,
+	authLimiter *services.AuthLimiter,
 ) *gin.Engine {
 	gin.SetMode(gin.DebugMode)
 	r := gin.Default()
 
+	// The client IP is used to throttle logins, so X-Forwarded-For is only believed when it comes from a trusted proxy
+	if err := r.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		slog.Error("Invalid trusted proxies, no proxy will be trusted", "error", err)
+		_ = r.SetTrustedProxies(nil)
+	}
+
 	v1 := r.Group("/api/v1")
 
-	authMiddleware := middleware.Auth(userHandler.Service)
+	authMiddleware := middleware.Auth(userHandler.Service, authLimiter, auditHandler.Service)
 
 	// User Routes
 	userRoutes := v1.Group("/users")
 	{
 		userRoutes.POST("/", userHandler.CreateUser)
 		userRoutes.DELETE("/", authMiddleware, userHandler.DeleteUser)
+		userRoutes.GET("/me", authMiddleware, userHandler.GetProfile)
+		userRoutes.PUT("/me/password", authMiddleware, userHandler.ChangePassword)
+	}
+
+	// Admin Routes
+	adminRoutes := v1.Group("/admin")
+	adminRoutes.Use(authMiddleware, middleware.AdminOnly())
+	{
+		adminRoutes.GET("/users", adminHandler.GetUsers)
+		adminRoutes.POST("/users", adminHandler.CreateUser)
+		adminRoutes.PATCH("/users/:username", adminHandler.UpdateUser)
+		adminRoutes.DELETE("/users/:username", adminHandler.DeleteUser)
+		adminRoutes.POST("/users/:username/password", adminHandler.ResetPassword)
+		adminRoutes.GET("/sessions", adminHandler.GetSessions)
+		adminRoutes.DELETE("/sessions/:session", adminHandler.DisconnectSession)
+		adminRoutes.GET("/audit", auditHandler.GetEntries)
+	}
+
+	// Vault Routes
+	vaultRoutes := v1.Group("/vaults")
+	vaultRoutes.Use(authMiddleware)
+	{
+		vaultRoutes.GET("/", vaultsHandler.GetVaults)
+		vaultRoutes.POST("/", vaultsHandler.CreateVault)
+		vaultRoutes.POST("/:vault/members", middleware.VaultAccess(vaultsHandler.Service, middleware.CanManage), vaultsHandler.AddMember)
+		vaultRoutes.DELETE("/:vault/members/:username", middleware.VaultAccess(vaultsHandler.Service, middleware.CanManage), vaultsHandler.RemoveMember)
+		vaultRoutes.GET("/:vault/usage", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), quotaHandler.GetUsage)
+	}
+
+	// Audit Routes
+	auditRoutes := v1.Group("/audit")
+	auditRoutes.Use(authMiddleware)
+	{
+		auditRoutes.GET("/", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), auditHandler.GetVaultEntries)
 	}
 
 	// Websocket Routes
@@ -37,10 +87,27 @@ func SetupRouter(
 	itemsRoutes := v1.Group("/items")
 	itemsRoutes.Use(authMiddleware)
 	{
-		itemsRoutes.GET("/", itemHandler.GetItem)
-		itemsRoutes.POST("/", itemHandler.CreateItem)
-		itemsRoutes.DELETE("/", itemHandler.DeleteItem)
+		itemsRoutes.GET("/list", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), itemHandler.ListItems)
+		itemsRoutes.GET("/stat", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), itemHandler.StatItem)
+		itemsRoutes.GET("/collisions", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), itemHandler.ListCollisions)
+		itemsRoutes.GET("/", middleware.VaultAccess(vaultsHandler.Service, middleware.CanRead), itemHandler.GetItem)
+		itemsRoutes.POST("/", middleware.VaultAccess(vaultsHandler.Service, middleware.CanWrite), middleware.Session(), middleware.AllowsUpload(), itemHandler.CreateItem)
+		itemsRoutes.DELETE("/", middleware.VaultAccess(vaultsHandler.Service, middleware.CanWrite), middleware.Session(), middleware.AllowsUpload(), itemHandler.DeleteItem)
+		itemsRoutes.POST("/rename", middleware.VaultAccess(vaultsHandler.Service, middleware.CanWrite), middleware.Session(), middleware.AllowsUpload(), itemHandler.RenameItem)
 	}
 
 	return r
 }
+
+// trustedProxiesFromEnv will read the comma separated IPs and CIDRs of the trusted proxies from the GOBI_TRUSTED_PROXIES
+// environment variable. No proxy is trusted if it is missing
+func trustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("GOBI_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/database"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultAuditLimit is how many entries are returned if no limit is given
+	defaultAuditLimit = 100
+	// maxAuditLimit is the most entries that can be returned at once
+	maxAuditLimit = 1000
+	// auditQueueSize is how many entries recorded with RecordAsync can wait to be written. Entries are dropped once it is full
+	auditQueueSize = 1024
+	// auditBatchSize is the most queued entries that are written at once
+	auditBatchSize = 100
+)
+
+// AuditService records who changed what in the Audit collection. The collection is append-only, entries are never
+// updated or removed, not even when the user or the vault they belong to is deleted
+type AuditService struct {
+	DB *database.Database
+
+	// queue holds the entries recorded with RecordAsync until they are written in batches
+	queue     chan models.AuditEntry
+	written   chan struct{}
+	closeOnce sync.Once
+}
+
+// AuditFilter narrows down which entries are returned. Empty fields match everything
+type AuditFilter struct {
+	VaultId  string
+	Username string
+	Action   models.AuditAction
+	// Path matches the item itself and everything inside of it, if it is a directory
+	Path string
+	// Since and Until are in unix milliseconds, both inclusive
+	Since int64
+	Until int64
+	Limit int64
+}
+
+// NewAuditService will instantiate a new AuditService given the database and start writing queued entries
+func NewAuditService(db *database.Database) *AuditService {
+	service := &AuditService{
+		DB:      db,
+		queue:   make(chan models.AuditEntry, auditQueueSize),
+		written: make(chan struct{}),
+	}
+
+	go service.writeQueued()
+
+	return service
+}
+
+// Record will store the entry in the audit log. Failing to record an entry is logged, but does not stop the action
+// that is being audited. Safe to call on a nil AuditService
+func (s *AuditService) Record(entry models.AuditEntry) {
+	if s == nil {
+		return
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.Time = time.Now().UnixMilli()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.DB.Collections.AuditCollection.InsertOne(ctx, entry); err != nil {
+		slog.Error("Error while recording audit entry", "action", entry.Action, "user", entry.Username, "error", err)
+	}
+}
+
+// RecordAsync will queue the entry to be written in the background. Use this for entries anyone can cause, like failed
+// logins, so a flood of them cannot slow down the requests or the database. Entries are dropped if the queue is full.
+// Safe to call on a nil AuditService
+func (s *AuditService) RecordAsync(entry models.AuditEntry) {
+	if s == nil {
+		return
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.Time = time.Now().UnixMilli()
+
+	select {
+	case s.queue <- entry:
+	default:
+		slog.Warn("Audit queue is full, dropping entry", "action", entry.Action, "user", entry.Username)
+	}
+}
+
+// Close will write the queued entries and wait until they are written or the context is done.
+// Nothing can be recorded with RecordAsync afterwards
+func (s *AuditService) Close(ctx context.Context) {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+	})
+
+	select {
+	case <-s.written:
+	case <-ctx.Done():
+		slog.Warn("Timed out writing queued audit entries")
+	}
+}
+
+// writeQueued will write the queued entries in batches until the queue is closed
+func (s *AuditService) writeQueued() {
+	defer close(s.written)
+
+	for entry := range s.queue {
+		batch := []interface{}{entry}
+
+	fill:
+		for len(batch) < auditBatchSize {
+			select {
+			case next, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := s.DB.Collections.AuditCollection.InsertMany(ctx, batch); err != nil {
+			slog.Error("Error while recording audit entries", "entries", len(batch), "error", err)
+		}
+		cancel()
+	}
+}
+
+// Find will return the entries that match the filter, newest first
+func (s *AuditService) Find(filter AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetLimit(limit)
+
+	cursor, err := s.DB.Collections.AuditCollection.Find(ctx, filter.query(), findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.AuditEntry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// query returns the mongo filter for the AuditFilter
+func (f AuditFilter) query() bson.D {
+	query := bson.D{}
+
+	if f.VaultId != "" {
+		query = append(query, bson.E{Key: "vault_id", Value: f.VaultId})
+	}
+
+	if f.Username != "" {
+		query = append(query, bson.E{Key: "username", Value: f.Username})
+	}
+
+	if f.Action != "" {
+		query = append(query, bson.E{Key: "action", Value: f.Action})
+	}
+
+	if f.Path != "" {
+		query = append(query, bson.E{Key: "path", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(f.Path) + "(/|$)"}}})
+	}
+
+	timeRange := bson.D{}
+	if f.Since > 0 {
+		timeRange = append(timeRange, bson.E{Key: "$gte", Value: f.Since})
+	}
+
+	if f.Until > 0 {
+		timeRange = append(timeRange, bson.E{Key: "$lte", Value: f.Until})
+	}
+
+	if len(timeRange) > 0 {
+		query = append(query, bson.E{Key: "time", Value: timeRange})
+	}
+
+	return query
+}
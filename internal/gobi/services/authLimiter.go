@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/redis"
+)
+
+// AuthLimiter throttles failed logins per IP, per username from an IP and per username from all IPs, using redis so the limits
+// are shared between servers. Once too many logins failed within the lockout duration, the IP or the username on that IP is
+// locked out until it passes. Guesses spread over many IPs are caught by the account limit, which only delays the next login
+// of the username, doubling the delay with every further failure up to MaxAccountDelay. It is never a lockout, so nobody can
+// lock the owner of an account out from somewhere else.
+// Usernames are limited whether they exist or not, so a lockout does not reveal if an account exists
+type AuthLimiter struct {
+	// MaxAttemptsPerUser is how many failed logins a username can have from one IP before it is locked out on that IP. Zero means no limit
+	MaxAttemptsPerUser int64
+	// MaxAttemptsPerIP is how many failed logins an IP can have before it is locked out.
+	// Should be higher than MaxAttemptsPerUser, since many users can share an IP
+	MaxAttemptsPerIP int64
+	// MaxAttemptsPerAccount is how many failed logins a username can have from all IPs before its logins are delayed. Zero means no limit
+	MaxAttemptsPerAccount int64
+	// MaxAccountDelay is the longest a login to an account is delayed
+	MaxAccountDelay time.Duration
+	// Lockout is both the window in which failed logins are counted and how long a lockout lasts. Zero disables lockouts.
+	// Failed logins of an account are counted until there were none for this long
+	Lockout time.Duration
+}
+
+// NewAuthLimiter will instantiate a new AuthLimiter.
+// The limits are read from the GOBI_AUTH_MAX_ATTEMPTS_PER_USER, GOBI_AUTH_MAX_ATTEMPTS_PER_IP, GOBI_AUTH_MAX_ATTEMPTS_PER_ACCOUNT,
+// GOBI_AUTH_MAX_ACCOUNT_DELAY_SECONDS and GOBI_AUTH_LOCKOUT_SECONDS environment variables
+func NewAuthLimiter() *AuthLimiter {
+	return &AuthLimiter{
+		MaxAttemptsPerUser:    limitFromEnv("GOBI_AUTH_MAX_ATTEMPTS_PER_USER", 5),
+		MaxAttemptsPerIP:      limitFromEnv("GOBI_AUTH_MAX_ATTEMPTS_PER_IP", 20),
+		MaxAttemptsPerAccount: limitFromEnv("GOBI_AUTH_MAX_ATTEMPTS_PER_ACCOUNT", 10),
+		MaxAccountDelay:       time.Duration(limitFromEnv("GOBI_AUTH_MAX_ACCOUNT_DELAY_SECONDS", 60)) * time.Second,
+		Lockout:               time.Duration(limitFromEnv("GOBI_AUTH_LOCKOUT_SECONDS", 900)) * time.Second,
+	}
+}
+
+// Locked returns how long until the IP or the username on the IP can try to log in again, or the delay of the account has passed,
+// and whether they have to wait. If redis cannot be reached, nobody is locked out, so users can still log in
+func (l AuthLimiter) Locked(ip string, username string) (time.Duration, bool) {
+	var retryAfter time.Duration
+
+	for _, key := range []string{lockKey("ip", ip), lockKey("user", userOnIP(username, ip)), lockKey("account", username)} {
+		ttl, err := redis.TTL(key)
+		if err != nil {
+			slog.Error("Error while checking login lockout", "error", err)
+			continue
+		}
+
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+
+	return retryAfter, retryAfter > 0
+}
+
+// Fail will record a failed login for the IP, the username on the IP and the account. The IP and the username on the IP are
+// locked out if they went over the limit, the account is delayed
+func (l AuthLimiter) Fail(ip string, username string) {
+	l.fail("ip", ip, l.MaxAttemptsPerIP)
+	l.fail("user", userOnIP(username, ip), l.MaxAttemptsPerUser)
+	l.delayAccount(username)
+}
+
+// Succeed will reset the failed logins of the username on the IP and of the account. The failed logins of the IP are kept,
+// so an attacker cannot reset them by logging in to their own account
+func (l AuthLimiter) Succeed(ip string, username string) {
+	if err := redis.Del(attemptsKey("user", userOnIP(username, ip)), attemptsKey("account", username)); err != nil {
+		slog.Error("Error while resetting failed logins", "error", err)
+	}
+}
+
+// delayAccount will count a failed login of the account from any IP. Once it reaches the maximum number of attempts, every
+// further failure delays the next login of the account, twice as long as the one before, up to MaxAccountDelay
+func (l AuthLimiter) delayAccount(username string) {
+	if l.MaxAttemptsPerAccount <= 0 || l.MaxAccountDelay <= 0 || l.Lockout <= 0 {
+		return
+	}
+
+	key := attemptsKey("account", username)
+
+	attempts, err := redis.Incr(key)
+	if err != nil {
+		slog.Error("Error while counting failed logins", "error", err)
+		return
+	}
+
+	// Slow guesses must not outlast the window, so it starts over with every failure
+	if err := redis.Expire(key, l.Lockout); err != nil {
+		slog.Error("Error while counting failed logins", "error", err)
+	}
+
+	if attempts < l.MaxAttemptsPerAccount {
+		return
+	}
+
+	delay := accountDelay(attempts-l.MaxAttemptsPerAccount, l.MaxAccountDelay)
+	slog.Warn("Delaying logins after too many failed logins", "username", username, "attempts", attempts, "delay", delay)
+
+	if err := redis.Set(lockKey("account", username), attempts, delay); err != nil {
+		slog.Error("Error while delaying logins", "error", err)
+	}
+}
+
+// accountDelay returns how long to delay the next login after the given number of failures over the limit.
+// Starts at a second and doubles with every failure, up to the maximum
+func accountDelay(overLimit int64, maxDelay time.Duration) time.Duration {
+	if overLimit >= 32 {
+		return maxDelay
+	}
+
+	return min(time.Second<<overLimit, maxDelay)
+}
+
+// fail will count a failed login for the given key and lock it out once it reaches the maximum number of attempts
+func (l AuthLimiter) fail(kind string, value string, maxAttempts int64) {
+	if maxAttempts <= 0 || l.Lockout <= 0 {
+		return
+	}
+
+	key := attemptsKey(kind, value)
+
+	attempts, err := redis.Incr(key)
+	if err != nil {
+		slog.Error("Error while counting failed logins", "error", err)
+		return
+	}
+
+	if attempts == 1 {
+		if err := redis.Expire(key, l.Lockout); err != nil {
+			slog.Error("Error while counting failed logins", "error", err)
+		}
+	}
+
+	if attempts < maxAttempts {
+		return
+	}
+
+	slog.Warn("Locking out after too many failed logins", kind, value, "attempts", attempts, "lockout", l.Lockout)
+
+	if err := redis.Set(lockKey(kind, value), attempts, l.Lockout); err != nil {
+		slog.Error("Error while locking out", "error", err)
+	}
+
+	if err := redis.Del(key); err != nil {
+		slog.Error("Error while resetting failed logins", "error", err)
+	}
+}
+
+// userOnIP returns the value the failed logins of the username on the IP are counted under
+func userOnIP(username string, ip string) string {
+	return fmt.Sprintf("%s@%s", username, ip)
+}
+
+// attemptsKey returns the redis key under which the failed logins are counted
+func attemptsKey(kind string, value string) string {
+	return fmt.Sprintf("auth-attempts:%s:%s", kind, value)
+}
+
+// lockKey returns the redis key that is set while logins are locked out
+func lockKey(kind string, value string) string {
+	return fmt.Sprintf("auth-lock:%s:%s", kind, value)
+}
+
+// limitFromEnv will read a positive number from the given environment variable. Invalid or missing values use the default
+func limitFromEnv(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		slog.Warn("Invalid limit, using the default", "variable", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+
+	return limit
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/database"
+	"github.com/Michaelpalacce/gobi/pkg/redis"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// eventLockExpiration is how long the event log of a vault stays locked if the server holding the lock dies.
+	// Must be longer than eventAppendTimeout, so the lock cannot expire while an event is being appended
+	eventLockExpiration = 10 * time.Second
+	// eventAppendTimeout is how long assigning the sequence and storing an event may take together
+	eventAppendTimeout = 5 * time.Second
+	// eventLockTimeout is how long we wait for the event log of a vault to be unlocked before giving up
+	eventLockTimeout = 5 * time.Second
+)
+
+// EventService is the event log of every vault, stored in the Events collection.
+// Sequence numbers are handed out by a counter per vault in the Sequences collection. Appends to the same vault are
+// serialized with a redis lock, so an event is always stored before one with a bigger sequence, even across servers.
+// Otherwise a client could see sequence 6 before 5 is stored and skip 5 forever
+type EventService struct {
+	DB *database.Database
+}
+
+var _ storage.EventStore = (*EventService)(nil)
+
+// sequenceCounter is the document in the Sequences collection that holds the last sequence of a vault
+type sequenceCounter struct {
+	VaultId  string `bson:"_id"`
+	Sequence int64  `bson:"sequence"`
+}
+
+// NewEventService will instantiate a new EventService given the database and make sure the event log is indexed
+func NewEventService(db *database.Database) *EventService {
+	service := &EventService{
+		DB: db,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "vault_id", Value: 1}, {Key: "sequence", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	if _, err := db.Collections.EventsCollection.Indexes().CreateOne(ctx, index); err != nil {
+		slog.Error("Error while creating the event log index", "error", err)
+	}
+
+	return service
+}
+
+// Append will record the event with the next sequence number of its vault and return the recorded event
+func (s *EventService) Append(event storage.Event) (*storage.Event, error) {
+	unlock, err := s.lock(event.VaultId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventAppendTimeout)
+	defer cancel()
+
+	counter := sequenceCounter{}
+	err = s.DB.Collections.SequencesCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{{Key: "_id", Value: event.VaultId}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "sequence", Value: 1}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return nil, fmt.Errorf("error while assigning sequence: %w", err)
+	}
+
+	event.Sequence = counter.Sequence
+	event.Item.Sequence = counter.Sequence
+	event.Time = time.Now().UnixMilli()
+
+	if _, err := s.DB.Collections.EventsCollection.InsertOne(ctx, event); err != nil {
+		return nil, fmt.Errorf("error while appending event: %w", err)
+	}
+
+	return &event, nil
+}
+
+// EventsSince returns up to limit events of the vault with a sequence bigger than the given one, in order
+func (s *EventService) EventsSince(vaultId string, sequence int64, limit int64) ([]storage.Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "vault_id", Value: vaultId},
+		{Key: "sequence", Value: bson.D{{Key: "$gt", Value: sequence}}},
+	}
+
+	cursor, err := s.DB.Collections.EventsCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]storage.Event, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// LastSequence returns the sequence of the last event of the vault, or 0 if there are none
+func (s *EventService) LastSequence(vaultId string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counter := sequenceCounter{}
+	err := s.DB.Collections.SequencesCollection.FindOne(ctx, bson.D{{Key: "_id", Value: vaultId}}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.Sequence, nil
+}
+
+// lock will wait until the event log of the vault can be locked and return a function that unlocks it
+func (s *EventService) lock(vaultId string) (func(), error) {
+	key := fmt.Sprintf("vault-events:%s", vaultId)
+	deadline := time.Now().Add(eventLockTimeout)
+
+	for {
+		token, err := redis.Lock(key, eventLockExpiration)
+		if err != nil {
+			return nil, fmt.Errorf("error while locking event log: %w", err)
+		}
+
+		if token != "" {
+			return func() {
+				if err := redis.Unlock(key, token); err != nil {
+					slog.Error("Error while unlocking event log", "vault", vaultId, "error", err)
+				}
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the event log of vault %s", vaultId)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
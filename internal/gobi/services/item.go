@@ -1,8 +1,932 @@
 package services
 
-type ItemService struct{}
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
-// NewItemService will instantiate a new ItemService given the database
-func NewItemService() *ItemService {
-	return &ItemService{}
+	"github.com/Michaelpalacce/gobi/pkg/database"
+	"github.com/Michaelpalacce/gobi/pkg/digest"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/pubsub"
+	"github.com/Michaelpalacce/gobi/pkg/iops"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrNotAFile is returned when trying to read the contents of a directory or a symlink
+	ErrNotAFile = errors.New("item is not a file")
+	// ErrItemExists is returned when renaming an item to a path that is already taken
+	ErrItemExists = errors.New("item already exists")
+	// ErrRenameDir is returned when trying to rename a directory. Rename the items inside of it instead
+	ErrRenameDir = errors.New("directories cannot be renamed")
+	// ErrContentsMismatch is returned when the uploaded contents do not match the size or the SHA256 the client sent
+	ErrContentsMismatch = errors.New("contents do not match the metadata")
+	// ErrItemChanged is returned when saving over an item that changed since the version the change is based on
+	ErrItemChanged = errors.New("item changed since the version the change is based on")
+)
+
+const (
+	// eventPageSize is how many events are fetched from the event log at once
+	eventPageSize int64 = 1000
+	// maxReplayEvents is how many events a client can be behind, before it gets the whole vault instead
+	maxReplayEvents = 10000
+	// repairWindow is how many of the last events of a vault are checked against the metadata by RepairItems
+	repairWindow int64 = 1000
+)
+
+// ItemService handles the items in a vault.
+// The files themselves are handled by the storage driver, while the metadata is stored in the Items collection
+// Every change is appended to the event log of the vault before the storage and the metadata are changed. The event log is the
+// source of truth, if storing the metadata fails or the server stops in between, RepairItems replays the events onto the metadata
+type ItemService struct {
+	DB     *database.Database
+	Quota  *QuotaService
+	Audit  *AuditService
+	Events storage.EventStore
+}
+
+// NewItemService will instantiate a new ItemService given the database, the QuotaService that limits uploads, the
+// AuditService that records every change and the EventStore that holds the event log
+// Items stored before paths were normalized are backfilled and the normalized paths are indexed
+func NewItemService(db *database.Database, quota *QuotaService, audit *AuditService, events storage.EventStore) *ItemService {
+	service := &ItemService{
+		DB:     db,
+		Quota:  quota,
+		Audit:  audit,
+		Events: events,
+	}
+
+	if err := service.backfillNormalizedPaths(); err != nil {
+		slog.Error("Error while backfilling normalized paths", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Only one item that is not deleted can have a normalized path, so concurrent uploads cannot both pass resolveCollision
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "vault_id", Value: 1}, {Key: "normalized_path", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{
+			{Key: "deleted", Value: false},
+			{Key: "normalized_path", Value: bson.D{{Key: "$exists", Value: true}}},
+		}),
+	}
+
+	if _, err := db.Collections.ItemCollection.Indexes().CreateOne(ctx, index); err != nil {
+		slog.Error("Error while creating the normalized path index, resolve the collisions listed by /items/collisions and restart", "error", err)
+	}
+
+	return service
+}
+
+// backfillNormalizedPaths will set the normalized path of items that were stored without one
+func (s ItemService) backfillNormalizedPaths() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := bson.D{{Key: "normalized_path", Value: bson.D{{Key: "$exists", Value: false}}}}
+	projection := options.Find().SetProjection(bson.D{{Key: "server_path", Value: 1}})
+
+	cursor, err := s.DB.Collections.ItemCollection.Find(ctx, filter, projection)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var item struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			ServerPath string             `bson:"server_path"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return err
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "normalized_path", Value: iops.NormalizePath(item.ServerPath)}}}}
+		if _, err := s.DB.Collections.ItemCollection.UpdateByID(ctx, item.ID, update); err != nil {
+			return err
+		}
+
+		count++
+	}
+
+	if count > 0 {
+		slog.Info("Backfilled normalized paths", "items", count)
+	}
+
+	return cursor.Err()
+}
+
+// GetChangesSince will return the items in the vault that changed since the client last synced, including deleted ones,
+// together with the sequence the client should sync from next time.
+// Clients that have a sequence get the events after it, squashed into one item per path.
+// Clients without one get the items with a mtime after lastSync, so 0 gets the whole vault.
+// If the sequence is ahead of the event log, it is from a log that no longer exists and the whole vault is sent.
+// The events are fetched in pages. Clients that are more than maxReplayEvents behind get the whole vault as well
+func (s ItemService) GetChangesSince(vaultId string, sequence int64, lastSync int64) ([]models.Item, int64, error) {
+	if s.Events == nil {
+		items, err := s.GetItemsSince(vaultId, lastSync)
+		return items, 0, err
+	}
+
+	// The last sequence is read before the items, so any change made in between is sent again next time instead of lost
+	lastSequence, err := s.Events.LastSequence(vaultId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching last sequence: %w", err)
+	}
+
+	if sequence > lastSequence {
+		slog.Warn("Client is ahead of the event log, sending the whole vault", "vault", vaultId, "sequence", sequence, "lastSequence", lastSequence)
+		sequence, lastSync = 0, 0
+	}
+
+	if sequence == 0 {
+		items, err := s.GetItemsSince(vaultId, lastSync)
+		return items, lastSequence, err
+	}
+
+	events := make([]storage.Event, 0)
+	for after := sequence; ; {
+		page, err := s.Events.EventsSince(vaultId, after, eventPageSize)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error fetching events: %w", err)
+		}
+
+		events = append(events, page...)
+		if int64(len(page)) < eventPageSize {
+			break
+		}
+
+		// Replaying this many events costs more than sending the metadata of the whole vault
+		if len(events) >= maxReplayEvents {
+			slog.Info("Client is too far behind the event log, sending the whole vault", "vault", vaultId, "sequence", sequence, "lastSequence", lastSequence)
+			items, err := s.GetItemsSince(vaultId, 0)
+			return items, lastSequence, err
+		}
+
+		after = page[len(page)-1].Sequence
+	}
+
+	if len(events) > 0 {
+		lastSequence = events[len(events)-1].Sequence
+	}
+
+	return storage.Replay(events), lastSequence, nil
+}
+
+// GetItemsSince will return all items in the vault that changed since the given time, including deleted ones
+func (s ItemService) GetItemsSince(vaultId string, since int64) ([]models.Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "vault_id", Value: vaultId},
+		{Key: "server_m_time", Value: bson.D{{Key: "$gte", Value: since}}},
+	}
+
+	cursor, err := s.DB.Collections.ItemCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.Item, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// IndexStorage will record the metadata of the items in the storage directory that have none, as created items of the vault.
+// The directory is given separately, so vaults can be indexed before their directory is moved to the ID of the vault.
+// Items that already have metadata are skipped, so it is safe to call more than once. Returns how many items were indexed
+func (s ItemService) IndexStorage(vault *models.Vault, storageName string) (int, error) {
+	storageDriver, err := storage.NewLocalDriver(storageName)
+	if err != nil {
+		return 0, err
+	}
+
+	storageDriver.EnqueueItemsSince(0, storageName)
+
+	indexed := 0
+	for _, item := range storageDriver.GetAllItems(storage.ConflictModeNo) {
+		itemPath, err := iops.NewVaultPath(item.ServerPath)
+		if err != nil {
+			slog.Warn("Skipping item with invalid path", "vault", vault.ID, "path", item.ServerPath, "error", err)
+			continue
+		}
+
+		if _, err := s.GetItem(vault, itemPath); err == nil {
+			continue
+		} else if err != mongo.ErrNoDocuments {
+			return indexed, err
+		}
+
+		item.OwnerId = vaultOwner(vault)
+		item.VaultId = vault.ID.Hex()
+
+		if err := s.appendEvent(storage.EventCreate, &item, ""); err != nil {
+			return indexed, err
+		}
+
+		if err := s.upsertItem(&item); err != nil {
+			return indexed, err
+		}
+
+		indexed++
+	}
+
+	return indexed, nil
+}
+
+// GetUsage will return how much storage the vault and its owner use, together with their quotas
+func (s ItemService) GetUsage(vault *models.Vault) (*models.QuotaUsage, error) {
+	if s.Quota == nil {
+		return &models.QuotaUsage{}, nil
+	}
+
+	return s.Quota.GetUsage(vault)
+}
+
+// GetItem will return the metadata of the item at the given path.
+// Returns mongo.ErrNoDocuments if the item does not exist or was deleted
+func (s ItemService) GetItem(vault *models.Vault, itemPath iops.VaultPath) (*models.Item, error) {
+	item, err := s.getItemIncludingDeleted(vault, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.Deleted {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return item, nil
+}
+
+// getItemIncludingDeleted will return the metadata of the item at the given path, which is the tombstone if it was deleted.
+// Returns mongo.ErrNoDocuments if there never was an item at the path
+func (s ItemService) getItemIncludingDeleted(vault *models.Vault, itemPath iops.VaultPath) (*models.Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item := &models.Item{}
+	if err := s.DB.Collections.ItemCollection.FindOne(ctx, itemFilter(vault, itemPath)).Decode(item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// ListItems will return the items and the subdirectories in the given directory.
+// An empty directory is the root of the vault. If recursive is set, all items under the directory are returned and no subdirectories
+func (s ItemService) ListItems(vault *models.Vault, dir iops.VaultPath, recursive bool) ([]models.Item, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	prefix := dir.String()
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	filter := bson.D{
+		{Key: "vault_id", Value: vault.ID.Hex()},
+		{Key: "deleted", Value: false},
+		{Key: "server_path", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}},
+	}
+
+	cursor, err := s.DB.Collections.ItemCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "server_path", Value: 1}}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	all := make([]models.Item, 0)
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, nil, err
+	}
+
+	if recursive {
+		return all, []string{}, nil
+	}
+
+	items := make([]models.Item, 0)
+	dirs := make(map[string]bool)
+
+	for _, item := range all {
+		rest := strings.TrimPrefix(item.ServerPath, prefix)
+		if index := strings.Index(rest, "/"); index != -1 {
+			dirs[prefix+rest[:index]] = true
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	directories := make([]string, 0, len(dirs))
+	for directory := range dirs {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+
+	return items, directories, nil
+}
+
+// OpenItem will return the metadata of the item together with a reader for its contents.
+// Returns ErrNotAFile for directories and symlinks. The caller is responsible for closing the reader
+func (s ItemService) OpenItem(vault *models.Vault, itemPath iops.VaultPath) (*models.Item, io.ReadCloser, error) {
+	item, err := s.GetItem(vault, itemPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !item.IsFile() {
+		return nil, nil, ErrNotAFile
+	}
+
+	storageDriver, err := storage.NewLocalDriver(vault.ID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := storageDriver.GetReader(*item)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return item, reader, nil
+}
+
+// SaveItem will store the item at the given path and record the metadata.
+// The modification time, kind, mode and symlink target are taken from the given metadata. For files, the contents are
+// read from the reader, directories and symlinks have none and the reader is ignored. Files are only replaced once their contents
+// were checked against the size and SHA256 in the metadata, returns ErrContentsMismatch otherwise.
+// The size in the metadata is checked against the quotas and reserved before anything is written. Returns ErrQuotaExceeded if it does not fit
+// If the path collides with another item that only differs in case or unicode normalization, the item is renamed.
+// The sequence in the metadata is the version the change is based on. If the item changed or was deleted since then, or the change
+// is not based on any version of an existing item, nothing is stored and the current item or its tombstone is returned together with ErrItemChanged.
+// All connected clients of the vault, except the session of the actor, are notified of the change and it is audited.
+// If the item did not change, nobody is notified
+func (s ItemService) SaveItem(vault *models.Vault, itemPath iops.VaultPath, metadata models.Item, reader io.Reader, actor models.Actor) (*models.Item, error) {
+	item := &models.Item{
+		OwnerId:     vaultOwner(vault),
+		VaultId:     vault.ID.Hex(),
+		ServerPath:  itemPath.String(),
+		ServerMTime: metadata.ServerMTime,
+		Kind:        metadata.Kind,
+		Mode:        metadata.Mode,
+		LinkTarget:  metadata.LinkTarget,
+	}
+
+	if item.Kind == "" {
+		item.Kind = models.ItemKindFile
+	}
+
+	if !item.Kind.Valid() {
+		return nil, fmt.Errorf("unknown item kind: %s", item.Kind)
+	}
+
+	if !item.IsSymlink() {
+		item.LinkTarget = ""
+	}
+
+	// stored is the tombstone if the item was deleted, existing is only set if the item was not
+	stored, err := s.getItemIncludingDeleted(vault, itemPath)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	var existing *models.Item
+	if stored != nil && !stored.Deleted {
+		existing = stored
+	}
+
+	// The base of the upload is checked against the item at the path it was uploaded to, even if it is renamed below
+	uploadedOver := stored
+
+	// Replacing an item keeps its path, only new items can collide with another one
+	if existing == nil {
+		resolvedPath, err := s.resolveCollision(vault, itemPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if resolvedPath != itemPath {
+			slog.Warn("Item collides with an existing one, renaming it", "vault", vault.ID, "path", itemPath, "renamed", resolvedPath)
+
+			entry := actor.Entry(models.AuditActionConflict)
+			entry.VaultId = vault.ID.Hex()
+			entry.Path = itemPath.String()
+			entry.Details = fmt.Sprintf("collides with an existing item, renamed to %s", resolvedPath)
+			s.Audit.Record(entry)
+
+			itemPath = resolvedPath
+			item.ServerPath = itemPath.String()
+
+			// The item is saved over whatever was deleted at the new path, not at the one it was uploaded to
+			if stored, err = s.getItemIncludingDeleted(vault, itemPath); err != nil && err != mongo.ErrNoDocuments {
+				return nil, err
+			}
+		}
+	}
+
+	release, err := s.reserveQuota(vault, *item, metadata.Size, existing)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	slog.Info("Saving item", "vault", vault.ID, "path", item.ServerPath)
+
+	storageDriver, err := storage.NewLocalDriver(vault.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	var writer storage.ItemWriter
+	if item.IsFile() {
+		if writer, err = writeFile(storageDriver, item, metadata, reader); err != nil {
+			return nil, err
+		}
+		defer writer.Close()
+	} else if item.IsSymlink() {
+		item.SHA256 = digest.SHA256(item.LinkTarget)
+		item.Size = len(item.LinkTarget)
+	}
+
+	if existing != nil && existing.SameContent(*item) {
+		return existing, nil
+	}
+
+	if err := checkBase(uploadedOver, metadata.Sequence); err != nil {
+		slog.Warn("Item was changed since the version the upload is based on", "vault", vault.ID, "path", item.ServerPath, "base", metadata.Sequence, "sequence", uploadedOver.Sequence)
+
+		entry := actor.Entry(models.AuditActionConflict)
+		entry.VaultId = vault.ID.Hex()
+		entry.Path = item.ServerPath
+		entry.SHA256Before = uploadedOver.SHA256
+		entry.SHA256After = item.SHA256
+		entry.Details = fmt.Sprintf("based on sequence %d, refused over sequence %d", metadata.Sequence, uploadedOver.Sequence)
+		s.Audit.Record(entry)
+
+		return uploadedOver, err
+	}
+
+	eventType := storage.EventUpdate
+	if existing == nil {
+		eventType = storage.EventCreate
+	}
+
+	// The contents only replace the item once the change is in the event log, so a failed append changes nothing
+	if err := s.appendEvent(eventType, item, ""); err != nil {
+		return nil, err
+	}
+
+	if writer != nil {
+		if err := writer.Commit(); err != nil {
+			return nil, fmt.Errorf("error writing item: %w", err)
+		}
+	} else if err := storageDriver.Apply(*item); err != nil {
+		return nil, err
+	}
+
+	if err := storageDriver.Touch(*item); err != nil {
+		return nil, fmt.Errorf("error setting mtime of item: %w", err)
+	}
+
+	if err := s.upsertItem(item); err != nil {
+		return nil, err
+	}
+
+	s.Audit.Record(saveEntry(actor, item, stored))
+
+	if err := pubsub.PublishItemChange(vault.ID.Hex(), actor.SessionId, *item); err != nil {
+		slog.Error("Error notifying clients of item change", "error", err)
+	}
+
+	return item, nil
+}
+
+// writeFile will write the contents of the item and set its size and SHA256.
+// The contents must match the size in the metadata and its SHA256, if one was given. The returned writer is not committed yet,
+// the caller commits it once the change is recorded and closes it either way
+func writeFile(storageDriver storage.Driver, item *models.Item, metadata models.Item, reader io.Reader) (storage.ItemWriter, error) {
+	writer, err := storageDriver.GetWriter(*item)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hasher), reader)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("error writing item: %w", err)
+	}
+
+	item.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	item.Size = int(size)
+
+	if item.Size != metadata.Size {
+		writer.Close()
+		return nil, fmt.Errorf("%w: %d bytes, expected %d", ErrContentsMismatch, item.Size, metadata.Size)
+	}
+
+	if metadata.SHA256 != "" && item.SHA256 != metadata.SHA256 {
+		writer.Close()
+		return nil, fmt.Errorf("%w: SHA256 %s, expected %s", ErrContentsMismatch, item.SHA256, metadata.SHA256)
+	}
+
+	return writer, nil
+}
+
+// checkBase returns ErrItemChanged if the stored item, which can be a tombstone, changed since the base sequence.
+// Items stored before the event log have no sequence and can be replaced without one.
+// Uploads without a base over a tombstone create the item anew, with a base they must have seen the delete
+func checkBase(stored *models.Item, base int64) error {
+	if stored == nil || stored.Sequence <= base {
+		return nil
+	}
+
+	if stored.Deleted && base == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: based on sequence %d, current is %d", ErrItemChanged, base, stored.Sequence)
+}
+
+// bury turns the item into a tombstone. The path and sequence are kept so clients can tell what was deleted
+func bury(item *models.Item) {
+	item.Deleted = true
+	item.SHA256 = ""
+	item.Size = 0
+}
+
+// saveEntry returns the audit entry for saving the item over the stored one, which can be a tombstone.
+// Saving over a tombstone is a restore
+func saveEntry(actor models.Actor, item *models.Item, stored *models.Item) models.AuditEntry {
+	entry := actor.Entry(models.AuditActionUpload)
+	entry.VaultId = item.VaultId
+	entry.Path = item.ServerPath
+	entry.SHA256After = item.SHA256
+
+	if stored != nil {
+		if stored.Deleted {
+			entry.Action = models.AuditActionRestore
+		} else {
+			entry.SHA256Before = stored.SHA256
+		}
+	}
+
+	return entry
+}
+
+// reserveQuota will make sure the item fits in the quotas and reserve the room for it until release is called.
+// Replacing an item only counts the difference in size
+func (s ItemService) reserveQuota(vault *models.Vault, item models.Item, size int, existing *models.Item) (release func(), err error) {
+	if s.Quota == nil {
+		return func() {}, nil
+	}
+
+	switch {
+	case item.IsSymlink():
+		size = len(item.LinkTarget)
+	case item.IsDir():
+		size = 0
+	}
+
+	if existing != nil {
+		size -= existing.Size
+	}
+
+	return s.Quota.Reserve(vault, int64(size))
+}
+
+// GetCollisions will return the groups of items in the vault whose paths only differ in case or unicode normalization.
+// Clients on case-insensitive filesystems can only have one item of every group
+func (s ItemService) GetCollisions(vault *models.Vault) ([][]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "vault_id", Value: vault.ID.Hex()}, {Key: "deleted", Value: false}}
+	projection := options.Find().SetProjection(bson.D{{Key: "server_path", Value: 1}}).SetSort(bson.D{{Key: "server_path", Value: 1}})
+
+	cursor, err := s.DB.Collections.ItemCollection.Find(ctx, filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.Item, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(items))
+	for _, item := range items {
+		paths = append(paths, item.ServerPath)
+	}
+
+	return iops.FindCollisions(paths), nil
+}
+
+// resolveCollision will return a path for the item that does not collide with any other item in the vault.
+// If an item exists whose path only differs in case or unicode normalization, a suffix is added to the name,
+// so clients on case-insensitive filesystems do not overwrite one item with the other
+func (s ItemService) resolveCollision(vault *models.Vault, itemPath iops.VaultPath) (iops.VaultPath, error) {
+	candidate := itemPath
+
+	for n := 1; ; n++ {
+		collides, err := s.collides(vault, candidate)
+		if err != nil || !collides {
+			return candidate, err
+		}
+
+		if candidate, err = itemPath.WithSuffix(n); err != nil {
+			return "", err
+		}
+	}
+}
+
+// collides returns true if another item in the vault has the same normalized path, but a different path
+func (s ItemService) collides(vault *models.Vault, itemPath iops.VaultPath) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "vault_id", Value: vault.ID.Hex()},
+		{Key: "deleted", Value: false},
+		{Key: "normalized_path", Value: iops.NormalizePath(itemPath.String())},
+		{Key: "server_path", Value: bson.D{{Key: "$ne", Value: itemPath.String()}}},
+	}
+
+	count, err := s.DB.Collections.ItemCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("error while checking for collisions: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteItem will remove the item at the given path and replace its metadata with a tombstone.
+// The tombstone is synced to the clients, so they can delete the item as well. If the item does not exist, does nothing
+func (s ItemService) DeleteItem(vault *models.Vault, itemPath iops.VaultPath, actor models.Actor) error {
+	item, err := s.GetItem(vault, itemPath)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deleting item", "vault", vault.ID, "path", item.ServerPath)
+
+	storageDriver, err := storage.NewLocalDriver(vault.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	entry := actor.Entry(models.AuditActionDelete)
+	entry.VaultId = item.VaultId
+	entry.Path = item.ServerPath
+	entry.SHA256Before = item.SHA256
+
+	bury(item)
+	item.ServerMTime = time.Now().Unix()
+
+	// The item is only removed once the delete is in the event log, so a failed append changes nothing
+	if err := s.appendEvent(storage.EventDelete, item, ""); err != nil {
+		return err
+	}
+
+	if err := storageDriver.Delete(*item); err != nil {
+		return err
+	}
+
+	if err := s.upsertItem(item); err != nil {
+		return err
+	}
+
+	s.Audit.Record(entry)
+
+	if err := pubsub.PublishItemChange(vault.ID.Hex(), actor.SessionId, *item); err != nil {
+		slog.Error("Error notifying clients of item change", "error", err)
+	}
+
+	return nil
+}
+
+// RenameItem will move the item to the new path and leave a tombstone at the old one.
+// Returns mongo.ErrNoDocuments if the item does not exist, ErrItemExists if the new path is taken or collides with another
+// item and ErrRenameDir for directories. Connected clients of the vault are notified of both paths and the rename is audited
+func (s ItemService) RenameItem(vault *models.Vault, from iops.VaultPath, to iops.VaultPath, actor models.Actor) (*models.Item, error) {
+	item, err := s.GetItem(vault, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.Deleted {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	if item.IsDir() {
+		return nil, ErrRenameDir
+	}
+
+	if _, err := s.GetItem(vault, to); err == nil {
+		return nil, ErrItemExists
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if collides, err := s.collides(vault, to); err != nil {
+		return nil, err
+	} else if collides {
+		return nil, ErrItemExists
+	}
+
+	slog.Info("Renaming item", "vault", vault.ID, "from", from, "to", to)
+
+	storageDriver, err := storage.NewLocalDriver(vault.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	renamed := *item
+	renamed.ServerPath = to.String()
+	renamed.ServerMTime = time.Now().Unix()
+
+	if err := storageDriver.Rename(*item, renamed); err != nil {
+		return nil, err
+	}
+
+	// Move the item back, so the storage does not disagree with the event log and the metadata
+	if err := s.appendEvent(storage.EventRename, &renamed, from.String()); err != nil {
+		if rollbackErr := storageDriver.Rename(renamed, *item); rollbackErr != nil {
+			slog.Error("Error moving the item back after a failed rename", "vault", vault.ID, "from", to, "to", from, "error", rollbackErr)
+		}
+
+		return nil, err
+	}
+
+	tombstone := *item
+	bury(&tombstone)
+	tombstone.ServerMTime = renamed.ServerMTime
+	tombstone.Sequence = renamed.Sequence
+
+	for _, changed := range []*models.Item{&renamed, &tombstone} {
+		if err := s.upsertItem(changed); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := actor.Entry(models.AuditActionRename)
+	entry.VaultId = renamed.VaultId
+	entry.Path = renamed.ServerPath
+	entry.SHA256Before = item.SHA256
+	entry.SHA256After = renamed.SHA256
+	entry.Details = fmt.Sprintf("renamed from %s", from)
+	s.Audit.Record(entry)
+
+	for _, changed := range []models.Item{tombstone, renamed} {
+		if err := pubsub.PublishItemChange(vault.ID.Hex(), actor.SessionId, changed); err != nil {
+			slog.Error("Error notifying clients of item change", "error", err)
+		}
+	}
+
+	return &renamed, nil
+}
+
+// appendEvent will record the change in the event log of the vault and set the sequence of the item to the one of the event
+func (s ItemService) appendEvent(eventType storage.EventType, item *models.Item, from string) error {
+	if s.Events == nil {
+		return nil
+	}
+
+	event, err := s.Events.Append(storage.Event{VaultId: item.VaultId, Type: eventType, Item: *item, From: from})
+	if err != nil {
+		return fmt.Errorf("error recording %s event: %w", eventType, err)
+	}
+
+	item.Sequence = event.Sequence
+
+	return nil
+}
+
+// upsertItem will insert or replace the metadata of the item, keyed by vault and path
+// Returns ErrItemExists if another item that is not deleted has the same normalized path
+func (s ItemService) upsertItem(item *models.Item) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item.NormalizedPath = iops.NormalizePath(item.ServerPath)
+
+	filter := bson.D{{Key: "vault_id", Value: item.VaultId}, {Key: "server_path", Value: item.ServerPath}}
+	_, err := s.DB.Collections.ItemCollection.ReplaceOne(ctx, filter, item, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s collides with another item", ErrItemExists, item.ServerPath)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while saving item metadata: %s, error was %w", item.ServerPath, err)
+	}
+
+	return nil
+}
+
+// RepairItems will store the metadata of the last events of the vault that did not make it into the Items collection,
+// because storing it failed or the server stopped after the event was appended. Only the last repairWindow events are checked,
+// as the metadata of older ones was stored long ago. Returns how many items were repaired
+func (s ItemService) RepairItems(vaultId string) (int, error) {
+	if s.Events == nil {
+		return 0, nil
+	}
+
+	lastSequence, err := s.Events.LastSequence(vaultId)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := s.Events.EventsSince(vaultId, max(lastSequence-repairWindow, 0), repairWindow)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching events: %w", err)
+	}
+
+	repaired := 0
+	for _, event := range events {
+		items := []models.Item{event.Item}
+		if event.Type == storage.EventRename {
+			tombstone := event.Item
+			tombstone.ServerPath = event.From
+			bury(&tombstone)
+			items = append(items, tombstone)
+		}
+
+		for _, item := range items {
+			item.Sequence = event.Sequence
+
+			stored, err := s.storedSequence(vaultId, item.ServerPath)
+			if err != nil {
+				return repaired, err
+			}
+
+			if stored >= item.Sequence {
+				continue
+			}
+
+			slog.Warn("Metadata of item is behind the event log, repairing it", "vault", vaultId, "path", item.ServerPath, "sequence", item.Sequence, "stored", stored)
+			if err := s.upsertItem(&item); err != nil {
+				return repaired, err
+			}
+
+			repaired++
+		}
+	}
+
+	return repaired, nil
+}
+
+// storedSequence returns the sequence in the metadata of the item at the given path, including tombstones, or 0 if there is none
+func (s ItemService) storedSequence(vaultId string, serverPath string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stored struct {
+		Sequence int64 `bson:"sequence"`
+	}
+
+	filter := bson.D{{Key: "vault_id", Value: vaultId}, {Key: "server_path", Value: serverPath}}
+	err := s.DB.Collections.ItemCollection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.D{{Key: "sequence", Value: 1}})).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	return stored.Sequence, err
+}
+
+// itemFilter returns the filter for the item at the given path in the vault
+func itemFilter(vault *models.Vault, itemPath iops.VaultPath) bson.D {
+	return bson.D{{Key: "vault_id", Value: vault.ID.Hex()}, {Key: "server_path", Value: itemPath.String()}}
+}
+
+// vaultOwner returns the ID of the owner of the vault
+func vaultOwner(vault *models.Vault) string {
+	for _, member := range vault.Members {
+		if member.Role == models.RoleOwner {
+			return member.UserId
+		}
+	}
+
+	return ""
 }
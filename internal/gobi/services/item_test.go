@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+func TestSaveEntry(t *testing.T) {
+	actor := models.Actor{Username: "user"}
+	live := models.Item{ServerPath: "notes/a.md", SHA256: "before", Size: 6, Sequence: 3}
+	tombstone := live
+	bury(&tombstone)
+
+	tests := []struct {
+		name             string
+		stored           *models.Item
+		wantAction       models.AuditAction
+		wantSHA256Before string
+	}{
+		{
+			name:       "new item is an upload",
+			wantAction: models.AuditActionUpload,
+		},
+		{
+			name:             "replacing an item is an upload of the new contents",
+			stored:           &live,
+			wantAction:       models.AuditActionUpload,
+			wantSHA256Before: "before",
+		},
+		{
+			name:       "saving over a tombstone is a restore",
+			stored:     &tombstone,
+			wantAction: models.AuditActionRestore,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &models.Item{ServerPath: "notes/a.md", SHA256: "after"}
+
+			entry := saveEntry(actor, item, tt.stored)
+			if entry.Action != tt.wantAction {
+				t.Errorf("Action = %q, want %q", entry.Action, tt.wantAction)
+			}
+
+			if entry.SHA256Before != tt.wantSHA256Before {
+				t.Errorf("SHA256Before = %q, want %q", entry.SHA256Before, tt.wantSHA256Before)
+			}
+
+			if entry.SHA256After != "after" {
+				t.Errorf("SHA256After = %q, want %q", entry.SHA256After, "after")
+			}
+		})
+	}
+}
+
+func TestCheckBase(t *testing.T) {
+	live := models.Item{ServerPath: "notes/a.md", SHA256: "contents", Size: 8, Sequence: 5}
+	tombstone := live
+	bury(&tombstone)
+	tombstone.Sequence = 7
+
+	tests := []struct {
+		name    string
+		stored  *models.Item
+		base    int64
+		wantErr bool
+	}{
+		{name: "new item", base: 0},
+		{name: "based on the current version", stored: &live, base: 5},
+		{name: "based on an older version", stored: &live, base: 3, wantErr: true},
+		{name: "replacing without a base", stored: &live, base: 0, wantErr: true},
+		{name: "replacing an item from before the event log", stored: &models.Item{ServerPath: "notes/a.md"}, base: 0},
+		{name: "based on the delete", stored: &tombstone, base: 7},
+		{name: "stale base over a delete", stored: &tombstone, base: 5, wantErr: true},
+		{name: "creating anew over a delete", stored: &tombstone, base: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBase(tt.stored, tt.base)
+			if tt.wantErr && !errors.Is(err, ErrItemChanged) {
+				t.Errorf("checkBase() = %v, want ErrItemChanged", err)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkBase() = %v, want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"log/slog"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrateVaultStorage will move the vaults that are still stored in a directory named after them to the ID of their vault.
+// Before vaults were shared, every vault was only a directory, without any metadata, so the items in it are indexed first.
+// Directories with no vault of that name are given to the admin, if there is one. Directories whose name is used by more
+// than one vault cannot be told apart and are left in place. Directories are only moved once they were indexed, so if the
+// server stops midway, the migration picks up where it stopped on the next start
+func MigrateVaultStorage(vaultsService *VaultsService, itemService *ItemService, admin *models.User) error {
+	names, err := storage.ListVaults()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		// Vaults stored by ID are already migrated
+		if primitive.IsValidObjectID(name) {
+			continue
+		}
+
+		vaults, err := vaultsService.GetVaultsByName(name)
+		if err != nil {
+			return err
+		}
+
+		var vault *models.Vault
+		switch {
+		case len(vaults) == 1:
+			vault = &vaults[0]
+		case len(vaults) > 1:
+			slog.Warn("Vault directory is used by more than one vault, move it by hand", "directory", name, "vaults", len(vaults))
+			continue
+		case admin == nil:
+			slog.Warn("Vault directory has no vault and there is no admin to give it to, set GOBI_ADMIN_USERNAME", "directory", name)
+			continue
+		default:
+			if vault, err = vaultsService.CreateVault(*admin, name); err != nil {
+				slog.Warn("Could not create a vault for the vault directory", "directory", name, "error", err)
+				continue
+			}
+		}
+
+		// The contents of two directories are never mixed, so vaults that already have items at their ID are left alone
+		if hasContents, err := storage.VaultHasContents(vault.ID.Hex()); err != nil || hasContents {
+			slog.Warn("Vault already has items, move the vault directory by hand", "directory", name, "vault", vault.ID, "error", err)
+			continue
+		}
+
+		indexed, err := itemService.IndexStorage(vault, name)
+		if err != nil {
+			slog.Warn("Could not index the vault directory", "directory", name, "vault", vault.ID, "error", err)
+			continue
+		}
+
+		if err := storage.MoveVault(name, vault.ID.Hex()); err != nil {
+			slog.Warn("Could not move the vault directory", "directory", name, "vault", vault.ID, "error", err)
+			continue
+		}
+
+		slog.Info("Migrated vault directory", "directory", name, "vault", vault.ID, "items", indexed)
+	}
+
+	return nil
+}
+
+// RepairItemMetadata will repair the metadata of every vault stored by ID from its event log, see ItemService.RepairItems.
+// Vaults that cannot be repaired are logged and skipped, they are tried again on the next start
+func RepairItemMetadata(itemService *ItemService) error {
+	names, err := storage.ListVaults()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !primitive.IsValidObjectID(name) {
+			continue
+		}
+
+		repaired, err := itemService.RepairItems(name)
+		if err != nil {
+			slog.Warn("Could not repair the metadata of the vault", "vault", name, "error", err)
+			continue
+		}
+
+		if repaired > 0 {
+			slog.Info("Repaired metadata from the event log", "vault", name, "items", repaired)
+		}
+	}
+
+	return nil
+}
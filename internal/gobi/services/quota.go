@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/database"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/redis"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrQuotaExceeded is returned when an upload would go over the quota of the user or the vault
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// reservationExpiration is how long bytes reserved for an upload are kept if the server never releases them
+const reservationExpiration = time.Hour
+
+// QuotaService calculates how much storage users and vaults use and enforces the quotas.
+// Usage is calculated from the sizes in the Items collection. Items count against the vault and against its owner
+type QuotaService struct {
+	DB *database.Database
+
+	// UserQuota is the maximum number of bytes all vaults owned by a user can use. Zero means there is no limit
+	UserQuota int64
+	// VaultQuota is the maximum number of bytes a single vault can use. Zero means there is no limit
+	VaultQuota int64
+}
+
+// NewQuotaService will instantiate a new QuotaService given the database
+// The quotas are read from the GOBI_USER_QUOTA_BYTES and GOBI_VAULT_QUOTA_BYTES environment variables
+func NewQuotaService(db *database.Database) *QuotaService {
+	return &QuotaService{
+		DB:         db,
+		UserQuota:  quotaFromEnv("GOBI_USER_QUOTA_BYTES"),
+		VaultQuota: quotaFromEnv("GOBI_VAULT_QUOTA_BYTES"),
+	}
+}
+
+// GetUsage will return the usage of the vault and of the user that owns it
+func (s QuotaService) GetUsage(vault *models.Vault) (*models.QuotaUsage, error) {
+	vaultUsed, err := s.usedBytes(bson.D{{Key: "vault_id", Value: vault.ID.Hex()}})
+	if err != nil {
+		return nil, err
+	}
+
+	userUsed, err := s.usedBytes(bson.D{{Key: "owner_id", Value: vaultOwner(vault)}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.QuotaUsage{
+		User:  models.Usage{UsedBytes: userUsed, QuotaBytes: s.UserQuota},
+		Vault: models.Usage{UsedBytes: vaultUsed, QuotaBytes: s.VaultQuota},
+	}, nil
+}
+
+// Enabled returns true if any quota is set
+func (s QuotaService) Enabled() bool {
+	return s.UserQuota != 0 || s.VaultQuota != 0
+}
+
+// Reserve will set aside the given number of bytes for an upload to the vault and return ErrQuotaExceeded if they do not fit.
+// Reservations are counted on top of the usage until they are released, so concurrent uploads cannot go over a quota together.
+// Call release once the item is stored or the upload failed. Reservations of servers that die expire on their own
+func (s QuotaService) Reserve(vault *models.Vault, bytes int64) (release func(), err error) {
+	release = func() {}
+	if !s.Enabled() || bytes <= 0 {
+		return release, nil
+	}
+
+	usage, err := s.GetUsage(vault)
+	if err != nil {
+		return release, err
+	}
+
+	keys := []string{reservedKey("vault", vault.ID.Hex()), reservedKey("user", vaultOwner(vault))}
+	reserved := make([]int64, 0, len(keys))
+
+	release = func() {
+		for _, key := range keys[:len(reserved)] {
+			if _, err := redis.DecrBy(key, bytes); err != nil {
+				slog.Error("Error releasing reserved bytes", "key", key, "error", err)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		total, err := redis.IncrBy(key, bytes)
+		if err != nil {
+			release()
+			return func() {}, fmt.Errorf("error reserving bytes: %w", err)
+		}
+
+		reserved = append(reserved, total-bytes)
+		_ = redis.Expire(key, reservationExpiration)
+	}
+
+	usage.Vault.UsedBytes += reserved[0]
+	usage.User.UsedBytes += reserved[1]
+
+	if !usage.Allows(bytes) {
+		release()
+		return func() {}, fmt.Errorf("%w: %d bytes requested, %d bytes remaining", ErrQuotaExceeded, bytes, usage.Remaining())
+	}
+
+	return release, nil
+}
+
+// reservedKey returns the redis key that holds the bytes reserved for uploads to the vault or the user with the given ID
+func reservedKey(kind string, id string) string {
+	return fmt.Sprintf("quota-reserved-%s-%s", kind, id)
+}
+
+// usedBytes will return the sum of the sizes of all items that match the filter, except deleted ones
+func (s QuotaService) usedBytes(filter bson.D) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: append(filter, bson.E{Key: "deleted", Value: false})}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "used", Value: bson.D{{Key: "$sum", Value: "$size"}}},
+		}}},
+	}
+
+	cursor, err := s.DB.Collections.ItemCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("error while calculating usage: %w", err)
+	}
+
+	var results []struct {
+		Used int64 `bson:"used"`
+	}
+
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("error while calculating usage: %w", err)
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Used, nil
+}
+
+// quotaFromEnv will read a quota in bytes from the given environment variable. Invalid or missing values mean no limit
+func quotaFromEnv(key string) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	quota, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || quota < 0 {
+		slog.Warn("Invalid quota, no limit will be enforced", "variable", key, "value", value)
+		return 0
+	}
+
+	return quota
+}
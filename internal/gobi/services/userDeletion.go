@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/redis"
+)
+
+// deletionLockExpiration is how long a deletion job holds its lock without renewing it.
+// If the server crashes midway, the job can be picked up again once the lock expires
+const deletionLockExpiration = 5 * time.Minute
+
+// UserDeletionService removes users together with everything they own.
+// Deletion runs in the background: the user is marked as deleting, so they can no longer authenticate, and every step
+// can be safely repeated, so a deletion that was interrupted is retried by Resume
+type UserDeletionService struct {
+	UsersService     *UsersService
+	VaultsService    *VaultsService
+	WebsocketService *WebsocketService
+}
+
+// NewUserDeletionService will instantiate a new UserDeletionService given the services it operates on
+func NewUserDeletionService(usersService *UsersService, vaultsService *VaultsService, websocketService *WebsocketService) *UserDeletionService {
+	return &UserDeletionService{
+		UsersService:     usersService,
+		VaultsService:    vaultsService,
+		WebsocketService: websocketService,
+	}
+}
+
+// DeleteUser will mark the user as deleting, disconnect them and start removing their data in the background
+func (s *UserDeletionService) DeleteUser(user *models.User) error {
+	slog.Info("Scheduling user deletion", "user", user.Username)
+
+	if err := s.UsersService.SetDeleting(user.Username); err != nil {
+		return fmt.Errorf("error while marking user as deleting: %w", err)
+	}
+
+	s.WebsocketService.DisconnectUser(user.Username, "User was deleted")
+
+	go s.run(*user)
+
+	return nil
+}
+
+// Resume will retry all deletions that did not finish, now and then every interval
+// Does not block
+func (s *UserDeletionService) Resume(interval time.Duration) {
+	go func() {
+		for {
+			users, err := s.UsersService.GetDeletingUsers()
+			if err != nil {
+				slog.Error("Error while fetching users pending deletion", "error", err)
+			}
+
+			for _, user := range users {
+				s.run(user)
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// run will delete the user, unless another deletion of the same user is already running
+func (s *UserDeletionService) run(user models.User) {
+	lockKey := deletionLockKey(user)
+
+	token, err := redis.Lock(lockKey, deletionLockExpiration)
+	if err != nil {
+		slog.Error("Error while locking user deletion", "user", user.Username, "error", err)
+		return
+	}
+
+	if token == "" {
+		slog.Debug("User deletion is already running", "user", user.Username)
+		return
+	}
+
+	defer redis.Unlock(lockKey, token)
+
+	renew := func() error {
+		return redis.Renew(lockKey, token, deletionLockExpiration)
+	}
+
+	if err := s.delete(user, renew); err != nil {
+		slog.Error("Error while deleting user, will be retried", "user", user.Username, "error", err)
+		return
+	}
+
+	slog.Info("User deleted", "user", user.Username)
+}
+
+// delete will disconnect the user, purge their sessions, delete the vaults they own, leave the vaults they are a member of
+// and finally delete the user itself. The user document is deleted last, so the job is retried until everything is gone
+func (s *UserDeletionService) delete(user models.User, renew func() error) error {
+	if err := s.WebsocketService.RevokeUser(user.Username, "User was deleted"); err != nil {
+		return err
+	}
+
+	vaults, err := s.VaultsService.GetVaults(user)
+	if err != nil {
+		return fmt.Errorf("error while fetching vaults: %w", err)
+	}
+
+	for _, vault := range vaults {
+		if err := renew(); err != nil {
+			return fmt.Errorf("error while renewing lock: %w", err)
+		}
+
+		if role, _ := vault.RoleOf(user.ID.Hex()); role != models.RoleOwner {
+			if err := s.VaultsService.RemoveMember(&vault, user.Username); err != nil {
+				return fmt.Errorf("error while leaving vault %s: %w", vault.ID.Hex(), err)
+			}
+
+			continue
+		}
+
+		s.WebsocketService.DisconnectVault(vault.ID.Hex(), "Vault was deleted")
+
+		if err := s.VaultsService.DeleteVault(&vault); err != nil {
+			return err
+		}
+	}
+
+	return s.UsersService.DeleteUser(user.ID.Hex())
+}
+
+// deletionLockKey returns the redis key used to make sure a user is only deleted by one job at a time
+func deletionLockKey(user models.User) string {
+	return fmt.Sprintf("user-deletion:%s", user.ID.Hex())
+}
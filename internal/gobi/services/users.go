@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -12,8 +13,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrInvalidPassword is returned when the given password does not match the one of the user
+var ErrInvalidPassword = errors.New("invalid password")
+
 type UsersService struct {
 	DB *database.Database
 }
@@ -39,6 +44,18 @@ func (u UsersService) CreateUser(user *models.User) error {
 		return fmt.Errorf("username cannot be empty")
 	}
 
+	if user.Password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	if user.Role == "" {
+		user.Role = models.UserRoleUser
+	}
+
+	if !user.Role.Valid() {
+		return fmt.Errorf("unknown role: %s", user.Role)
+	}
+
 	// Validate username allows only alphanumeric characters and underscores
 	if !models.ValidateUsername(user.Username) {
 		return fmt.Errorf("username must only contain alphanumeric characters and underscores")
@@ -58,6 +75,8 @@ func (u UsersService) CreateUser(user *models.User) error {
 	// Set a new ObjectID for the user's _id field
 	user.ID = primitive.NewObjectID()
 	user.Password = digest.SHA256(user.Password)
+	user.CreatedAt = time.Now().Unix()
+	user.UpdatedAt = user.CreatedAt
 
 	insertResult, err := userCollection.InsertOne(ctx, user)
 	if err != nil {
@@ -76,7 +95,7 @@ func (u UsersService) CreateUser(user *models.User) error {
 	return nil
 }
 
-// DeleteUser deletes the user given the ID.
+// DeleteUser deletes the user document given the ID. Does not remove any of the user's data, use the UserDeletionService for that.
 // If the user doesn't exist, does nothing.
 func (u UsersService) DeleteUser(id string) error {
 	slog.Info("Deleting user", "id", id)
@@ -90,11 +109,41 @@ func (u UsersService) DeleteUser(id string) error {
 		return err
 	}
 
-	userCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: objectId}})
+	result, err := userCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: objectId}})
+	if err != nil {
+		return fmt.Errorf("error while deleting user: %s, error was %w", id, err)
+	}
+
+	if result.DeletedCount == 0 {
+		slog.Warn("User to delete was not found", "id", id)
+	}
 
 	return nil
 }
 
+// SetDeleting will mark the user as being deleted. Users that are being deleted cannot authenticate
+func (u UsersService) SetDeleting(username string) error {
+	return u.updateUser(username, bson.D{{Key: "deleting", Value: true}})
+}
+
+// GetDeletingUsers will return all the users whose deletion has not finished yet
+func (u UsersService) GetDeletingUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := u.DB.Collections.UsersCollection.Find(ctx, bson.D{{Key: "deleting", Value: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 // GetUser will return the user, given an ID.
 // If the user does not exist, then an error will be returned.
 func (u UsersService) GetUser(id string) (*models.User, error) {
@@ -134,3 +183,91 @@ func (u UsersService) GetUserByName(username string) (*models.User, error) {
 
 	return user, err
 }
+
+// GetUsers will return all the users, sorted by username
+func (u UsersService) GetUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := u.DB.Collections.UsersCollection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "username", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetDisabled will disable or enable the user with the given username. Disabled users cannot authenticate
+func (u UsersService) SetDisabled(username string, disabled bool) error {
+	slog.Info("Changing user status", "user", username, "disabled", disabled)
+
+	return u.updateUser(username, bson.D{{Key: "disabled", Value: disabled}})
+}
+
+// SetRole will change the server role of the user with the given username
+func (u UsersService) SetRole(username string, role models.UserRole) error {
+	if !role.Valid() {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+
+	slog.Info("Changing user role", "user", username, "role", role)
+
+	return u.updateUser(username, bson.D{{Key: "role", Value: role}})
+}
+
+// ChangePassword will replace the password of the user, if the current password matches
+func (u UsersService) ChangePassword(user *models.User, currentPassword string, newPassword string) error {
+	if digest.SHA256(currentPassword) != user.Password {
+		return ErrInvalidPassword
+	}
+
+	return u.SetPassword(user.Username, newPassword)
+}
+
+// SetPassword will replace the password of the user with the given username
+func (u UsersService) SetPassword(username string, password string) error {
+	if password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	slog.Info("Changing user password", "user", username)
+
+	return u.updateUser(username, bson.D{{Key: "password", Value: digest.SHA256(password)}})
+}
+
+// EnsureAdmin will make sure a user with the given username exists and is an admin.
+// If the user does not exist, it is created with the given password. The password of an existing user is not changed
+func (u UsersService) EnsureAdmin(username string, password string) error {
+	if _, err := u.GetUserByName(username); err == mongo.ErrNoDocuments {
+		return u.CreateUser(&models.User{Username: username, Password: password, Role: models.UserRoleAdmin})
+	} else if err != nil {
+		return err
+	}
+
+	return u.SetRole(username, models.UserRoleAdmin)
+}
+
+// updateUser will set the given fields on the user with the given username and bump the time it was updated at
+// Returns mongo.ErrNoDocuments if there is no such user
+func (u UsersService) updateUser(username string, fields bson.D) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields = append(fields, bson.E{Key: "updated_at", Value: time.Now().Unix()})
+
+	result, err := u.DB.Collections.UsersCollection.UpdateOne(ctx, bson.D{{Key: "username", Value: username}}, bson.D{{Key: "$set", Value: fields}})
+	if err != nil {
+		return fmt.Errorf("error while updating user: %s, error was %w", username, err)
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
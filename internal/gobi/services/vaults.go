@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/database"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/pubsub"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
+	"github.com/Michaelpalacce/gobi/pkg/iops"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrVaultNotFound is returned when the user has no vault with the given name. Vaults must be created before connecting to them
+var ErrVaultNotFound = errors.New("vault not found")
+
+type VaultsService struct {
+	DB *database.Database
+}
+
+// NewVaultsService will return an instance of the Vaults Service
+func NewVaultsService(db *database.Database) *VaultsService {
+	return &VaultsService{
+		DB: db,
+	}
+}
+
+// CreateVault will create a new vault with the given name, owned by the given user
+// Will return an error if the user already has access to a vault with the same name
+func (v VaultsService) CreateVault(owner models.User, name string) (*models.Vault, error) {
+	slog.Info("Creating a new vault", "vault", name, "owner", owner.Username)
+
+	if err := iops.ValidateVaultName(name); err != nil {
+		return nil, err
+	}
+
+	if _, err := v.GetVault(owner, name); err == nil {
+		return nil, fmt.Errorf("vault exists")
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	vault := &models.Vault{
+		ID:   primitive.NewObjectID(),
+		Name: name,
+		Members: []models.VaultMember{
+			{UserId: owner.ID.Hex(), Username: owner.Username, Role: models.RoleOwner},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := v.DB.Collections.VaultsCollection.InsertOne(ctx, vault); err != nil {
+		return nil, fmt.Errorf("error while inserting vault: %s, error was %w", name, err)
+	}
+
+	slog.Info("Vault Created", "ID", vault.ID)
+
+	return vault, nil
+}
+
+// GetVault will return the vault with the given name that the user is a member of.
+// Returns mongo.ErrNoDocuments if the user has no such vault
+func (v VaultsService) GetVault(user models.User, name string) (*models.Vault, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	vault := &models.Vault{}
+	filter := bson.D{
+		{Key: "name", Value: name},
+		{Key: "members.user_id", Value: user.ID.Hex()},
+	}
+
+	if err := v.DB.Collections.VaultsCollection.FindOne(ctx, filter).Decode(vault); err != nil {
+		return nil, err
+	}
+
+	return vault, nil
+}
+
+// GetVaults will return all the vaults the user is a member of
+func (v VaultsService) GetVaults(user models.User) ([]models.Vault, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := v.DB.Collections.VaultsCollection.Find(ctx, bson.D{{Key: "members.user_id", Value: user.ID.Hex()}})
+	if err != nil {
+		return nil, err
+	}
+
+	vaults := make([]models.Vault, 0)
+	if err := cursor.All(ctx, &vaults); err != nil {
+		return nil, err
+	}
+
+	return vaults, nil
+}
+
+// GetVaultsByName will return all vaults with the given name, no matter who their members are
+func (v VaultsService) GetVaultsByName(name string) ([]models.Vault, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := v.DB.Collections.VaultsCollection.Find(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return nil, err
+	}
+
+	vaults := make([]models.Vault, 0)
+	if err := cursor.All(ctx, &vaults); err != nil {
+		return nil, err
+	}
+
+	return vaults, nil
+}
+
+// ResolveVault will return the vault with the given name that the user is a member of, together with the user's role.
+// Returns ErrVaultNotFound if the user has no vault with that name, vaults are only created with CreateVault
+func (v VaultsService) ResolveVault(user models.User, name string) (*models.Vault, models.Role, error) {
+	vault, err := v.GetVault(user, name)
+	if err == mongo.ErrNoDocuments {
+		err = ErrVaultNotFound
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("error resolving vault %s: %w", name, err)
+	}
+
+	role, _ := vault.RoleOf(user.ID.Hex())
+
+	return vault, role, nil
+}
+
+// AddMember will give the user with the given username the role in the vault.
+// If the user is already a member, their role is updated and their clients are disconnected, so they reconnect with the new role.
+// The owner's role cannot be changed this way
+func (v VaultsService) AddMember(vault *models.Vault, username string, role models.Role) error {
+	slog.Info("Adding member to vault", "vault", vault.ID, "username", username, "role", role)
+
+	if !role.Valid() || role == models.RoleOwner {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user := &models.User{}
+	if err := v.DB.Collections.UsersCollection.FindOne(ctx, bson.D{{Key: "username", Value: username}}).Decode(user); err != nil {
+		return fmt.Errorf("user %s not found", username)
+	}
+
+	if currentRole, ok := vault.RoleOf(user.ID.Hex()); ok {
+		if currentRole == models.RoleOwner {
+			return fmt.Errorf("cannot change the role of the owner")
+		}
+
+		if currentRole == role {
+			return nil
+		}
+
+		_, err := v.DB.Collections.VaultsCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: vault.ID}, {Key: "members.user_id", Value: user.ID.Hex()}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "members.$.role", Value: role}}}},
+		)
+		if err != nil {
+			return err
+		}
+
+		return revokeMember(vault, user.ID.Hex(), user.Username)
+	}
+
+	// Vaults are addressed by name, so a user cannot be a member of two vaults with the same name
+	if _, err := v.GetVault(*user, vault.Name); err == nil {
+		return fmt.Errorf("user %s already has a vault named %s", username, vault.Name)
+	}
+
+	member := models.VaultMember{UserId: user.ID.Hex(), Username: user.Username, Role: role}
+	_, err := v.DB.Collections.VaultsCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: vault.ID}},
+		bson.D{{Key: "$push", Value: bson.D{{Key: "members", Value: member}}}},
+	)
+
+	return err
+}
+
+// DeleteVault will delete the vault, all of its items, its event log and its directory.
+// The vault document is deleted last, so if anything fails, calling it again will pick up where it stopped
+func (v VaultsService) DeleteVault(vault *models.Vault) error {
+	slog.Info("Deleting vault", "vault", vault.ID, "name", vault.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := v.DB.Collections.ItemCollection.DeleteMany(ctx, bson.D{{Key: "vault_id", Value: vault.ID.Hex()}}); err != nil {
+		return fmt.Errorf("error while deleting items of vault: %s, error was %w", vault.ID.Hex(), err)
+	}
+
+	if _, err := v.DB.Collections.EventsCollection.DeleteMany(ctx, bson.D{{Key: "vault_id", Value: vault.ID.Hex()}}); err != nil {
+		return fmt.Errorf("error while deleting events of vault: %s, error was %w", vault.ID.Hex(), err)
+	}
+
+	if _, err := v.DB.Collections.SequencesCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: vault.ID.Hex()}}); err != nil {
+		return fmt.Errorf("error while deleting sequence of vault: %s, error was %w", vault.ID.Hex(), err)
+	}
+
+	if err := storage.RemoveVault(vault.ID.Hex()); err != nil {
+		return err
+	}
+
+	if _, err := v.DB.Collections.VaultsCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: vault.ID}}); err != nil {
+		return fmt.Errorf("error while deleting vault: %s, error was %w", vault.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// RemoveMember will remove the user with the given username from the vault.
+// Their clients connected to the vault are disconnected and their sessions in it are removed, on every server.
+// The owner cannot be removed. If the user is not a member, does nothing.
+func (v VaultsService) RemoveMember(vault *models.Vault, username string) error {
+	slog.Info("Removing member from vault", "vault", vault.ID, "username", username)
+
+	var removed *models.VaultMember
+	for i, member := range vault.Members {
+		if member.Username != username {
+			continue
+		}
+
+		if member.Role == models.RoleOwner {
+			return fmt.Errorf("cannot remove the owner of the vault")
+		}
+
+		removed = &vault.Members[i]
+	}
+
+	if removed == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := v.DB.Collections.VaultsCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: vault.ID}},
+		bson.D{{Key: "$pull", Value: bson.D{{Key: "members", Value: bson.D{{Key: "username", Value: username}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return revokeMember(vault, removed.UserId, removed.Username)
+}
+
+// revokeMember will remove the sessions of the member in the vault and tell every server to disconnect their clients.
+// Roles are only checked when a client connects, so this must be called whenever the membership of a user changes
+func revokeMember(vault *models.Vault, userId string, username string) error {
+	if err := session.DeleteVaultSessions(username, vault.Name); err != nil {
+		return fmt.Errorf("error while revoking sessions of %s: %w", username, err)
+	}
+
+	if err := pubsub.PublishMembershipChange(vault.ID.Hex(), userId); err != nil {
+		return fmt.Errorf("error while disconnecting clients of %s: %w", username, err)
+	}
+
+	return nil
+}
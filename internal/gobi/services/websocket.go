@@ -1,11 +1,15 @@
 package services
 
 import (
+	"context"
 	"log/slog"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/client"
 	"github.com/Michaelpalacce/gobi/pkg/gobi/connection"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
 	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
 	"github.com/gorilla/websocket"
@@ -14,46 +18,237 @@ import (
 // connectedClientsMutex is a mutex so we can register only one client at a time
 var connectedClientsMutex sync.Mutex
 
+// shutdownPollInterval is how often we check if all clients disconnected while shutting down
+const shutdownPollInterval = 100 * time.Millisecond
+
 // WebsocketService handles the connection between the server and the clients
 type WebsocketService struct {
 	// connectedClients is a map of all the connected clients
 	connectedClients map[*connection.ServerConnection]bool
+	vaultsService    *VaultsService
+	itemService      *ItemService
+	auditService     *AuditService
+	// heartbeat configures how clients that went silent are detected
+	heartbeat socket.Heartbeat
+
+	// shutdownMutex guards the fields below. Once shuttingDown is set, no new connections are accepted
+	shutdownMutex  sync.Mutex
+	shuttingDown   bool
+	reconnectDelay time.Duration
+}
+
+// SessionInfo describes a connected client
+type SessionInfo struct {
+	SessionID     string `json:"session_id"`
+	Username      string `json:"username"`
+	VaultName     string `json:"vault_name"`
+	Version       int    `json:"version"`
+	SyncDirection int    `json:"sync_direction"`
+	RemoteAddr    string `json:"remote_addr"`
 }
 
 // NewWebsocketService should only be created once by the handler
-func NewWebsocketService() WebsocketService {
-	return WebsocketService{
+func NewWebsocketService(vaultsService *VaultsService, itemService *ItemService, auditService *AuditService) *WebsocketService {
+	return &WebsocketService{
 		connectedClients: make(map[*connection.ServerConnection]bool),
+		vaultsService:    vaultsService,
+		itemService:      itemService,
+		auditService:     auditService,
+		heartbeat:        socket.NewHeartbeat(time.Duration(limitFromEnv("GOBI_PING_INTERVAL_SECONDS", 30)) * time.Second),
 	}
 }
 
 // HandleConnection will register a new client and start listening for any messages
 // At the end, the client will be unregistered and the connection will be closed with
-// an Error message if one was present
-func (s *WebsocketService) HandleConnection(conn *websocket.Conn, user models.User) {
-	client := &connection.ServerConnection{WebsocketClient: &socket.WebsocketClient{
-		Conn:   conn,
-		Client: client.ClientMetadata{},
-		User:   user,
-	}}
+// an Error message if one was present. Clients that stop answering pings lose their session as well
+func (s *WebsocketService) HandleConnection(conn *websocket.Conn, user models.User, device string) {
+	client := &connection.ServerConnection{
+		WebsocketClient: &socket.WebsocketClient{
+			Conn:   conn,
+			Client: client.ClientMetadata{},
+			User:   user,
+			Device: device,
+		},
+		VaultResolver: s.vaultsService,
+		ItemStore:     s.itemService,
+		Auditor:       s.auditService,
+		Heartbeat:     s.heartbeat,
+	}
 
 	s.registerClient(client)
 	defer s.unregisterClient(client)
 
+	// The client connected while the server was already telling the others about the shutdown
+	if reconnectDelay, shuttingDown := s.ShuttingDown(); shuttingDown {
+		if err := client.Shutdown("server is shutting down", reconnectAfter(reconnectDelay)); err != nil {
+			slog.Warn("Error telling client about the shutdown", "error", err)
+		}
+	}
+
 	closeChannel := make(chan error, 1)
 	defer close(closeChannel)
 
 	go client.Listen(closeChannel)
 
 	err := <-closeChannel
+	// Half-open connections, like the ones of sleeping laptops, are cleaned up together with their session
+	if socket.IsTimeout(err) {
+		slog.Warn("Client went silent, disconnecting it", "user", user.Username, "session", client.SessionID())
+		client.Disconnect("no heartbeat")
+		client.Close("")
+		return
+	}
+
 	if err != nil {
 		slog.Error("Closing connection due to an error", "error", err)
 		client.Close(err.Error())
+		return
 	}
 
 	client.Close("")
 }
 
+// ShuttingDown returns true once Shutdown was called, together with how long clients should wait before reconnecting.
+// New connections must not be accepted after that
+func (s *WebsocketService) ShuttingDown() (time.Duration, bool) {
+	s.shutdownMutex.Lock()
+	defer s.shutdownMutex.Unlock()
+
+	return s.reconnectDelay, s.shuttingDown
+}
+
+// Shutdown will tell all connected clients that the server is stopping and wait for them to disconnect, until the context is done.
+// Every client is told to wait a random time between reconnectDelay and twice that, so they do not all come back at once.
+// Clients that are still connected at the end are disconnected
+func (s *WebsocketService) Shutdown(ctx context.Context, reconnectDelay time.Duration) {
+	s.shutdownMutex.Lock()
+	s.shuttingDown = true
+	s.reconnectDelay = reconnectDelay
+	s.shutdownMutex.Unlock()
+
+	clients := s.clients(func(client *connection.ServerConnection) bool { return true })
+	slog.Info("Telling clients that the server is shutting down", "clients", len(clients))
+	for _, client := range clients {
+		if err := client.Shutdown("server is shutting down", reconnectAfter(reconnectDelay)); err != nil {
+			slog.Warn("Error telling client about the shutdown", "session", client.SessionID(), "error", err)
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for s.connectedCount() > 0 {
+		select {
+		case <-ctx.Done():
+			disconnected := s.disconnect(func(client *connection.ServerConnection) bool { return true }, "server is shutting down")
+			slog.Warn("Clients did not disconnect in time", "clients", disconnected)
+			return
+		case <-ticker.C:
+		}
+	}
+
+	slog.Info("All clients disconnected")
+}
+
+// GetSessions will return all connected clients. If a username is given, only the clients of that user are returned
+func (s *WebsocketService) GetSessions(username string) []SessionInfo {
+	connectedClientsMutex.Lock()
+	defer connectedClientsMutex.Unlock()
+
+	sessions := make([]SessionInfo, 0, len(s.connectedClients))
+	for client := range s.connectedClients {
+		if username != "" && client.WebsocketClient.User.Username != username {
+			continue
+		}
+
+		// The client changes while it is connected, so only its snapshot is read
+		info, _ := client.Info()
+		sessions = append(sessions, SessionInfo{
+			SessionID:     info.SessionID,
+			Username:      client.WebsocketClient.User.Username,
+			VaultName:     info.VaultName,
+			Version:       info.Version,
+			SyncDirection: info.SyncDirection,
+			RemoteAddr:    client.WebsocketClient.Conn.RemoteAddr().String(),
+		})
+	}
+
+	return sessions
+}
+
+// DisconnectSession will disconnect the client with the given session ID
+// Returns false if no such client is connected
+func (s *WebsocketService) DisconnectSession(sessionId string, reason string) bool {
+	return s.disconnect(func(client *connection.ServerConnection) bool {
+		return client.SessionID() == sessionId
+	}, reason) > 0
+}
+
+// DisconnectUser will disconnect all clients of the given user and returns how many there were
+func (s *WebsocketService) DisconnectUser(username string, reason string) int {
+	return s.disconnect(func(client *connection.ServerConnection) bool {
+		return client.WebsocketClient.User.Username == username
+	}, reason)
+}
+
+// RevokeUser will disconnect all clients of the given user and remove all of their sessions, including ones of clients
+// that are not connected at the moment
+func (s *WebsocketService) RevokeUser(username string, reason string) error {
+	s.DisconnectUser(username, reason)
+
+	return session.DeleteUserSessions(username)
+}
+
+// DisconnectVault will disconnect all clients connected to the vault with the given ID and returns how many there were
+func (s *WebsocketService) DisconnectVault(vaultId string, reason string) int {
+	return s.disconnect(func(client *connection.ServerConnection) bool {
+		return client.VaultID() == vaultId
+	}, reason)
+}
+
+// disconnect will disconnect all clients that match and returns how many there were.
+// The clients are unregistered by HandleConnection, once their read loop stops
+func (s *WebsocketService) disconnect(match func(client *connection.ServerConnection) bool, reason string) int {
+	clients := s.clients(match)
+	for _, client := range clients {
+		slog.Info("Disconnecting client", "user", client.WebsocketClient.User.Username, "session", client.SessionID(), "reason", reason)
+		client.Disconnect(reason)
+	}
+
+	return len(clients)
+}
+
+// clients returns the connected clients that match. Sending to a client can block, so it must be done with the returned
+// clients and never while holding the lock, otherwise one slow client stalls every connect and disconnect
+func (s *WebsocketService) clients(match func(client *connection.ServerConnection) bool) []*connection.ServerConnection {
+	connectedClientsMutex.Lock()
+	defer connectedClientsMutex.Unlock()
+
+	clients := make([]*connection.ServerConnection, 0, len(s.connectedClients))
+	for client := range s.connectedClients {
+		if match(client) {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
+
+// reconnectAfter returns after how many seconds a client should reconnect, somewhere between the delay and twice that
+func reconnectAfter(reconnectDelay time.Duration) int {
+	delay := int(reconnectDelay.Seconds())
+
+	return delay + rand.Intn(delay+1)
+}
+
+// connectedCount returns how many clients are connected
+func (s *WebsocketService) connectedCount() int {
+	connectedClientsMutex.Lock()
+	defer connectedClientsMutex.Unlock()
+
+	return len(s.connectedClients)
+}
+
 // registerClient registers a client
 func (s *WebsocketService) registerClient(client *connection.ServerConnection) {
 	connectedClientsMutex.Lock()
@@ -3,8 +3,35 @@ package client
 // ClientMetadata contains metadata about the client for websocket communication
 type ClientMetadata struct {
 	// General
-	VaultName    string `json:"vault_name"`
-	Version      int    `json:"version"`
-	LastSync     int    `json:"last_sync"`
-	SyncStrategy int    `json:"sync_strategy"`
+	VaultName string `json:"vault_name"`
+	Version   int    `json:"version"`
+	LastSync  int    `json:"last_sync"`
+	// Sequence is the last event of the vault's event log the client has synced
+	Sequence      int64 `json:"sequence"`
+	SyncStrategy  int   `json:"sync_strategy"`
+	SyncDirection int   `json:"sync_direction"`
+	// Subscription contains the paths the client wants to sync
+	Subscription Subscription `json:"subscription"`
+}
+
+// Supported sync directions
+const (
+	// SyncDirectionBidirectional uploads local changes and downloads remote ones
+	SyncDirectionBidirectional = 1
+	// SyncDirectionDownloadOnly only mirrors the vault. Local edits are reverted or flagged, never uploaded
+	SyncDirectionDownloadOnly = 2
+	// SyncDirectionUploadOnly only publishes local changes. Remote changes are never downloaded
+	SyncDirectionUploadOnly = 3
+)
+
+// CanUpload returns true if the client is allowed to upload changes to the server
+// A SyncDirection of 0 is treated as bidirectional, for clients that did not specify one
+func (c ClientMetadata) CanUpload() bool {
+	return c.SyncDirection != SyncDirectionDownloadOnly
+}
+
+// CanDownload returns true if the client wants changes from the server
+// A SyncDirection of 0 is treated as bidirectional, for clients that did not specify one
+func (c ClientMetadata) CanDownload() bool {
+	return c.SyncDirection != SyncDirectionUploadOnly
 }
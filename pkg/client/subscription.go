@@ -0,0 +1,52 @@
+package client
+
+import "strings"
+
+// Subscription holds the path prefixes a device wants to sync.
+// Paths outside of the subscription are never sent to the device and never treated as deleted locally
+type Subscription struct {
+	// Include contains the path prefixes to sync. If empty, everything is included
+	Include []string `json:"include,omitempty"`
+	// Exclude contains the path prefixes to skip. Takes precedence over Include
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Matches returns true if the given path, relative to the vault, is part of the subscription
+func (s Subscription) Matches(path string) bool {
+	path = strings.Trim(path, "/")
+
+	for _, prefix := range s.Exclude {
+		if hasPathPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	if len(s.Include) == 0 {
+		return true
+	}
+
+	for _, prefix := range s.Include {
+		if hasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Equal returns true if both subscriptions contain the same prefixes in the same order
+func (s Subscription) Equal(other Subscription) bool {
+	return strings.Join(s.Include, "\x00") == strings.Join(other.Include, "\x00") &&
+		strings.Join(s.Exclude, "\x00") == strings.Join(other.Exclude, "\x00")
+}
+
+// hasPathPrefix checks if the path is the prefix itself or is located inside of it.
+// `notes` matches `notes` and `notes/todo.md`, but not `notes-old/todo.md`
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,30 @@
+package client
+
+import "testing"
+
+func TestSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription Subscription
+		path         string
+		want         bool
+	}{
+		{"Empty subscription", Subscription{}, "notes/todo.md", true},
+		{"Included folder", Subscription{Include: []string{"notes"}}, "notes/todo.md", true},
+		{"Included folder with slashes", Subscription{Include: []string{"/notes/"}}, "notes/todo.md", true},
+		{"Included file", Subscription{Include: []string{"notes/todo.md"}}, "notes/todo.md", true},
+		{"Not included", Subscription{Include: []string{"notes"}}, "work/todo.md", false},
+		{"Similar prefix", Subscription{Include: []string{"notes"}}, "notes-old/todo.md", false},
+		{"Excluded folder", Subscription{Exclude: []string{"archive"}}, "archive/2020.md", false},
+		{"Excluded inside included", Subscription{Include: []string{"notes"}, Exclude: []string{"notes/archive"}}, "notes/archive/2020.md", false},
+		{"Included next to excluded", Subscription{Include: []string{"notes"}, Exclude: []string{"notes/archive"}}, "notes/todo.md", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.subscription.Matches(tt.path); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package compression
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd is the name of the encoding, as used in the Content-Encoding and Accept-Encoding headers
+const Zstd = "zstd"
+
+// minSize is the size below which files are not worth compressing
+const minSize = 1024
+
+// ErrSizeMismatch is returned when decompressed contents are not the size they were declared to be
+var ErrSizeMismatch = errors.New("decompressed contents do not match the declared size")
+
+// compressedExtensions are file types that are already compressed, compressing them again only costs CPU
+var compressedExtensions = map[string]bool{
+	// Archives
+	".7z": true, ".br": true, ".bz2": true, ".gz": true, ".lz4": true, ".rar": true, ".tgz": true, ".xz": true, ".zip": true, ".zst": true,
+	// Images
+	".avif": true, ".gif": true, ".heic": true, ".jpeg": true, ".jpg": true, ".png": true, ".webp": true,
+	// Audio and video
+	".aac": true, ".flac": true, ".m4a": true, ".mkv": true, ".mov": true, ".mp3": true, ".mp4": true, ".ogg": true, ".opus": true, ".webm": true,
+	// Documents that are zip files or compress their contents
+	".docx": true, ".epub": true, ".odt": true, ".pdf": true, ".pptx": true, ".xlsx": true,
+}
+
+// ShouldCompress returns true if a file with the given path and size is worth compressing.
+// Small files and file types that are already compressed are sent as they are
+func ShouldCompress(filePath string, size int64) bool {
+	return size >= minSize && !compressedExtensions[strings.ToLower(path.Ext(filePath))]
+}
+
+// Accepts returns true if the Accept-Encoding header allows zstd
+func Accepts(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(name, Zstd) && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewWriter returns a writer that compresses everything written to it into w. Close it to flush the rest
+func NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+// NewReader returns a reader that decompresses r. Returns ErrSizeMismatch once more than maxSize bytes were decompressed,
+// so a small upload cannot fill the disk
+func NewReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedReader{decoder: decoder, remaining: maxSize}, nil
+}
+
+// limitedReader fails instead of stopping at the limit, unlike io.LimitReader
+type limitedReader struct {
+	decoder   *zstd.Decoder
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.decoder.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrSizeMismatch
+	}
+
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	r.decoder.Close()
+	return nil
+}
@@ -0,0 +1,107 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestShouldCompress(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		size int64
+		want bool
+	}{
+		{name: "note", path: "notes/todo.md", size: 4096, want: true},
+		{name: "no extension", path: "notes/README", size: 4096, want: true},
+		{name: "too small", path: "notes/todo.md", size: 100, want: false},
+		{name: "image", path: "attachments/cat.png", size: 1 << 20, want: false},
+		{name: "upper case extension", path: "attachments/CAT.JPG", size: 1 << 20, want: false},
+		{name: "archive", path: "backup.tar.gz", size: 1 << 20, want: false},
+		{name: "document", path: "papers/paper.pdf", size: 1 << 20, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldCompress(tt.path, tt.size); got != tt.want {
+				t.Errorf("ShouldCompress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           bool
+	}{
+		{name: "empty", acceptEncoding: "", want: false},
+		{name: "only zstd", acceptEncoding: "zstd", want: true},
+		{name: "list", acceptEncoding: "gzip, deflate, zstd", want: true},
+		{name: "with quality", acceptEncoding: "gzip;q=1.0, zstd;q=0.5", want: true},
+		{name: "refused", acceptEncoding: "gzip, zstd; q=0", want: false},
+		{name: "other encodings", acceptEncoding: "gzip, br", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Accepts(tt.acceptEncoding); got != tt.want {
+				t.Errorf("Accepts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader(t *testing.T) {
+	contents := strings.Repeat("# Todo\n- [ ] write the notes\n", 1000)
+
+	var compressed bytes.Buffer
+	writer, err := NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(writer, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if compressed.Len() >= len(contents) {
+		t.Errorf("compressed %d bytes into %d bytes", len(contents), compressed.Len())
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		wantErr error
+	}{
+		{name: "exact size", maxSize: int64(len(contents)), wantErr: nil},
+		{name: "larger than declared", maxSize: int64(len(contents)) - 1, wantErr: ErrSizeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(compressed.Bytes()), tt.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && string(got) != contents {
+				t.Errorf("ReadAll() returned %d bytes, want %d", len(got), len(contents))
+			}
+		})
+	}
+}
@@ -3,14 +3,22 @@ package database
 import "go.mongodb.org/mongo-driver/mongo"
 
 type collections struct {
-	UsersCollection *mongo.Collection
-	ItemCollection  *mongo.Collection
+	UsersCollection     *mongo.Collection
+	ItemCollection      *mongo.Collection
+	VaultsCollection    *mongo.Collection
+	AuditCollection     *mongo.Collection
+	EventsCollection    *mongo.Collection
+	SequencesCollection *mongo.Collection
 }
 
 // newCollections will create a new Collections container that will contain all the possible collections supported by gobi
 func newCollections(db *Database) collections {
 	return collections{
-		UsersCollection: db.Client.Database(db.DatabaseName).Collection("Users"),
-		ItemCollection:  db.Client.Database(db.DatabaseName).Collection("Items"),
+		UsersCollection:     db.Client.Database(db.DatabaseName).Collection("Users"),
+		ItemCollection:      db.Client.Database(db.DatabaseName).Collection("Items"),
+		VaultsCollection:    db.Client.Database(db.DatabaseName).Collection("Vaults"),
+		AuditCollection:     db.Client.Database(db.DatabaseName).Collection("Audit"),
+		EventsCollection:    db.Client.Database(db.DatabaseName).Collection("Events"),
+		SequencesCollection: db.Client.Database(db.DatabaseName).Collection("Sequences"),
 	}
 }
@@ -1,24 +1,56 @@
 package connection
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	processor_v1 "github.com/Michaelpalacce/gobi/pkg/gobi-client/processor/v1"
 	"github.com/Michaelpalacce/gobi/pkg/gobi-client/settings"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
 	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
 	"github.com/gorilla/websocket"
 )
 
+// requestTimeout is how long we wait for the server to reply to a request
+const requestTimeout = 30 * time.Second
+
+var (
+	// ErrServerShutdown is returned when the server told us that it is stopping. Reconnect after ReconnectAfter
+	ErrServerShutdown = errors.New("server is shutting down")
+	// ErrUnsupportedVersion is returned when the client is configured with a websocket version it does not support
+	ErrUnsupportedVersion = errors.New("unsupported websocket version")
+	// ErrUnknownVault is returned when the vault does not exist or the user is not a member of it. Vaults are not created on connect
+	ErrUnknownVault = errors.New("unknown vault")
+)
+
+// clientHello is every version, capability and encoding the client supports. Capabilities are only added once they are implemented
+var clientHello = messages.HelloPayload{
+	Versions:     []int{v1.Version},
+	Capabilities: messages.Capabilities{messages.CapabilityCompression},
+	Encodings:    []messages.Encoding{messages.EncodingCBOR, messages.EncodingJSON},
+}
+
 // ClientConnection handles the initial processing of the websocket messages and sends it off to the WebsocketClient to take care of them
 // Reconnections are not handled here, the surrounding code will have to handle that
 type ClientConnection struct {
 	WebsocketClient *socket.WebsocketClient
 	V1Processor     *processor_v1.Processor
 	LocalSettings   *settings.Store
+	Transfer        *transfer.Client
+
+	// Heartbeat configures how a server that went silent is detected
+	Heartbeat socket.Heartbeat
+
+	// ReconnectAfter is how long the server asked us to wait before reconnecting. Zero if it did not ask
+	ReconnectAfter time.Duration
+
+	// Encoding is the encoding we prefer. JSON is always offered as well. Empty prefers the first one in clientHello
+	Encoding messages.Encoding
 }
 
 // Listen requests information from the server and then listens for data
@@ -28,10 +60,13 @@ func (c *ClientConnection) Listen(closeChan chan<- error) {
 
 	c.initProcessors()
 
+	stopHeartbeat := c.WebsocketClient.StartHeartbeat(c.Heartbeat)
+	defer stopHeartbeat()
+
+	// The channels are never closed, the goroutine that did not finish first sends to them after we returned.
+	// Each sends at most once, so they never block on the buffer
 	initChan := make(chan error, 1)
 	readMessageChan := make(chan error, 1)
-	defer close(initChan)
-	defer close(readMessageChan)
 
 	go c.init(initChan)
 	go c.readMessage(readMessageChan)
@@ -46,27 +81,42 @@ func (c *ClientConnection) Listen(closeChan chan<- error) {
 
 // initProcessors will initialize the processors for the client
 func (c *ClientConnection) initProcessors() {
-	c.V1Processor = processor_v1.NewProcessor(c.WebsocketClient, c.LocalSettings)
+	c.V1Processor = processor_v1.NewProcessor(c.WebsocketClient, c.LocalSettings, c.Transfer)
 }
 
 // Close will gracefully close the connection. If an error ocurrs during closing, it will be ignored.
 func (c *ClientConnection) Close(msg string) {
+	if c.V1Processor != nil {
+		c.V1Processor.Close()
+	}
+
 	c.WebsocketClient.Close(msg)
 }
 
 // init will send the initial data to the server. Stuff like what version is being used and what is the name of the vault
+// The hello and the vault are sent as requests, so we know the server accepted them before going on
 // Supported versions:
 // - 1
 func (c *ClientConnection) init(initChan chan<- error) {
-	if err := c.WebsocketClient.SendMessage(messages.NewVersionMessage(c.WebsocketClient.Client.Version)); err != nil {
+	if err := c.hello(); err != nil {
 		initChan <- err
 		return
 	}
 
 	switch c.WebsocketClient.Client.Version {
 	case 1:
-		if err := c.WebsocketClient.SendMessage(v1.NewVaultNameMessage(c.WebsocketClient.Client.VaultName)); err != nil {
-			initChan <- err
+		response, err := c.WebsocketClient.Request(v1.NewVaultNameMessage(c.WebsocketClient.Client.VaultName), requestTimeout)
+		if err == nil {
+			err = v1.ErrorOf(response)
+		}
+
+		var protocolError *v1.Error
+		if errors.As(err, &protocolError) && protocolError.Code == v1.ErrorCodeUnknownVault {
+			err = fmt.Errorf("%w %s: %w", ErrUnknownVault, c.WebsocketClient.Client.VaultName, err)
+		}
+
+		if err != nil {
+			initChan <- fmt.Errorf("error while connecting to the vault: %w", err)
 			return
 		}
 
@@ -75,22 +125,105 @@ func (c *ClientConnection) init(initChan chan<- error) {
 			return
 		}
 
-		if err := c.WebsocketClient.SendMessage(v1.NewSyncMessage(c.WebsocketClient.Client.LastSync)); err != nil {
+		if err := c.WebsocketClient.SendMessage(v1.NewSyncDirectionMessage(c.WebsocketClient.Client.SyncDirection)); err != nil {
+			initChan <- err
+			return
+		}
+
+		if err := c.WebsocketClient.SendMessage(v1.NewSubscriptionMessage(c.WebsocketClient.Client.Subscription)); err != nil {
+			initChan <- err
+			return
+		}
+
+		if err := c.WebsocketClient.SendMessage(v1.NewSyncMessage(c.WebsocketClient.Client.LastSync, c.WebsocketClient.Client.Sequence)); err != nil {
 			initChan <- err
 			return
 		}
 	default:
-		initChan <- fmt.Errorf("unknown websocket version: %d", c.WebsocketClient.Client.Version)
+		initChan <- fmt.Errorf("%w: %d", ErrUnsupportedVersion, c.WebsocketClient.Client.Version)
+	}
+}
+
+// hello will agree with the server on the version, the capabilities and the encoding to use.
+// Servers that predate the hello reject it as an unknown type, those are sent the highest version we support instead
+func (c *ClientConnection) hello() error {
+	hello := clientHello
+	if c.Encoding != "" {
+		hello.Encodings = []messages.Encoding{c.Encoding, messages.EncodingJSON}
+	}
+
+	response, err := c.WebsocketClient.Request(messages.NewHelloMessage(hello), requestTimeout)
+
+	var ackError *messages.AckError
+	if errors.As(err, &ackError) {
+		switch ackError.Code {
+		case messages.AckCodeUnknownType:
+			return c.legacyVersion()
+		case messages.AckCodeUnknownVersion:
+			return fmt.Errorf("%w: %v, the server said %s", ErrUnsupportedVersion, clientHello.Versions, ackError.Message)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while sending hello: %w", err)
+	}
+
+	if response.Type != messages.HelloAckType {
+		return fmt.Errorf("%w: %s in reply to hello", messages.ErrUnknownType, response.Type)
+	}
+
+	var helloAck messages.HelloAckPayload
+	if err := response.Decode(&helloAck); err != nil {
+		return fmt.Errorf("error while unmarshaling hello ack: %w", err)
+	}
+
+	if !slices.Contains(clientHello.Versions, helloAck.Version) {
+		return fmt.Errorf("%w: the server picked %d", ErrUnsupportedVersion, helloAck.Version)
+	}
+
+	codec, ok := messages.CodecFor(helloAck.Encoding)
+	if !ok {
+		return fmt.Errorf("%w: the server picked the %s encoding", ErrUnsupportedVersion, helloAck.Encoding)
+	}
+
+	c.WebsocketClient.Client.Version = helloAck.Version
+	c.WebsocketClient.Capabilities = clientHello.Capabilities.Intersect(helloAck.Capabilities)
+	c.WebsocketClient.SetCodec(codec)
+	c.Transfer.SetCompression(c.WebsocketClient.Capabilities.Has(messages.CapabilityCompression))
+	slog.Info("Agreed on protocol with server", "version", helloAck.Version, "capabilities", c.WebsocketClient.Capabilities, "encoding", codec.Encoding())
+
+	return nil
+}
+
+// legacyVersion will tell a server that predates the hello which version we use. No capabilities are used with it
+func (c *ClientConnection) legacyVersion() error {
+	version := slices.Max(clientHello.Versions)
+
+	_, err := c.WebsocketClient.Request(messages.NewVersionMessage(version), requestTimeout)
+
+	var ackError *messages.AckError
+	if errors.As(err, &ackError) && ackError.Code == messages.AckCodeUnknownVersion {
+		return fmt.Errorf("%w: %d, the server said %s", ErrUnsupportedVersion, version, ackError.Message)
 	}
+
+	if err != nil {
+		return fmt.Errorf("error while sending the version: %w", err)
+	}
+
+	c.WebsocketClient.Client.Version = version
+	c.WebsocketClient.Capabilities = messages.Capabilities{}
+
+	return nil
 }
 
 // readMessage will continuously wait for incomming messages and process them for the given client
-// This function is blocking and will stop when Close is called
+// This function is blocking and will stop when Close is called. Sends nil if the server closed the connection gracefully
 func (c *ClientConnection) readMessage(readMessageChan chan<- error) {
 	var closeError error
 
 out:
 	for {
+		c.WebsocketClient.ExtendReadDeadline()
 		messageType, message, err := c.WebsocketClient.Conn.ReadMessage()
 		slog.Debug("Received message from server", "message", string(message), "messageType", messageType)
 
@@ -107,12 +240,8 @@ out:
 		}
 
 		switch messageType {
-		case websocket.TextMessage:
-			if closeError = c.processTextMessage(message); closeError != nil {
-				break out
-			}
-		case websocket.BinaryMessage:
-			if closeError = c.processBinaryMessage(message); closeError != nil {
+		case websocket.TextMessage, websocket.BinaryMessage:
+			if closeError = c.processMessage(messageType, message); closeError != nil {
 				break out
 			}
 		case websocket.PingMessage:
@@ -129,55 +258,85 @@ out:
 		}
 	}
 
+	// A graceful close is not an error
+	if closeError == nil {
+		readMessageChan <- nil
+		return
+	}
+
 	readMessageChan <- fmt.Errorf("error while communicating with server: %w", closeError)
 }
 
-// processTextMessage will process different types of text messages
-func (c *ClientConnection) processTextMessage(message []byte) error {
-	var websocketMessage messages.WebsocketMessage
-
-	if err := json.Unmarshal(message, &websocketMessage); err != nil {
+// processMessage will process different types of messages. Text messages are JSON, binary ones CBOR
+func (c *ClientConnection) processMessage(messageType int, message []byte) error {
+	websocketMessage, err := socket.Decode(messageType, message)
+	if err != nil {
 		return fmt.Errorf("error while unmarshaling websocket message %w", err)
 	}
 
+	if c.WebsocketClient.Resolve(websocketMessage) {
+		return nil
+	}
+
 	switch websocketMessage.Version {
 	case 0:
 		if err := c.processV0(websocketMessage); err != nil {
 			return err
 		}
 	case 1:
-		if err := c.V1Processor.ProcessClientTextMessage(websocketMessage); err != nil {
-			return err
-		}
+		return c.replyV1(websocketMessage, c.V1Processor.ProcessClientTextMessage(websocketMessage))
 	default:
-		return fmt.Errorf("unknown websocket version: %d", websocketMessage.Version)
+		return fmt.Errorf("%w: %d", messages.ErrUnknownVersion, websocketMessage.Version)
 	}
 
 	return nil
 }
 
-// processV0 since V0 are special, they are handled directly by the client.
-// V0 messages are client specific
-func (c *ClientConnection) processV0(websocketMessage messages.WebsocketMessage) error {
-	switch websocketMessage.Type {
-	default:
-		return fmt.Errorf("unknown websocket message type: %s for version 0", websocketMessage.Type)
+// replyV1 will tell the server why processing its message failed. Recoverable errors only failed that message, so the
+// connection stays open. Errors the server sent us are never answered, or both sides could keep replying to each other
+func (c *ClientConnection) replyV1(websocketMessage messages.WebsocketMessage, err error) error {
+	if err == nil || websocketMessage.Type == v1.ErrorType {
+		return err
 	}
-}
 
-// processBinaryMessage will process different types of binary messages
-// When processing the binary message we need to know where to store it
-func (c *ClientConnection) processBinaryMessage(message []byte) error {
-	if err := c.V1Processor.ProcessClientBinaryMessage(message); err != nil {
+	protocolError := v1.AsError(err)
+	if sendErr := c.WebsocketClient.SendPriorityMessage(v1.NewErrorMessage(websocketMessage.ID, protocolError)); sendErr != nil {
+		return sendErr
+	}
+
+	if !protocolError.Recoverable {
 		return err
 	}
 
+	slog.Warn("Error processing message from server", "type", websocketMessage.Type, "code", protocolError.Code, "error", err)
+
 	return nil
 }
 
+// processV0 since V0 are special, they are handled directly by the client.
+// V0 messages are client specific
+func (c *ClientConnection) processV0(websocketMessage messages.WebsocketMessage) error {
+	switch websocketMessage.Type {
+	// Called when the server is stopping. Whatever we were doing is done, since messages are processed one by one
+	case messages.ServerShutdownType:
+		var shutdownPayload messages.ServerShutdownPayload
+
+		if err := websocketMessage.Decode(&shutdownPayload); err != nil {
+			return err
+		}
+
+		c.ReconnectAfter = time.Duration(shutdownPayload.ReconnectAfter) * time.Second
+		slog.Info("Server is shutting down", "reason", shutdownPayload.Reason, "reconnectAfter", c.ReconnectAfter)
+
+		return ErrServerShutdown
+	default:
+		return fmt.Errorf("%w: %s for version 0", messages.ErrUnknownType, websocketMessage.Type)
+	}
+}
+
 // processPingMessage will send a PongMessage and nothing else
 func (c *ClientConnection) processPingMessage(message []byte) error {
-	if err := c.WebsocketClient.Conn.WriteMessage(websocket.PongMessage, []byte("")); err != nil {
+	if err := c.WebsocketClient.SendPong(); err != nil {
 		return fmt.Errorf("error sending message: %w", err)
 	}
 
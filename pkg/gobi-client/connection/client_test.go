@@ -0,0 +1,59 @@
+package connection
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/settings"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
+	"github.com/Michaelpalacce/gobi/pkg/socket"
+	"github.com/gorilla/websocket"
+)
+
+// TestListenServerClosesBeforeHello makes sure the hello that is still waiting for a reply does not send to a channel
+// that was closed, once the read loop stopped and the connection was closed
+func TestListenServerClosesBeforeHello(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("error upgrading connection: %v", err)
+			return
+		}
+
+		// Wait for the hello, but never answer it
+		_, _, _ = conn.ReadMessage()
+		_ = conn.Close()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error dialing server: %v", err)
+	}
+
+	client := &ClientConnection{
+		WebsocketClient: &socket.WebsocketClient{Conn: conn},
+		LocalSettings:   &settings.Store{},
+		Transfer:        &transfer.Client{},
+	}
+
+	closeChan := make(chan error, 1)
+	go client.Listen(closeChan)
+
+	select {
+	case err := <-closeChan:
+		if err == nil {
+			t.Error("Listen() = nil, want the error of the closed connection")
+		}
+	case <-time.After(requestTimeout):
+		t.Fatal("Listen() did not return after the server closed the connection")
+	}
+
+	// Closing fails the hello that is still waiting, which reports it after Listen returned
+	client.Close("")
+	time.Sleep(100 * time.Millisecond)
+}
@@ -1,5 +1,10 @@
 package gobiclient
 
+import (
+	"github.com/Michaelpalacce/gobi/pkg/client"
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+)
+
 type Options struct {
 	// Required
 	Username         string
@@ -8,5 +13,22 @@ type Options struct {
 	VaultName        string
 	VaultPath        string
 	SyncStrategy     int
+	SyncDirection    int
+	Subscription     client.Subscription
 	WebsocketVersion int
+
+	// Encoding is the encoding to prefer for websocket messages
+	Encoding messages.Encoding
+
+	// Overrides contains the options that were set explicitly, like OptionSyncDirection.
+	// They replace the persisted settings, the rest of the options are only used when the settings are first created
+	Overrides map[string]bool
 }
+
+const (
+	OptionVaultName     = "vaultName"
+	OptionSyncStrategy  = "syncStrategy"
+	OptionSyncDirection = "syncDirection"
+	OptionInclude       = "include"
+	OptionExclude       = "exclude"
+)
@@ -2,21 +2,51 @@ package processor_v1
 
 import (
 	"log/slog"
+	"maps"
+	"sync"
+	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/gobi-client/settings"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
 )
 
+// versionsSaveDelay is how long changes from the watcher are collected, before the known versions are saved
+const versionsSaveDelay = 2 * time.Second
+
 // Processor is the processor for version 1 of the protocol
 // It contains the business logic for the protocol
 type Processor struct {
 	WebsocketClient *socket.WebsocketClient
 	LocalSettings   *settings.Store
+	Transfer        *transfer.Client
 	SessionID       string
+
+	// known contains the last version of each item we synced with the server, keyed by path.
+	// Used to skip changes we made ourselves, to detect conflicts and to revert local edits in download-only mode.
+	// It is persisted in the settings store, so it survives restarts
+	known map[string]models.Item
+	// fetching contains the paths that are being written with the server version. The watcher skips them, since
+	// their contents only match the known version once the write is done
+	fetching   map[string]bool
+	knownMutex sync.Mutex
+
+	// savePending is set while a save of the known versions is scheduled
+	savePending bool
+	saveTimer   *time.Timer
+	saveMutex   sync.Mutex
+
+	// usage is the storage usage the server last told us about. Nil until the first sync
+	usage      *models.QuotaUsage
+	usageMutex sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewProcessor will create a new processor with the selected sync strategy in the client
-func NewProcessor(client *socket.WebsocketClient, localSettings *settings.Store) *Processor {
+func NewProcessor(client *socket.WebsocketClient, localSettings *settings.Store, transfer *transfer.Client) *Processor {
 	switch client.Client.SyncStrategy {
 	default:
 		slog.Info("Using LastModifiedTimeSyncStrategy")
@@ -25,5 +55,25 @@ func NewProcessor(client *socket.WebsocketClient, localSettings *settings.Store)
 	return &Processor{
 		WebsocketClient: client,
 		LocalSettings:   localSettings,
+		Transfer:        transfer,
+		known:           maps.Clone(localSettings.Versions),
+		fetching:        make(map[string]bool),
+		done:            make(chan struct{}),
 	}
 }
+
+// Close will stop watching the vault and save the known versions, if a save is still scheduled
+func (p *Processor) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		p.saveMutex.Lock()
+		pending := p.savePending && p.saveTimer.Stop()
+		p.savePending = false
+		p.saveMutex.Unlock()
+
+		if pending {
+			p.saveVersions()
+		}
+	})
+}
@@ -0,0 +1,536 @@
+package processor_v1
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/settings"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
+	"github.com/Michaelpalacce/gobi/pkg/iops"
+	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+)
+
+// processQueue will download or delete all the items the server has changed
+// Returns false if any of the items failed
+func (p *Processor) processQueue() bool {
+	ok := true
+	storageDriver := p.WebsocketClient.StorageDriver
+
+	for storageDriver.HasItemsToProcess(storage.ConflictModeNo) {
+		item := storageDriver.GetNext(storage.ConflictModeNo)
+
+		if err := p.apply(*item); err != nil {
+			slog.Error("Error syncing item from server", "path", item.ServerPath, "error", err)
+			p.reportItemError(item.ServerPath, err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// apply will download or delete the item and remember it as the version in sync with the server.
+// The watcher skips the path until then, so our own write is not mistaken for a local change
+func (p *Processor) apply(item models.Item) error {
+	p.setFetching(item.ServerPath, true)
+	defer p.setFetching(item.ServerPath, false)
+
+	var err error
+	if item.Deleted {
+		slog.Info("Deleting item", "path", item.ServerPath)
+		err = p.WebsocketClient.StorageDriver.Delete(item)
+	} else {
+		err = p.fetch(item)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p.remember(item)
+
+	return nil
+}
+
+// fetch will bring the local item in line with the server version.
+// Directories and symlinks are created locally and files are only downloaded if their contents differ
+func (p *Processor) fetch(item models.Item) error {
+	storageDriver := p.WebsocketClient.StorageDriver
+
+	if item.IsFile() && storageDriver.CalculateSHA256(item) != item.SHA256 {
+		slog.Info("Downloading item", "path", item.ServerPath)
+		return p.Transfer.Download(item, storageDriver)
+	}
+
+	slog.Info("Updating item", "path", item.ServerPath, "kind", item.Kind)
+	if err := storageDriver.Apply(item); err != nil {
+		return err
+	}
+
+	return storageDriver.Touch(item)
+}
+
+// uploadConflicts will resolve all items that were changed both locally and on the server.
+// Local edits of items the server deleted are uploaded again, for all others the local edit is kept as a conflict copy
+// Returns false if any of the items failed
+func (p *Processor) uploadConflicts() bool {
+	ok := true
+
+	for _, conflict := range p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeYes) {
+		var err error
+		if conflict.Deleted {
+			err = p.restoreDeleted(conflict)
+		} else {
+			err = p.keepConflictCopy(conflict)
+		}
+
+		if err != nil {
+			slog.Error("Error resolving conflict", "path", conflict.ServerPath, "error", err)
+			p.reportItemError(conflict.ServerPath, err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// restoreDeleted will upload the local edit of an item that was deleted on the server, so the edit is not lost
+func (p *Processor) restoreDeleted(tombstone models.Item) error {
+	local, err := p.WebsocketClient.StorageDriver.Stat(tombstone)
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("Item was deleted on the server, but changed locally, uploading it again", "path", tombstone.ServerPath)
+
+	// The upload is based on the delete, otherwise the server refuses it as based on the version before it
+	p.remember(tombstone)
+
+	if err := p.upload(local); err != nil {
+		return err
+	}
+
+	p.reportConflict(tombstone.ServerPath, v1.ConflictResolutionLocalWins)
+
+	return nil
+}
+
+// keepConflictCopy will move the local edit of the item aside as a conflict copy and upload the copy as a new item,
+// then bring the item in line with the version on the server. Neither edit is lost, the user can merge them by hand.
+// The server version is fetched even by upload-only clients, so the path is not left empty.
+// Directories have no contents to keep, so the server version of them wins
+func (p *Processor) keepConflictCopy(server models.Item) error {
+	storageDriver := p.WebsocketClient.StorageDriver
+
+	local, err := storageDriver.Stat(server)
+	if err != nil {
+		return err
+	}
+
+	if local.IsDir() {
+		if !server.IsDir() {
+			return fmt.Errorf("%s is a directory locally, but not on the server, resolve the conflict by hand", server.ServerPath)
+		}
+
+		if err := p.apply(server); err != nil {
+			return err
+		}
+
+		p.reportConflict(server.ServerPath, v1.ConflictResolutionServerWins)
+
+		return nil
+	}
+
+	conflictCopy := local
+	conflictCopy.ServerPath = conflictCopyPath(server.ServerPath, time.Now())
+
+	// The watcher must not mistake the move for a local delete of the item and a new item
+	p.setFetching(server.ServerPath, true)
+	p.setFetching(conflictCopy.ServerPath, true)
+	defer p.setFetching(server.ServerPath, false)
+	defer p.setFetching(conflictCopy.ServerPath, false)
+
+	slog.Warn("Item was changed locally and on the server, keeping the local edit as a conflict copy", "path", server.ServerPath, "copy", conflictCopy.ServerPath)
+	if err := storageDriver.Rename(local, conflictCopy); err != nil {
+		return err
+	}
+
+	if err := p.apply(server); err != nil {
+		return err
+	}
+
+	if err := p.upload(conflictCopy); err != nil {
+		return err
+	}
+
+	if err := p.WebsocketClient.SendMessage(v1.NewConflictCopyMessage(server.ServerPath, conflictCopy.ServerPath)); err != nil {
+		slog.Warn("Error reporting conflict to the server", "path", server.ServerPath, "error", err)
+	}
+
+	return nil
+}
+
+// conflictCopyPath returns the path of the conflict copy of the item, next to it with the time in its name
+func conflictCopyPath(itemPath string, now time.Time) string {
+	dir, name := path.Split(itemPath)
+
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+
+	return fmt.Sprintf("%s%s (conflict %s)%s", dir, strings.TrimSuffix(name, ext), now.Format("2006-01-02 150405"), ext)
+}
+
+// uploadLocalChanges will upload all local changes, except the ones the server sent us, as they were already handled
+// Returns false if any of the items failed
+func (p *Processor) uploadLocalChanges(localChanges []models.Item, serverItems []models.Item) bool {
+	ok := true
+
+	serverPaths := make(map[string]bool, len(serverItems))
+	for _, item := range serverItems {
+		serverPaths[item.ServerPath] = true
+	}
+
+	for _, item := range localChanges {
+		if serverPaths[item.ServerPath] {
+			continue
+		}
+
+		if err := p.upload(item); err != nil {
+			slog.Error("Error uploading item", "path", item.ServerPath, "error", err)
+			p.reportItemError(item.ServerPath, err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// upload will send the item to the server and remember the version the server stored.
+// The version we last synced is sent along, so the server can detect that the item was changed by somebody else meanwhile
+// If the server renamed the item, because it collides with another one, the local item is renamed as well.
+// If somebody else changed the item on the server meanwhile, the local edit is kept as a conflict copy, if they deleted it, it is restored.
+// Items that would go over the quota are not sent at all
+func (p *Processor) upload(item models.Item) error {
+	if err := p.checkQuota(item); err != nil {
+		return err
+	}
+
+	slog.Info("Uploading item", "path", item.ServerPath)
+
+	// The server uses the sequence to tell if somebody else changed the item since we last synced it
+	previous, hadPrevious := p.getKnown(item.ServerPath)
+	if hadPrevious {
+		item.Sequence = previous.Sequence
+	}
+
+	uploaded, err := p.Transfer.Upload(item, p.WebsocketClient.StorageDriver)
+	if errors.Is(err, transfer.ErrItemChanged) {
+		if uploaded.Deleted {
+			return p.restoreDeleted(*uploaded)
+		}
+
+		return p.keepConflictCopy(*uploaded)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p.recordUpload(previous, hadPrevious, *uploaded)
+
+	if uploaded.ServerPath != item.ServerPath {
+		slog.Warn("Item collides with another item on the server and was renamed", "path", item.ServerPath, "renamed", uploaded.ServerPath)
+		if err := p.WebsocketClient.StorageDriver.Rename(item, *uploaded); err != nil {
+			return err
+		}
+	}
+
+	p.remember(*uploaded)
+
+	return nil
+}
+
+// startWatching will watch the vault for local changes until the processor is closed
+func (p *Processor) startWatching() {
+	changeChan := make(chan *models.Item)
+
+	go func() {
+		if err := p.WebsocketClient.StorageDriver.WatchVault(p.WebsocketClient.Client.VaultName, changeChan, p.done); err != nil {
+			slog.Error("Error watching vault", "error", err)
+		}
+	}()
+
+	slog.Info("Starting to watch vault", "vaultName", p.WebsocketClient.Client.VaultName)
+
+	go func() {
+		for {
+			select {
+			case item := <-changeChan:
+				p.processLocalChange(*item)
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// processLocalChange will send a change detected by the watcher to the server.
+// Changes we made ourselves while syncing are skipped. Download-only clients revert the change instead
+func (p *Processor) processLocalChange(item models.Item) {
+	if !p.WebsocketClient.Client.Subscription.Matches(item.ServerPath) {
+		return
+	}
+
+	if p.isFetching(item.ServerPath) {
+		return
+	}
+
+	known, isKnown := p.getKnown(item.ServerPath)
+	if isKnown && known.SameContent(item) {
+		return
+	}
+
+	if !p.WebsocketClient.Client.CanUpload() {
+		p.revertLocalChange(item, known, isKnown)
+		return
+	}
+
+	var err error
+	if item.Deleted {
+		slog.Info("Deleting item on server", "path", item.ServerPath)
+		if err = p.Transfer.Delete(item); err == nil {
+			p.remember(item)
+		}
+	} else {
+		err = p.upload(item)
+	}
+
+	if err != nil {
+		slog.Error("Error syncing local change", "path", item.ServerPath, "error", err)
+		p.reportItemError(item.ServerPath, err)
+	}
+
+	p.scheduleSaveVersions()
+}
+
+// revertLocalChange will restore the version we last synced with the server, or flag the item if the server does not have it
+func (p *Processor) revertLocalChange(item models.Item, known models.Item, isKnown bool) {
+	if !isKnown || known.Deleted {
+		if err := p.flagLocalChanges([]models.Item{item}, nil); err != nil {
+			slog.Error("Error flagging local change", "path", item.ServerPath, "error", err)
+		}
+
+		return
+	}
+
+	slog.Warn("Reverting local edit, client is download-only", "path", item.ServerPath)
+	if err := p.fetch(known); err != nil {
+		slog.Error("Error reverting local change", "path", item.ServerPath, "error", err)
+		return
+	}
+
+	p.reportConflict(item.ServerPath, v1.ConflictResolutionServerWins)
+}
+
+// remember stores the version of the item that is in sync with the server
+func (p *Processor) remember(item models.Item) {
+	p.knownMutex.Lock()
+	defer p.knownMutex.Unlock()
+
+	p.known[item.ServerPath] = item
+}
+
+// getKnown returns the version of the item that is in sync with the server, if we have one
+func (p *Processor) getKnown(path string) (models.Item, bool) {
+	p.knownMutex.Lock()
+	defer p.knownMutex.Unlock()
+
+	item, ok := p.known[path]
+
+	return item, ok
+}
+
+// setFetching marks the path as being written with the server version, or clears the mark
+func (p *Processor) setFetching(path string, fetching bool) {
+	p.knownMutex.Lock()
+	defer p.knownMutex.Unlock()
+
+	if fetching {
+		p.fetching[path] = true
+	} else {
+		delete(p.fetching, path)
+	}
+}
+
+// isFetching returns true if the path is being written with the server version
+func (p *Processor) isFetching(path string) bool {
+	p.knownMutex.Lock()
+	defer p.knownMutex.Unlock()
+
+	return p.fetching[path]
+}
+
+// saveVersions will persist the known versions, so local edits can still be told apart from server changes after a restart
+func (p *Processor) saveVersions() {
+	p.knownMutex.Lock()
+	versions := maps.Clone(p.known)
+	p.knownMutex.Unlock()
+
+	if err := p.LocalSettings.SaveVersions(versions); err != nil {
+		slog.Error("Error saving versions", "error", err)
+	}
+}
+
+// scheduleSaveVersions will save the known versions after versionsSaveDelay, unless a save is already scheduled.
+// The watcher reports every change on its own, so a burst of changes writes the versions only once
+func (p *Processor) scheduleSaveVersions() {
+	p.saveMutex.Lock()
+	defer p.saveMutex.Unlock()
+
+	if p.savePending {
+		return
+	}
+
+	p.savePending = true
+	p.saveTimer = time.AfterFunc(versionsSaveDelay, func() {
+		p.saveMutex.Lock()
+		p.savePending = false
+		p.saveMutex.Unlock()
+
+		p.saveVersions()
+	})
+}
+
+// saveLastSync will persist the time of the last successful sync and the last event of the event log it covered.
+// A sequence of 0 keeps the current one, since changes sent while connected can arrive out of order and only a sync
+// response guarantees that every event up to its sequence was seen
+func (p *Processor) saveLastSync(lastSync int64, sequence int64) error {
+	p.WebsocketClient.Client.LastSync = int(lastSync)
+	if sequence > 0 {
+		p.WebsocketClient.Client.Sequence = sequence
+	}
+
+	return p.LocalSettings.UpdateSync(func(sync *settings.SyncData) {
+		sync.LastSync = int(lastSync)
+		if sequence > 0 {
+			sync.Sequence = sequence
+		}
+	})
+}
+
+// filterSubscribed returns only the items that are part of the client's subscription.
+// Items outside of it are neither downloaded nor uploaded. Since they are never synced, their absence locally
+// must never be treated as a deletion
+func (p *Processor) filterSubscribed(items []models.Item) []models.Item {
+	filtered := make([]models.Item, 0, len(items))
+	for _, item := range items {
+		if p.WebsocketClient.Client.Subscription.Matches(item.ServerPath) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered
+}
+
+// skipCollisions returns the items without the ones whose path only differs in case or unicode normalization from
+// another item. Only the first item of every such group is kept, as case-insensitive filesystems can only store one of them
+func (p *Processor) skipCollisions(items []models.Item) []models.Item {
+	paths := make([]string, 0, len(items))
+	for _, item := range items {
+		if !item.Deleted {
+			paths = append(paths, item.ServerPath)
+		}
+	}
+
+	skipped := make(map[string]bool)
+	for _, collision := range iops.FindCollisions(paths) {
+		slog.Warn("Items collide with each other, only the first one will be synced", "paths", collision)
+		for _, path := range collision[1:] {
+			skipped[path] = true
+		}
+	}
+
+	filtered := make([]models.Item, 0, len(items))
+	for _, item := range items {
+		if !skipped[item.ServerPath] {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered
+}
+
+// revertConflicts will make the server version win for all items that were changed both locally and on the server
+func (p *Processor) revertConflicts() {
+	conflicts := p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeYes)
+	for _, conflict := range conflicts {
+		slog.Warn("Reverting local edit, client is download-only", "path", conflict.ServerPath)
+		p.reportConflict(conflict.ServerPath, v1.ConflictResolutionServerWins)
+	}
+
+	p.WebsocketClient.StorageDriver.Requeue(conflicts)
+}
+
+// flagLocalChanges will flag all local changes that the server did not send us, as they cannot be uploaded.
+// The flagged paths are persisted in the sync file, so the user can decide what to do with them
+func (p *Processor) flagLocalChanges(localChanges []models.Item, serverItems []models.Item) error {
+	serverPaths := make(map[string]bool, len(serverItems))
+	for _, item := range serverItems {
+		serverPaths[item.ServerPath] = true
+	}
+
+	var flagged []string
+	err := p.LocalSettings.UpdateSync(func(sync *settings.SyncData) {
+		for _, item := range localChanges {
+			if serverPaths[item.ServerPath] || slices.Contains(sync.Flagged, item.ServerPath) {
+				continue
+			}
+
+			slog.Warn("Local edit will not be uploaded, client is download-only", "path", item.ServerPath)
+			sync.Flagged = append(sync.Flagged, item.ServerPath)
+			flagged = append(flagged, item.ServerPath)
+		}
+	})
+
+	for _, path := range flagged {
+		p.reportConflict(path, v1.ConflictResolutionFlagged)
+	}
+
+	return err
+}
+
+// reportConflict will tell the server how we resolved a conflict, so it ends up in the audit log
+func (p *Processor) reportConflict(path string, resolution v1.ConflictResolution) {
+	if err := p.WebsocketClient.SendMessage(v1.NewConflictMessage(path, resolution)); err != nil {
+		slog.Warn("Error reporting conflict to the server", "path", path, "error", err)
+	}
+}
+
+// reportItemError will tell the server that syncing the item failed, if the server can do something about it.
+// Local problems, like a full disk, are only logged. The sync goes on with the other items either way
+func (p *Processor) reportItemError(path string, err error) {
+	var code v1.ErrorCode
+
+	switch {
+	case errors.Is(err, transfer.ErrChecksumMismatch):
+		code = v1.ErrorCodeChecksumMismatch
+	case errors.Is(err, transfer.ErrQuotaExceeded):
+		code = v1.ErrorCodeQuotaExceeded
+	default:
+		return
+	}
+
+	if sendErr := p.WebsocketClient.SendMessage(v1.NewErrorMessage("", v1.NewItemError(code, path, err))); sendErr != nil {
+		slog.Warn("Error reporting item error to the server", "path", path, "error", sendErr)
+	}
+}
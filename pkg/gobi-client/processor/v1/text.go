@@ -1,13 +1,15 @@
 package processor_v1
 
 import (
-	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/Michaelpalacce/gobi/pkg/client"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
 	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
 	"github.com/Michaelpalacce/gobi/pkg/messages/v1/rest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/storage"
 )
 
@@ -19,41 +21,39 @@ func (p *Processor) ProcessClientTextMessage(websocketMessage messages.Websocket
 		if err := p.processSyncMessage(websocketMessage); err != nil {
 			return err
 		}
+	// Called when the server sends the items that have changed
+	case v1.SyncData:
+		if err := p.processSyncDataMessage(websocketMessage); err != nil {
+			return err
+		}
+	// Called when the server tells us how much storage is used
+	case v1.UsageType:
+		if err := p.processUsageMessage(websocketMessage); err != nil {
+			return err
+		}
 	case rest.SessionType:
 		if err := p.processSessionMessage(websocketMessage); err != nil {
 			return err
 		}
+	// Called when the server could not process one of our messages
+	case v1.ErrorType:
+		if err := p.processErrorMessage(websocketMessage); err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("unknown websocket message type: %s for version 1", websocketMessage.Type)
+		return fmt.Errorf("%w: %s for version 1", messages.ErrUnknownType, websocketMessage.Type)
 	}
 
 	return nil
 }
 
-// if p.WebsocketClient.InitialSync {
-// 	p.WebsocketClient.InitialSync = false
-//
-// 	changeChan := make(chan *models.Item)
-//
-// 	go p.WebsocketClient.StorageDriver.WatchVault(p.WebsocketClient.Client.VaultName, changeChan)
-// 	slog.Info("Starting to watch vault", "vaultName", p.WebsocketClient.Client.VaultName)
-//
-// 	go func() {
-// 		// @TODO: send changes to the server
-// 		// for item := range changeChan {
-// 		// }
-// 	}()
-// }
-//
-// return nil
-
 // processSyncMessage is a request from the server that it wants to sync.
-// The client will send all items that have been modified since the last sync (provided by the server)
+// The client will upload all items that have been modified since the last sync (provided by the server)
 // @NOTE: Should this use the sync strategy?
 func (p *Processor) processSyncMessage(websocketMessage messages.WebsocketMessage) error {
 	var syncPayload v1.SyncPayload
 
-	if err := json.Unmarshal(websocketMessage.Payload, &syncPayload); err != nil {
+	if err := websocketMessage.Decode(&syncPayload); err != nil {
 		return err
 	}
 
@@ -62,25 +62,123 @@ func (p *Processor) processSyncMessage(websocketMessage messages.WebsocketMessag
 		p.WebsocketClient.Client.VaultName,
 	)
 
-	items := p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeNo)
+	items := p.filterSubscribed(p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeNo))
 
 	slog.Debug("Items found for sync since last reconcillation", "items", len(items), "lastSync", syncPayload.LastSync)
-	// @TODO: Send new message telling the client the changed files
-	// p.WebsocketClient.SendMessage()
+
+	p.uploadLocalChanges(items, nil)
+	p.saveVersions()
+
+	return nil
+}
+
+// processSyncDataMessage will sync the items that the server has changed.
+// Items that match what we have locally are skipped, items that were changed locally as well are marked as conflicts.
+// On the initial sync, local changes since the last sync are uploaded as well and the vault starts being watched.
+// Download-only clients revert local edits to items the server knows about and flag the rest
+func (p *Processor) processSyncDataMessage(websocketMessage messages.WebsocketMessage) error {
+	var syncDataPayload v1.SyncDataPayload
+
+	if err := websocketMessage.Decode(&syncDataPayload); err != nil {
+		return err
+	}
+
+	syncStart := time.Now().Unix()
+	initialSync := p.WebsocketClient.InitialSync
+
+	var localChanges []models.Item
+
+	// Local changes must be collected before the server items are enqueued, as they share the same queue
+	if initialSync {
+		p.WebsocketClient.InitialSync = false
+		p.WebsocketClient.StorageDriver.EnqueueItemsSince(
+			p.WebsocketClient.Client.LastSync,
+			p.WebsocketClient.Client.VaultName,
+		)
+
+		localChanges = p.filterSubscribed(p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeNo))
+	}
+
+	// The server already filters by our subscription, this is in case it changed while the message was in flight
+	serverItems := p.skipCollisions(p.filterSubscribed(syncDataPayload.Items))
+	if p.WebsocketClient.Client.CanDownload() {
+		p.WebsocketClient.StorageDriver.Enqueue(serverItems, p.getKnown)
+	}
+
+	slog.Debug("Received items from server", "items", len(serverItems))
+
+	ok := true
+
+	if p.WebsocketClient.Client.SyncDirection == client.SyncDirectionDownloadOnly {
+		p.revertConflicts()
+
+		if err := p.flagLocalChanges(localChanges, serverItems); err != nil {
+			return err
+		}
+	} else {
+		ok = p.uploadConflicts() && ok
+		ok = p.uploadLocalChanges(localChanges, serverItems) && ok
+	}
+
+	ok = p.processQueue() && ok
+
+	p.saveVersions()
+
+	// If anything failed, we don't move the last sync forward so it's retried next time
+	if ok {
+		if err := p.saveLastSync(syncStart, syncDataPayload.Sequence); err != nil {
+			return err
+		}
+	}
+
+	if initialSync {
+		p.startWatching()
+	}
+
+	p.logTransferStats()
 
 	return nil
 }
 
+// logTransferStats will log how many files were transferred since we connected and how well they were compressed
+func (p *Processor) logTransferStats() {
+	stats := p.Transfer.Stats()
+	if stats.Files == 0 {
+		return
+	}
+
+	slog.Info("Transfer stats", "files", stats.Files, "compressed", stats.Compressed, "bytes", stats.Bytes, "wireBytes", stats.WireBytes, "ratio", fmt.Sprintf("%.2f", stats.Ratio()))
+}
+
 // processSessionMessage will process the session message from the server
 func (p *Processor) processSessionMessage(websocketMessage messages.WebsocketMessage) error {
 	var sessionPayload rest.SessionPayload
 
-	if err := json.Unmarshal(websocketMessage.Payload, &sessionPayload); err != nil {
+	if err := websocketMessage.Decode(&sessionPayload); err != nil {
 		return err
 	}
 
 	p.SessionID = sessionPayload.SessionId
+	p.Transfer.SessionID = sessionPayload.SessionId
 	slog.Debug("Received session message", "sessionID", sessionPayload.SessionId)
 
 	return nil
 }
+
+// processErrorMessage will log an error the server ran into while processing one of our messages.
+// Recoverable errors only failed that message, fatal ones are returned so the connection is closed
+func (p *Processor) processErrorMessage(websocketMessage messages.WebsocketMessage) error {
+	var errorPayload v1.ErrorPayload
+
+	if err := websocketMessage.Decode(&errorPayload); err != nil {
+		return err
+	}
+
+	if !errorPayload.Recoverable {
+		return errorPayload.Err()
+	}
+
+	slog.Warn("Server could not process a message", "code", errorPayload.Code, "path", errorPayload.Path, "error", errorPayload.Message)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package processor_v1
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/transfer"
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+// usageWarningRatio is how full a quota must be before the user is warned
+const usageWarningRatio = 0.9
+
+// processUsageMessage will store how much storage is used on the server and warn if a quota is almost full
+func (p *Processor) processUsageMessage(websocketMessage messages.WebsocketMessage) error {
+	var usagePayload v1.UsagePayload
+
+	if err := websocketMessage.Decode(&usagePayload); err != nil {
+		return err
+	}
+
+	usage := usagePayload.Usage
+
+	p.usageMutex.Lock()
+	p.usage = &usage
+	p.usageMutex.Unlock()
+
+	for name, quota := range map[string]models.Usage{"user": usage.User, "vault": usage.Vault} {
+		if quota.Ratio() >= usageWarningRatio {
+			slog.Warn("Storage quota is almost full", "quota", name, "used", quota.UsedBytes, "limit", quota.QuotaBytes)
+		}
+	}
+
+	return nil
+}
+
+// checkQuota will return transfer.ErrQuotaExceeded if uploading the item would go over a quota the server told us about.
+// Replacing an item we already synced only counts the difference in size
+func (p *Processor) checkQuota(item models.Item) error {
+	p.usageMutex.Lock()
+	defer p.usageMutex.Unlock()
+
+	if p.usage == nil {
+		return nil
+	}
+
+	size := int64(item.Size)
+	if known, ok := p.getKnown(item.ServerPath); ok {
+		size -= int64(known.Size)
+	}
+
+	if size > 0 && !p.usage.Allows(size) {
+		return fmt.Errorf("%w: %s needs %d bytes, %d bytes remaining", transfer.ErrQuotaExceeded, item.ServerPath, size, p.usage.Remaining())
+	}
+
+	return nil
+}
+
+// recordUpload will update the usage we know of after an item was uploaded, until the server sends the next one
+func (p *Processor) recordUpload(previous models.Item, hadPrevious bool, uploaded models.Item) {
+	p.usageMutex.Lock()
+	defer p.usageMutex.Unlock()
+
+	if p.usage == nil {
+		return
+	}
+
+	size := int64(uploaded.Size)
+	if hadPrevious {
+		size -= int64(previous.Size)
+	}
+
+	p.usage.User.UsedBytes += size
+	p.usage.Vault.UsedBytes += size
+}
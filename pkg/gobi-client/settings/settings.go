@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"github.com/Michaelpalacce/gobi/pkg/client"
 )
 
 type SettingsData struct {
@@ -11,6 +13,9 @@ type SettingsData struct {
 	VaultName        string `json:"vaultName,omitempty"`
 	WebsocketVersion int    `json:"websocketVersion,omitempty"`
 	SyncStrategy     int    `json:"syncStrategy,omitempty"`
+	SyncDirection    int    `json:"syncDirection,omitempty"`
+	// Subscription contains the path prefixes this device syncs. Empty means the whole vault
+	Subscription client.Subscription `json:"subscription"`
 }
 
 // readSettings reads and then returns the settings from the given path
@@ -37,7 +42,7 @@ func writeSettings(path string, settings *SettingsData) error {
 		return fmt.Errorf("error marshalling settings: %w", err)
 	}
 
-	err = os.WriteFile(path, settingsBytes, 0o640)
+	err = writeFileAtomic(path, settingsBytes, 0o640)
 	if err != nil {
 		return fmt.Errorf("error writing settings file: %w", err)
 	}
@@ -2,9 +2,15 @@ package settings
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 
+	"github.com/Michaelpalacce/gobi/pkg/client"
 	gobiclient "github.com/Michaelpalacce/gobi/pkg/gobi-client"
+	"github.com/Michaelpalacce/gobi/pkg/ignore"
 )
 
 type Store struct {
@@ -13,6 +19,10 @@ type Store struct {
 
 	Settings *SettingsData
 	Sync     *SyncData
+	Versions Versions
+
+	// mutex guards Sync and Versions, which are changed by the sync and the watcher at the same time
+	mutex sync.Mutex
 }
 
 // NewStore creates a new Store
@@ -24,6 +34,7 @@ func NewStore(options gobiclient.Options) (*Store, error) {
 		VaultPath: fmt.Sprintf("%s/%s", options.VaultPath, options.VaultName),
 		Settings:  &SettingsData{},
 		Sync:      &SyncData{},
+		Versions:  make(Versions),
 	}
 
 	err := localStore.Init()
@@ -56,9 +67,15 @@ func (l *Store) Init() error {
 			l.options.SyncStrategy = 1
 		}
 
+		if l.options.SyncDirection == 0 {
+			l.options.SyncDirection = client.SyncDirectionBidirectional
+		}
+
 		l.Settings.WebsocketVersion = l.options.WebsocketVersion
 		l.Settings.VaultName = l.options.VaultName
 		l.Settings.SyncStrategy = l.options.SyncStrategy
+		l.Settings.SyncDirection = l.options.SyncDirection
+		l.Settings.Subscription = l.options.Subscription
 
 		err = writeSettings(l.GetSettingsPath(), l.Settings)
 		if err != nil {
@@ -71,6 +88,7 @@ func (l *Store) Init() error {
 	_, err = os.Stat(l.GetSyncPath())
 	if os.IsNotExist(err) {
 		l.Sync.LastSync = 0
+		l.Sync.Sequence = 0
 
 		err = writeSyncData(l.GetSyncPath(), l.Sync)
 		if err != nil {
@@ -86,15 +104,104 @@ func (l *Store) Init() error {
 	}
 	l.Settings = settings
 
+	if l.applyOverrides() {
+		if err := l.SaveSettings(); err != nil {
+			return fmt.Errorf("error saving settings: %w", err)
+		}
+	}
+
 	sync, err := readSyncData(l.GetSyncPath())
 	if err != nil {
 		return fmt.Errorf("error reading sync file: %w", err)
 	}
 	l.Sync = sync
 
+	versions, err := readVersions(l.GetVersionsPath())
+	if err != nil {
+		return fmt.Errorf("error reading versions file: %w", err)
+	}
+	l.Versions = versions
+
+	// Paths that were not synced before will not be sent by the server unless we sync from the beginning
+	if !l.Sync.Subscription.Equal(l.Settings.Subscription) {
+		slog.Info("Subscription changed, syncing the vault from the beginning", "include", l.Settings.Subscription.Include, "exclude", l.Settings.Subscription.Exclude)
+
+		l.Sync.LastSync = 0
+		l.Sync.Sequence = 0
+		l.Sync.Subscription = l.Settings.Subscription
+
+		if err := l.SaveSync(); err != nil {
+			return fmt.Errorf("error resetting sync after subscription change: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// applyOverrides will replace the persisted settings with the options that were set explicitly.
+// Returns true if any of the settings changed
+func (l *Store) applyOverrides() bool {
+	changed := false
+	override := func(option string, differs bool, apply func()) {
+		if !l.options.Overrides[option] || !differs {
+			return
+		}
+
+		slog.Info("Overriding the persisted setting", "option", option)
+		apply()
+		changed = true
+	}
+
+	override(gobiclient.OptionVaultName, l.Settings.VaultName != l.options.VaultName, func() {
+		l.Settings.VaultName = l.options.VaultName
+	})
+	override(gobiclient.OptionSyncStrategy, l.Settings.SyncStrategy != l.options.SyncStrategy, func() {
+		l.Settings.SyncStrategy = l.options.SyncStrategy
+	})
+	override(gobiclient.OptionSyncDirection, l.Settings.SyncDirection != l.options.SyncDirection, func() {
+		l.Settings.SyncDirection = l.options.SyncDirection
+	})
+	override(gobiclient.OptionInclude, !slices.Equal(l.Settings.Subscription.Include, l.options.Subscription.Include), func() {
+		l.Settings.Subscription.Include = l.options.Subscription.Include
+	})
+	override(gobiclient.OptionExclude, !slices.Equal(l.Settings.Subscription.Exclude, l.options.Subscription.Exclude), func() {
+		l.Settings.Subscription.Exclude = l.options.Subscription.Exclude
+	})
+
+	return changed
+}
+
+// writeFileAtomic writes the data to a temporary file next to the path and renames it over the path.
+// A crash while writing leaves the previous file intact instead of a truncated one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ignore.TempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
 // getConfigDir returns the path where the hidden config dir is located
 // The config dir is used to store settings and other configuration files
 func (l *Store) getConfigDir() string {
@@ -113,14 +220,44 @@ func (l *Store) GetSyncPath() string {
 	return fmt.Sprintf("%s/sync.json", l.getConfigDir())
 }
 
+// GetVersionsPath returns the path to the versions file
+// The versions file is used to store the last version of each item the client synced with the server
+func (l *Store) GetVersionsPath() string {
+	return fmt.Sprintf("%s/versions.json", l.getConfigDir())
+}
+
 func (l *Store) SaveSettings() error {
 	return writeSettings(l.GetSettingsPath(), l.Settings)
 }
 
 func (l *Store) SaveSync() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return writeSyncData(l.GetSyncPath(), l.Sync)
+}
+
+// UpdateSync will change the sync data with the given function and persist it.
+// Use this instead of changing Sync directly, so concurrent changes are not lost
+func (l *Store) UpdateSync(update func(sync *SyncData)) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	update(l.Sync)
+
 	return writeSyncData(l.GetSyncPath(), l.Sync)
 }
 
+// SaveVersions persists the given versions, replacing the stored ones
+func (l *Store) SaveVersions(versions Versions) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.Versions = versions
+
+	return writeVersions(l.GetVersionsPath(), versions)
+}
+
 // SaveAll saves all the data in the LocalStore
 func (l *Store) SaveAll() error {
 	err := l.SaveSettings()
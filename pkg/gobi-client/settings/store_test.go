@@ -0,0 +1,156 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/client"
+	gobiclient "github.com/Michaelpalacce/gobi/pkg/gobi-client"
+)
+
+func TestNewStoreOverrides(t *testing.T) {
+	first := gobiclient.Options{
+		VaultName:     "vault",
+		SyncStrategy:  1,
+		SyncDirection: client.SyncDirectionBidirectional,
+		Subscription:  client.Subscription{Include: []string{"notes"}},
+	}
+
+	tests := []struct {
+		name              string
+		options           gobiclient.Options
+		wantDirection     int
+		wantInclude       []string
+		wantExclude       []string
+		wantSyncFromStart bool
+	}{
+		{
+			name: "options that were not set explicitly keep the persisted settings",
+			options: gobiclient.Options{
+				VaultName:     "vault",
+				SyncStrategy:  1,
+				SyncDirection: client.SyncDirectionDownloadOnly,
+				Subscription:  client.Subscription{Exclude: []string{"archive"}},
+			},
+			wantDirection: client.SyncDirectionBidirectional,
+			wantInclude:   []string{"notes"},
+		},
+		{
+			name: "explicit direction replaces the persisted one",
+			options: gobiclient.Options{
+				VaultName:     "vault",
+				SyncStrategy:  1,
+				SyncDirection: client.SyncDirectionDownloadOnly,
+				Overrides:     map[string]bool{gobiclient.OptionSyncDirection: true},
+			},
+			wantDirection: client.SyncDirectionDownloadOnly,
+			wantInclude:   []string{"notes"},
+		},
+		{
+			name: "explicit subscription replaces the persisted one and syncs from the start",
+			options: gobiclient.Options{
+				VaultName:    "vault",
+				SyncStrategy: 1,
+				Subscription: client.Subscription{Exclude: []string{"archive"}},
+				Overrides:    map[string]bool{gobiclient.OptionInclude: true, gobiclient.OptionExclude: true},
+			},
+			wantDirection:     client.SyncDirectionBidirectional,
+			wantExclude:       []string{"archive"},
+			wantSyncFromStart: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "vault"), 0o700); err != nil {
+				t.Fatalf("Mkdir() error = %v", err)
+			}
+
+			first.VaultPath = dir
+			store, err := NewStore(first)
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+
+			store.Sync.LastSync = 100
+			store.Sync.Sequence = 10
+			if err := store.SaveSync(); err != nil {
+				t.Fatalf("SaveSync() error = %v", err)
+			}
+
+			tt.options.VaultPath = dir
+			store, err = NewStore(tt.options)
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+
+			if store.Settings.SyncDirection != tt.wantDirection {
+				t.Errorf("SyncDirection = %d, want %d", store.Settings.SyncDirection, tt.wantDirection)
+			}
+
+			if !slices.Equal(store.Settings.Subscription.Include, tt.wantInclude) {
+				t.Errorf("Include = %v, want %v", store.Settings.Subscription.Include, tt.wantInclude)
+			}
+
+			if !slices.Equal(store.Settings.Subscription.Exclude, tt.wantExclude) {
+				t.Errorf("Exclude = %v, want %v", store.Settings.Subscription.Exclude, tt.wantExclude)
+			}
+
+			if got := store.Sync.Sequence == 0; got != tt.wantSyncFromStart {
+				t.Errorf("synced from the start = %v, want %v", got, tt.wantSyncFromStart)
+			}
+
+			persisted, err := readSettings(store.GetSettingsPath())
+			if err != nil {
+				t.Fatalf("readSettings() error = %v", err)
+			}
+
+			if persisted.SyncDirection != tt.wantDirection {
+				t.Errorf("persisted SyncDirection = %d, want %d", persisted.SyncDirection, tt.wantDirection)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sync.json")
+
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new"), 0o640); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	if string(contents) != "new" {
+		t.Errorf("contents = %q, want %q", contents, "new")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %o, want %o", info.Mode().Perm(), 0o640)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("entries = %d, want only the written file", len(entries))
+	}
+}
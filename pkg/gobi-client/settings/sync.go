@@ -4,11 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"github.com/Michaelpalacce/gobi/pkg/client"
 )
 
 type SyncData struct {
 	// Sync Relevant Data
 	LastSync int `json:"lastSync,omitempty"`
+	// Sequence is the last event of the vault's event log we synced. The server sends everything after it
+	Sequence int64 `json:"sequence,omitempty"`
+	// Flagged contains paths that were edited locally, but could not be uploaded because the client is download-only
+	Flagged []string `json:"flagged,omitempty"`
+	// Subscription is the subscription used for the last sync.
+	// If it differs from the one in the settings, a full sync is needed to fetch the newly included paths
+	Subscription client.Subscription `json:"subscription"`
 }
 
 // readSyncData reads and then returns the sync data from the given path
@@ -39,7 +48,7 @@ func writeSyncData(path string, sync *SyncData) error {
 
 	fmt.Println(string(syncBytes))
 
-	err = os.WriteFile(path, syncBytes, 0o640)
+	err = writeFileAtomic(path, syncBytes, 0o640)
 	if err != nil {
 		return fmt.Errorf("error writing sync file: %w", err)
 	}
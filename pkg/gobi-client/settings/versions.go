@@ -0,0 +1,50 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+// Versions contains the last version of each item we synced with the server, keyed by path.
+// The sequence the server assigned to each version tells which changes are newer, without looking at any clocks
+type Versions map[string]models.Item
+
+// readVersions reads and then returns the versions from the given path. A missing file means nothing was synced yet
+func readVersions(path string) (Versions, error) {
+	versionsBytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(Versions), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading versions file: %w", err)
+	}
+
+	versions := make(Versions)
+
+	err = json.Unmarshal(versionsBytes, &versions)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// writeVersions writes the given versions to the given path
+// 640 so that only the owner can read and write, group can read, and others can't do anything
+func writeVersions(path string, versions Versions) error {
+	versionsBytes, err := json.Marshal(versions)
+	if err != nil {
+		return fmt.Errorf("error marshalling versions: %w", err)
+	}
+
+	err = writeFileAtomic(path, versionsBytes, 0o640)
+	if err != nil {
+		return fmt.Errorf("error writing versions file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package supervisor
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff hands out exponentially growing delays between reconnect attempts
+// The delays are jittered, so clients that lost the connection at the same time do not reconnect at the same time
+type Backoff struct {
+	// Min is the delay of the first attempt, before jitter
+	Min time.Duration
+	// Max is the highest delay, before jitter
+	Max time.Duration
+
+	attempt int
+}
+
+// Next returns how long to wait before the next attempt. The delay doubles with every attempt until it reaches Max.
+// A random delay between half of it and all of it is returned
+func (b *Backoff) Next() time.Duration {
+	delay := b.Max
+	if b.attempt < 32 && b.Min<<b.attempt < b.Max {
+		delay = b.Min << b.attempt
+		b.attempt++
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reset starts over from Min. Call this once a connection was established
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,127 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// fatalError is an error that retrying will not fix, like bad credentials
+type fatalError struct {
+	err error
+}
+
+func (e fatalError) Error() string { return e.err.Error() }
+
+func (e fatalError) Unwrap() error { return e.err }
+
+// retryAfterError is an error after which the server asked us to wait a certain time before reconnecting
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string { return e.err.Error() }
+
+func (e retryAfterError) Unwrap() error { return e.err }
+
+// Fatal marks the error as fatal. The supervisor stops when it is returned
+func Fatal(err error) error {
+	return fatalError{err: err}
+}
+
+// RetryAfter marks the error as one after which the server asked us to wait for the given time. Zero uses the backoff
+func RetryAfter(err error, after time.Duration) error {
+	return retryAfterError{err: err, after: after}
+}
+
+// IsFatal returns true if retrying will not fix the error
+func IsFatal(err error) bool {
+	var fatal fatalError
+	return errors.As(err, &fatal)
+}
+
+// retryAfter returns how long the server asked us to wait, or zero if it did not
+func retryAfter(err error) time.Duration {
+	var retry retryAfterError
+	if errors.As(err, &retry) {
+		return retry.after
+	}
+
+	return 0
+}
+
+// isOffline returns true if the error means that the server could not be reached at all
+func isOffline(err error) bool {
+	var opError *net.OpError
+	var dnsError *net.DNSError
+
+	return errors.As(err, &opError) || errors.As(err, &dnsError)
+}
+
+// ConnectFunc establishes a connection and keeps it going until it ends.
+// Connected tells if the connection was established before it ended, err why it ended. A nil error ends the supervisor
+type ConnectFunc func(ctx context.Context) (connected bool, err error)
+
+// Supervisor keeps a connection to the server going, reconnecting with a backoff whenever it is lost
+type Supervisor struct {
+	Backoff *Backoff
+
+	offline bool
+}
+
+// New creates a Supervisor that waits between min and max before reconnecting
+func New(min time.Duration, max time.Duration) *Supervisor {
+	return &Supervisor{
+		Backoff: &Backoff{Min: min, Max: max},
+	}
+}
+
+// Run calls connect until the context is done, connect returns nil or a fatal error. Fatal errors are returned.
+// Transient errors are retried after the delay the server asked for, or the next backoff delay if it did not ask.
+// The backoff starts over every time a connection was established
+func (s *Supervisor) Run(ctx context.Context, connect ConnectFunc) error {
+	for {
+		connected, err := connect(ctx)
+		if ctx.Err() != nil || err == nil {
+			return nil
+		}
+
+		if IsFatal(err) {
+			return err
+		}
+
+		if connected {
+			s.Backoff.Reset()
+		}
+
+		s.logOffline(connected, err)
+
+		delay := retryAfter(err)
+		if delay <= 0 {
+			delay = s.Backoff.Next()
+		}
+
+		slog.Info("Reconnecting", "in", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// logOffline logs once when the server becomes unreachable, instead of on every attempt
+func (s *Supervisor) logOffline(connected bool, err error) {
+	if connected {
+		s.offline = false
+	}
+
+	if !connected && !s.offline && isOffline(err) {
+		s.offline = true
+		slog.Warn("Server is unreachable, we are probably offline. Local changes are picked up once we reconnect")
+	}
+}
@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("test")
+
+func TestBackoffNext(t *testing.T) {
+	backoff := &Backoff{Min: time.Second, Max: 10 * time.Second}
+
+	tests := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{name: "first attempt", delay: time.Second},
+		{name: "second attempt", delay: 2 * time.Second},
+		{name: "third attempt", delay: 4 * time.Second},
+		{name: "fourth attempt", delay: 8 * time.Second},
+		{name: "capped at max", delay: 10 * time.Second},
+		{name: "stays at max", delay: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backoff.Next()
+			if got < tt.delay/2 || got > tt.delay {
+				t.Errorf("Next() = %v, want between %v and %v", got, tt.delay/2, tt.delay)
+			}
+		})
+	}
+
+	backoff.Reset()
+	if got := backoff.Next(); got > time.Second {
+		t.Errorf("Next() after Reset() = %v, want at most %v", got, time.Second)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		fatal bool
+		after time.Duration
+	}{
+		{name: "plain error", err: errTest},
+		{name: "fatal", err: Fatal(errTest), fatal: true},
+		{name: "retry after", err: RetryAfter(errTest, time.Minute), after: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsFatal(tt.err); got != tt.fatal {
+				t.Errorf("IsFatal() = %v, want %v", got, tt.fatal)
+			}
+
+			if got := retryAfter(tt.err); got != tt.after {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.after)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package transfer
+
+import (
+	"io"
+	"sync"
+)
+
+// Stats counts the contents of the transferred files, before and after compression
+type Stats struct {
+	// Files is how many files were uploaded or downloaded
+	Files int64
+	// Compressed is how many of the files were compressed
+	Compressed int64
+	// Bytes is the size of the contents of the files
+	Bytes int64
+	// WireBytes is how many bytes were sent over the network for the contents
+	WireBytes int64
+}
+
+// Ratio returns how many times smaller the contents were on the network. 1 if nothing was compressed
+func (s Stats) Ratio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+
+	return float64(s.Bytes) / float64(s.WireBytes)
+}
+
+// statsRecorder adds up the stats of every transfer. Transfers can happen from different goroutines
+type statsRecorder struct {
+	mutex sync.Mutex
+	stats Stats
+}
+
+func (r *statsRecorder) record(bytes int64, wireBytes int64, compressed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stats.Files++
+	r.stats.Bytes += bytes
+	r.stats.WireBytes += wireBytes
+	if compressed {
+		r.stats.Compressed++
+	}
+}
+
+func (r *statsRecorder) get() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.stats
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+
+	return n, err
+}
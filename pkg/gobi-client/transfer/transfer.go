@@ -0,0 +1,349 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/Michaelpalacce/gobi/pkg/compression"
+	gobiclient "github.com/Michaelpalacce/gobi/pkg/gobi-client"
+	"github.com/Michaelpalacce/gobi/pkg/gobi-client/auth"
+	"github.com/Michaelpalacce/gobi/pkg/messages/v1/rest"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/storage"
+)
+
+var (
+	// ErrQuotaExceeded is returned when the server rejects an upload, because the quota of the user or the vault is full
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrChecksumMismatch is returned when a downloaded item does not match the SHA256 the server told us about
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrItemChanged is returned when the server refuses an upload, because the item changed since the version it is based on
+	ErrItemChanged = errors.New("item changed on the server")
+)
+
+// Client transfers items between the local storage and the server, using the REST API
+// Files are never sent over websockets
+type Client struct {
+	options gobiclient.Options
+	http    *http.Client
+
+	// SessionID is sent with every request, so the server knows which websocket session made the change
+	SessionID string
+
+	// compress is set once the server agreed to compression in the hello
+	compress atomic.Bool
+	stats    statsRecorder
+}
+
+// NewClient creates a new transfer client for the vault in the options
+func NewClient(options gobiclient.Options) *Client {
+	return &Client{
+		options: options,
+		http:    &http.Client{},
+	}
+}
+
+// SetCompression will compress the files we upload and ask for compressed downloads from now on.
+// Only enable it if the server supports compression. Files that are already compressed are always sent as they are
+func (c *Client) SetCompression(enabled bool) {
+	c.compress.Store(enabled)
+}
+
+// Stats returns how many files were transferred so far and how well they were compressed
+func (c *Client) Stats() Stats {
+	return c.stats.get()
+}
+
+// Download will fetch the item from the server and store it using the storage driver.
+// The local item is only replaced once the size and the SHA256 were verified. The modification time is set to the one on the server
+func (c *Client) Download(item models.Item, storageDriver storage.Driver) error {
+	request, err := c.newRequest(http.MethodGet, "/", item.ServerPath, nil)
+	if err != nil {
+		return err
+	}
+
+	// Setting the header ourselves stops the transport from decompressing on its own, which it only does for gzip
+	if c.compress.Load() {
+		request.Header.Set("Accept-Encoding", compression.Zstd)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("error downloading item: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+
+	wire := &countingReader{reader: response.Body}
+	var contents io.Reader = wire
+
+	compressed := response.Header.Get("Content-Encoding") == compression.Zstd
+	if compressed {
+		decompressor, err := compression.NewReader(wire, int64(item.Size))
+		if err != nil {
+			return fmt.Errorf("error decompressing item: %w", err)
+		}
+		defer decompressor.Close()
+
+		contents = decompressor
+	}
+
+	writer, err := storageDriver.GetWriter(item)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hasher), contents)
+	if err != nil {
+		return fmt.Errorf("error writing item: %w", err)
+	}
+
+	if size != int64(item.Size) {
+		return fmt.Errorf("%w for %s: expected %d bytes, got %d", ErrChecksumMismatch, item.ServerPath, item.Size, size)
+	}
+
+	if sum := hex.EncodeToString(hasher.Sum(nil)); sum != item.SHA256 {
+		return fmt.Errorf("%w for %s: expected %s, got %s", ErrChecksumMismatch, item.ServerPath, item.SHA256, sum)
+	}
+
+	if err := writer.Commit(); err != nil {
+		return fmt.Errorf("error writing item: %w", err)
+	}
+
+	c.stats.record(size, wire.count, compressed)
+	slog.Debug("Downloaded item", "path", item.ServerPath, "bytes", size, "wireBytes", wire.count, "compressed", compressed)
+
+	return storageDriver.Touch(item)
+}
+
+// Upload will send the item from the storage driver to the server.
+// Directories and symlinks are sent without contents, only with their metadata.
+// The sequence of the item is sent as the version the local changes are based on. If the item changed on the server since,
+// nothing is stored and the current item on the server is returned together with ErrItemChanged
+// If compression is enabled, the contents are compressed with zstd and their size is sent along, so the server can check the quota
+// Returns the metadata the server stored for the item
+func (c *Client) Upload(item models.Item, storageDriver storage.Driver) (*models.Item, error) {
+	var reader io.ReadCloser = io.NopCloser(nil)
+	if item.IsFile() {
+		var err error
+		if reader, err = storageDriver.GetReader(item); err != nil {
+			return nil, err
+		}
+	}
+
+	compressed := item.IsFile() && c.compress.Load() && compression.ShouldCompress(item.ServerPath, int64(item.Size))
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	// size and wire are only read once the request was sent
+	var size int64
+	wire := &countingWriter{}
+	sent := make(chan struct{})
+
+	go func() {
+		defer close(sent)
+		defer reader.Close()
+
+		mTime := item.ServerMTime
+		if item.IsFile() {
+			mTime = storageDriver.GetMTime(item)
+		}
+
+		fields := [][2]string{
+			{"path", item.ServerPath},
+			{"mtime", strconv.FormatInt(mTime, 10)},
+			{"kind", string(item.Kind)},
+		}
+
+		if item.Mode != 0 {
+			fields = append(fields, [2]string{"mode", strconv.FormatUint(uint64(item.Mode), 8)})
+		}
+
+		if item.IsSymlink() {
+			fields = append(fields, [2]string{"target", item.LinkTarget})
+		}
+
+		// Lets the server refuse contents that changed after they were hashed, instead of storing them under a wrong SHA256
+		if item.IsFile() && item.SHA256 != "" {
+			fields = append(fields, [2]string{"sha256", item.SHA256})
+		}
+
+		if item.Sequence > 0 {
+			fields = append(fields, [2]string{"base_sequence", strconv.FormatInt(item.Sequence, 10)})
+		}
+
+		if compressed {
+			fields = append(fields, [2]string{"encoding", compression.Zstd}, [2]string{"size", strconv.Itoa(item.Size)})
+		}
+
+		var err error
+		for _, field := range fields {
+			if err == nil && field[1] != "" {
+				err = form.WriteField(field[0], field[1])
+			}
+		}
+
+		if err == nil && item.IsFile() {
+			if wire.writer, err = form.CreateFormFile("item", item.ServerPath); err == nil {
+				size, err = writeContents(wire, reader, compressed)
+			}
+		}
+
+		if err == nil {
+			err = form.Close()
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	request, err := c.newRequest(http.MethodPost, "/", "", body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading item: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusConflict {
+		return changedItem(response)
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		return nil, responseError(response)
+	}
+
+	var created struct {
+		Items []models.Item `json:"items"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil || len(created.Items) == 0 {
+		return nil, fmt.Errorf("error decoding upload response: %w", err)
+	}
+
+	// The server read the whole body before replying, so this does not block
+	<-sent
+	if item.IsFile() {
+		c.stats.record(size, wire.count, compressed)
+		slog.Debug("Uploaded item", "path", item.ServerPath, "bytes", size, "wireBytes", wire.count, "compressed", compressed)
+	}
+
+	return &created.Items[0], nil
+}
+
+// changedItem returns the current item from a 409 response together with ErrItemChanged.
+// Collisions are 409 as well, but come without an item
+func changedItem(response *http.Response) (*models.Item, error) {
+	body, _ := io.ReadAll(response.Body)
+
+	var conflict struct {
+		Error string       `json:"error"`
+		Item  *models.Item `json:"item"`
+	}
+
+	if err := json.Unmarshal(body, &conflict); err != nil || conflict.Item == nil {
+		return nil, fmt.Errorf("unexpected response from server: %s, response was %s", response.Status, body)
+	}
+
+	return conflict.Item, fmt.Errorf("%w: %s", ErrItemChanged, conflict.Error)
+}
+
+// writeContents copies the contents into w, compressing them if asked to. Returns the size of the contents
+func writeContents(w io.Writer, contents io.Reader, compress bool) (int64, error) {
+	if !compress {
+		return io.Copy(w, contents)
+	}
+
+	compressor, err := compression.NewWriter(w)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(compressor, contents)
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+
+	return size, err
+}
+
+// Delete will delete the item on the server. The server keeps a tombstone, so other clients delete it as well
+func (c *Client) Delete(item models.Item) error {
+	request, err := c.newRequest(http.MethodDelete, "/", item.ServerPath, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("error deleting item: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+
+	return nil
+}
+
+// newRequest creates an authenticated request to the items API for the vault
+func (c *Client) newRequest(method, endpoint, itemPath string, body io.Reader) (*http.Request, error) {
+	query := url.Values{}
+	query.Set("vault", c.options.VaultName)
+	if itemPath != "" {
+		query.Set("path", itemPath)
+	}
+
+	requestUrl := url.URL{
+		Scheme:   "http",
+		Host:     c.options.Host,
+		Path:     fmt.Sprintf("/api/v%d/items%s", c.options.WebsocketVersion, endpoint),
+		RawQuery: query.Encode(),
+	}
+
+	request, err := http.NewRequest(method, requestUrl.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	request.Header.Set("Authorization", auth.BasicAuth(c.options.Username, c.options.Password))
+	request.Header.Set("User-Agent", gobiclient.UserAgent())
+	if c.SessionID != "" {
+		request.Header.Set(rest.SessionHeader, c.SessionID)
+	}
+
+	return request, nil
+}
+
+// responseError will return an error containing the status and the body of the response
+// Wraps ErrQuotaExceeded if the server rejected the request because the quota is full
+func responseError(response *http.Response) error {
+	body, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode == http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, body)
+	}
+
+	return fmt.Errorf("unexpected response from server: %s, response was %s", response.Status, body)
+}
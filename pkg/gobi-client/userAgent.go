@@ -0,0 +1,24 @@
+package gobiclient
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// UserAgent returns the User-Agent the client sends with the websocket handshake and every REST request.
+// The server records it as the device in the audit log and uses it to tell the devices of a user apart
+func UserAgent() string {
+	return userAgent()
+}
+
+// userAgent only looks the hostname up once
+var userAgent = sync.OnceValue(func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("gobi-client (%s/%s; %s)", runtime.GOOS, runtime.GOARCH, hostname)
+})
@@ -1,15 +1,25 @@
 package connection
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
+	"sync"
 
 	processor_v1 "github.com/Michaelpalacce/gobi/pkg/gobi/processor/v1"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
+	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
 	"github.com/gorilla/websocket"
 )
 
+// serverHello is every version, capability and encoding the server supports. Capabilities are only added once they are implemented
+var serverHello = messages.HelloPayload{
+	Versions:     []int{v1.Version},
+	Capabilities: messages.Capabilities{messages.CapabilityCompression},
+	Encodings:    []messages.Encoding{messages.EncodingCBOR, messages.EncodingJSON},
+}
+
 // ServerConnection represents a connected WebSocket client
 // It will handle the processing of the messages and send them to the processor
 // It will also handle the initial connection
@@ -17,24 +27,98 @@ import (
 type ServerConnection struct {
 	WebsocketClient *socket.WebsocketClient
 	V1Processor     *processor_v1.Processor
+	VaultResolver   processor_v1.VaultResolver
+	ItemStore       processor_v1.ItemStore
+	Auditor         processor_v1.Auditor
+	// Heartbeat configures how a client that went silent is detected
+	Heartbeat socket.Heartbeat
+
+	// processorMutex guards V1Processor against other goroutines. The read loop sets it and can read it without the mutex
+	processorMutex sync.Mutex
 }
 
 // Listen will request information from the client and then listen for data.
+// Clients that stop answering pings are detected and the read loop stops with a timeout error
 func (c *ServerConnection) Listen(closeChan chan<- error) {
+	stopHeartbeat := c.WebsocketClient.StartHeartbeat(c.Heartbeat)
+	defer stopHeartbeat()
+
 	closeChan <- c.readMessage()
 }
 
 // Close will gracefully close the connection. If an error ocurrs during closing, it will be ignored.
 func (c *ServerConnection) Close(msg string) {
+	if processor := c.processor(); processor != nil {
+		processor.Close()
+	}
+
 	c.WebsocketClient.Close(msg)
 }
 
+// Disconnect will close the connection from outside of the read loop, for example when an admin kicks the client.
+// The session is removed, so it cannot be used for REST requests anymore. The read loop stops once the connection is closed
+func (c *ServerConnection) Disconnect(msg string) {
+	if processor := c.processor(); processor != nil {
+		processor.Disconnect(msg)
+		return
+	}
+
+	c.WebsocketClient.Close(msg)
+	_ = c.WebsocketClient.Conn.Close()
+}
+
+// Shutdown will tell the client that the server is stopping and after how many seconds it should reconnect.
+// The session is kept alive, so REST requests of the client that are still in flight can finish. The client is expected to
+// close the connection, once it is done with what it is doing
+func (c *ServerConnection) Shutdown(reason string, reconnectAfter int) error {
+	if info, ok := c.Info(); ok {
+		if err := session.ExtendSession(info.Username, info.VaultName, info.SessionID); err != nil {
+			slog.Warn("Error extending session", "session", info.SessionID, "error", err)
+		}
+	}
+
+	return c.WebsocketClient.SendPriorityMessage(messages.NewServerShutdownMessage(reason, reconnectAfter))
+}
+
+// Info returns a snapshot of the session of the client. Returns false if the client has not sent its version yet.
+// Safe to call from any goroutine
+func (c *ServerConnection) Info() (processor_v1.SessionInfo, bool) {
+	processor := c.processor()
+	if processor == nil {
+		return processor_v1.SessionInfo{}, false
+	}
+
+	return processor.Info(), true
+}
+
+// SessionID returns the ID of the session of the client, or an empty string if the client has not sent its version yet
+func (c *ServerConnection) SessionID() string {
+	info, _ := c.Info()
+
+	return info.SessionID
+}
+
+// VaultID returns the ID of the vault the client is connected to, or an empty string if the client has not sent its vault yet
+func (c *ServerConnection) VaultID() string {
+	info, _ := c.Info()
+
+	return info.VaultID
+}
+
+// processor returns the processor of the client, or nil if the client has not sent its version yet
+func (c *ServerConnection) processor() *processor_v1.Processor {
+	c.processorMutex.Lock()
+	defer c.processorMutex.Unlock()
+
+	return c.V1Processor
+}
+
 // readMessage will continuously wait for incomming messages and process them for the given client
 // This function is blocking and will stop when Close is called
-// TODO: At the same time subscribe to a Redis queue. In case something gets changed, we'll know and we'll send it over eventually.
 func (c *ServerConnection) readMessage() (closeError error) {
 out:
 	for {
+		c.WebsocketClient.ExtendReadDeadline()
 		messageType, message, err := c.WebsocketClient.Conn.ReadMessage()
 		// slog.Debug("Received message from client", "message", string(message), "messageType", messageType)
 		if err != nil {
@@ -50,12 +134,8 @@ out:
 		}
 
 		switch messageType {
-		case websocket.TextMessage:
-			if closeError = c.processTextMessage(message); closeError != nil {
-				break out
-			}
-		case websocket.BinaryMessage:
-			if closeError = c.processBinaryMessage(message); closeError != nil {
+		case websocket.TextMessage, websocket.BinaryMessage:
+			if closeError = c.processMessage(messageType, message); closeError != nil {
 				break out
 			}
 		case websocket.PingMessage:
@@ -75,25 +155,146 @@ out:
 	return closeError
 }
 
-// processTextMessage will process different types of text messages
-func (c *ServerConnection) processTextMessage(message []byte) error {
-	var websocketMessage messages.WebsocketMessage
-
-	if err := json.Unmarshal(message, &websocketMessage); err != nil {
+// processMessage will process different types of messages. Text messages are JSON, binary ones CBOR
+// Messages with an ID are acknowledged once processed. If they were malformed or of an unknown type or version, the client is
+// told so in the ack and the connection stays open. Any other error closes the connection.
+// Once the client speaks version 1, errors are sent as error messages instead, see replyV1
+func (c *ServerConnection) processMessage(messageType int, message []byte) error {
+	websocketMessage, err := socket.Decode(messageType, message)
+	if err != nil {
 		return fmt.Errorf("error while unmarshaling websocket message %w", err)
 	}
 
+	if c.WebsocketClient.Resolve(websocketMessage) {
+		return nil
+	}
+
+	// The hello is answered with a helloAck instead of an ack
+	if websocketMessage.Version == 0 && websocketMessage.Type == messages.HelloType {
+		return c.processHello(websocketMessage)
+	}
+
+	err = c.processVersionedMessage(websocketMessage)
+	if websocketMessage.Version == v1.Version && c.V1Processor != nil {
+		return c.replyV1(websocketMessage, err)
+	}
+
+	if websocketMessage.ID == "" {
+		return err
+	}
+
+	if ackErr := c.WebsocketClient.SendPriorityMessage(messages.NewAckMessage(websocketMessage.ID, err)); ackErr != nil {
+		return ackErr
+	}
+
+	if err != nil && messages.AckCodeOf(err) != messages.AckCodeInternal {
+		slog.Warn("Rejected message from client", "type", websocketMessage.Type, "error", err)
+		return nil
+	}
+
+	return err
+}
+
+// replyV1 will acknowledge the message if it has an ID, or tell the client why it failed with an error message.
+// The connection is only closed for errors that are not recoverable, a single bad message or item does not end the sync
+func (c *ServerConnection) replyV1(websocketMessage messages.WebsocketMessage, err error) error {
+	if err == nil {
+		if websocketMessage.ID == "" {
+			return nil
+		}
+
+		return c.WebsocketClient.SendPriorityMessage(messages.NewAckMessage(websocketMessage.ID, nil))
+	}
+
+	// Errors are never answered with errors, or both sides could keep replying to each other
+	if websocketMessage.Type == v1.ErrorType {
+		return err
+	}
+
+	protocolError := v1.AsError(err)
+	if sendErr := c.WebsocketClient.SendPriorityMessage(v1.NewErrorMessage(websocketMessage.ID, protocolError)); sendErr != nil {
+		return sendErr
+	}
+
+	if !protocolError.Recoverable {
+		return err
+	}
+
+	slog.Warn("Error processing message from client", "type", websocketMessage.Type, "code", protocolError.Code, "error", err)
+
+	return nil
+}
+
+// processHello will agree with the client on the highest version and the capabilities both support and reply with them.
+// If there is no version in common, the client is told so in an ack and the connection stays open, like for any rejected message
+func (c *ServerConnection) processHello(websocketMessage messages.WebsocketMessage) error {
+	var helloPayload messages.HelloPayload
+	var helloAck messages.HelloAckPayload
+
+	err := websocketMessage.Decode(&helloPayload)
+	if err == nil {
+		helloAck, err = messages.Negotiate(serverHello, helloPayload)
+	}
+
+	if err == nil {
+		err = c.useVersion(helloAck.Version)
+	}
+
+	if err != nil {
+		slog.Warn("Rejected hello from client", "versions", helloPayload.Versions, "error", err)
+		return c.WebsocketClient.SendPriorityMessage(messages.NewAckMessage(websocketMessage.ID, err))
+	}
+
+	c.WebsocketClient.Capabilities = helloAck.Capabilities
+	slog.Debug("Agreed on protocol with client", "version", helloAck.Version, "capabilities", helloAck.Capabilities, "encoding", helloAck.Encoding)
+
+	if err := c.WebsocketClient.SendPriorityMessage(messages.NewHelloAckMessage(websocketMessage.ID, helloAck)); err != nil {
+		return err
+	}
+
+	// The reply is still JSON, everything after it uses the agreed on encoding
+	codec, _ := messages.CodecFor(helloAck.Encoding)
+	c.WebsocketClient.SetCodec(codec)
+
+	return nil
+}
+
+// useVersion will create the processor for the version the client speaks and start its session
+func (c *ServerConnection) useVersion(version int) error {
+	switch version {
+	case 1:
+		c.WebsocketClient.Client.Version = version
+		processor := processor_v1.NewProcessor(c.WebsocketClient, c.VaultResolver, c.ItemStore, c.Auditor)
+
+		c.processorMutex.Lock()
+		c.V1Processor = processor
+		c.processorMutex.Unlock()
+
+		processor.NewSession()
+	default:
+		return fmt.Errorf("%w: %d", messages.ErrUnknownVersion, version)
+	}
+
+	return nil
+}
+
+// processVersionedMessage will send the message to the processor of its version
+func (c *ServerConnection) processVersionedMessage(websocketMessage messages.WebsocketMessage) error {
 	switch websocketMessage.Version {
 	case 0:
 		if err := c.processV0(websocketMessage); err != nil {
 			return err
 		}
 	case 1:
+		if c.V1Processor == nil {
+			return fmt.Errorf("%w: the version must be sent first", messages.ErrUnknownVersion)
+		}
+
 		if err := c.V1Processor.ProcessServerTextMessage(websocketMessage); err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("unknown websocket version: %d", websocketMessage.Version)
+		return fmt.Errorf("%w: %d", messages.ErrUnknownVersion, websocketMessage.Version)
 	}
 
 	return nil
@@ -103,53 +304,23 @@ func (c *ServerConnection) processTextMessage(message []byte) error {
 // V0 messages are client specific
 func (c *ServerConnection) processV0(websocketMessage messages.WebsocketMessage) error {
 	switch websocketMessage.Type {
+	// Clients that predate the hello only send the version they want, without any capabilities
 	case messages.VersionType:
 		var versionResponsePayload messages.VersionPayload
 
-		if err := json.Unmarshal(websocketMessage.Payload, &versionResponsePayload); err != nil {
+		if err := websocketMessage.Decode(&versionResponsePayload); err != nil {
 			return err
-		} else {
-			c.WebsocketClient.Client.Version = versionResponsePayload.Version
-		}
-
-		switch c.WebsocketClient.Client.Version {
-		case 1:
-			c.V1Processor = processor_v1.NewProcessor(c.WebsocketClient)
-			c.V1Processor.NewSession()
-		default:
-			return fmt.Errorf("unknown version: %d", c.WebsocketClient.Client.Version)
 		}
 
+		return c.useVersion(versionResponsePayload.Version)
 	default:
-		return fmt.Errorf("unknown websocket message type: %s for version 0", websocketMessage.Type)
+		return fmt.Errorf("%w: %s for version 0", messages.ErrUnknownType, websocketMessage.Type)
 	}
-
-	return nil
-}
-
-// processBinaryMessage will process different types of binary messages
-func (c *ServerConnection) processBinaryMessage(message []byte) error {
-	var websocketMessage messages.WebsocketMessage
-
-	if err := json.Unmarshal(message, &websocketMessage); err != nil {
-		return fmt.Errorf("error while unmarshaling websocket response %w", err)
-	}
-
-	switch websocketMessage.Version {
-	case 1:
-		if err := c.V1Processor.ProcessServerBinaryMessage(websocketMessage); err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("unknown websocket version: %d", websocketMessage.Version)
-	}
-
-	return nil
 }
 
 // processPingMessage will send a PongMessage and nothing else
 func (c *ServerConnection) processPingMessage(message []byte) error {
-	if err := c.WebsocketClient.Conn.WriteMessage(websocket.PongMessage, []byte("")); err != nil {
+	if err := c.WebsocketClient.SendPong(); err != nil {
 		return fmt.Errorf("error sending message: %w", err)
 	}
 
@@ -1,20 +1,122 @@
 package processor_v1
 
 import (
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/Michaelpalacce/gobi/pkg/client"
 	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/socket"
+	goredis "github.com/redis/go-redis/v9"
 )
 
+// VaultResolver finds the vault a user is connecting to and the role they have in it
+type VaultResolver interface {
+	ResolveVault(user models.User, name string) (*models.Vault, models.Role, error)
+}
+
+// ItemStore holds the metadata of the items in the vaults
+type ItemStore interface {
+	GetChangesSince(vaultId string, sequence int64, lastSync int64) ([]models.Item, int64, error)
+	GetUsage(vault *models.Vault) (*models.QuotaUsage, error)
+}
+
+// Auditor records events in the audit log
+type Auditor interface {
+	Record(entry models.AuditEntry)
+}
+
 type Processor struct {
 	WebsocketClient *socket.WebsocketClient
 	Session         *session.Session
+	VaultResolver   VaultResolver
+	ItemStore       ItemStore
+	Auditor         Auditor
+
+	// Vault is the vault the client is connected to. Set once the client sends the vault name
+	Vault *models.Vault
+	// Role is the role of the user in the connected vault
+	Role models.Role
+
+	subscription *goredis.PubSub
+
+	// info is a snapshot of the session, so it can be read from other goroutines while the read loop changes the session
+	info atomic.Pointer[SessionInfo]
+}
+
+// SessionInfo is a snapshot of the session of the client. It is never changed once taken
+type SessionInfo struct {
+	SessionID     string
+	Username      string
+	VaultName     string
+	VaultID       string
+	Version       int
+	SyncDirection int
+	// Subscription contains the paths the client syncs. Its slices are copies, so they are never changed either
+	Subscription client.Subscription
+}
+
+// CanDownload returns true if the client wants changes from the server
+func (i SessionInfo) CanDownload() bool {
+	return i.SyncDirection != client.SyncDirectionUploadOnly
 }
 
 // NewProcessor will create a new processor with a default sync strategy of LastModifiedTime
 // The SyncStrategy can be changed later
-func NewProcessor(client *socket.WebsocketClient) *Processor {
-	return &Processor{
+func NewProcessor(client *socket.WebsocketClient, vaultResolver VaultResolver, itemStore ItemStore, auditor Auditor) *Processor {
+	processor := &Processor{
 		WebsocketClient: client,
-		Session:         session.NewSession(&client.Client, &client.User),
+		Session:         session.NewSession(&client.Client, &client.User, client.Device),
+		VaultResolver:   vaultResolver,
+		ItemStore:       itemStore,
+		Auditor:         auditor,
+	}
+
+	processor.storeInfo()
+
+	return processor
+}
+
+// Info returns the last snapshot of the session. Safe to call from any goroutine
+func (p *Processor) Info() SessionInfo {
+	return *p.info.Load()
+}
+
+// storeInfo will take a new snapshot of the session. Must only be called from the read loop, which owns the session
+func (p *Processor) storeInfo() {
+	p.info.Store(&SessionInfo{
+		SessionID:     p.Session.SessionID,
+		Username:      p.Session.User.Username,
+		VaultName:     p.Session.Client.VaultName,
+		VaultID:       p.Session.VaultId,
+		Version:       p.Session.Client.Version,
+		SyncDirection: p.Session.Client.SyncDirection,
+		Subscription: client.Subscription{
+			Include: slices.Clone(p.Session.Client.Subscription.Include),
+			Exclude: slices.Clone(p.Session.Client.Subscription.Exclude),
+		},
+	})
+}
+
+// Disconnect will close the connection from outside of the read loop and remove the session, so it cannot be used for
+// REST requests anymore. The read loop stops once the connection is closed
+// Only the snapshot of the session is read, as this is called from other goroutines
+func (p *Processor) Disconnect(msg string) {
+	info := p.Info()
+	if err := session.DeleteSession(info.Username, info.VaultName, info.SessionID); err != nil {
+		slog.Error("Error deleting session", "error", err)
+	}
+
+	p.WebsocketClient.Close(msg)
+	_ = p.WebsocketClient.Conn.Close()
+}
+
+// Close will release any resources held by the processor, like the Redis subscription
+func (p *Processor) Close() {
+	if p.subscription != nil {
+		_ = p.subscription.Close()
+		p.subscription = nil
 	}
 }
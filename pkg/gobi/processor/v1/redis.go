@@ -1,19 +1,75 @@
 package processor_v1
 
 import (
-	"fmt"
 	"log/slog"
 
-	"github.com/Michaelpalacce/gobi/pkg/redis"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/pubsub"
+	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 )
 
+// subscribeToRedis will subscribe to the changes of the connected vault and forward them to the client.
+// Changes are published by any server for any member of the vault, so all members' clients receive them.
+// Changes made by this session are not sent back.
+// If the membership of the user in the vault changes, the client is disconnected, as its role is only checked on connect
 func (p *Processor) subscribeToRedis() {
-	chanName := p.WebsocketClient.User.Username + "-" + p.WebsocketClient.Client.VaultName
-	redisChan := redis.Subscribe(chanName).Channel()
-	slog.Info("Subscribed to Redis channel", "channel", chanName)
+	p.Close()
 
-	for {
-		msg := <-redisChan
-		fmt.Println(msg.Payload)
+	p.subscription = pubsub.SubscribeToVault(p.Vault.ID.Hex())
+	redisChan := p.subscription.Channel()
+	membersChannel := pubsub.MembersChannel(p.Vault.ID.Hex())
+	slog.Info("Subscribed to Redis channel", "channel", pubsub.VaultChannel(p.Vault.ID.Hex()))
+
+	go func() {
+		for msg := range redisChan {
+			if msg.Channel == membersChannel {
+				if p.membershipChanged(msg.Payload) {
+					return
+				}
+
+				continue
+			}
+
+			change, err := pubsub.DecodeItemChange(msg.Payload)
+			if err != nil {
+				slog.Error("Error decoding item change", "error", err)
+				continue
+			}
+
+			if !p.forwards(change) {
+				continue
+			}
+
+			if err := p.WebsocketClient.SendMessage(v1.NewSyncDataMessage([]models.Item{change.Item}, 0)); err != nil {
+				slog.Error("Error forwarding item change to client", "error", err)
+				return
+			}
+		}
+	}()
+}
+
+// forwards returns true if the change should be sent to the client: it was made by another session, the client downloads
+// changes and the item is part of its subscription. Runs outside of the read loop, so only the snapshot of the session is read
+func (p *Processor) forwards(change *pubsub.ItemChange) bool {
+	info := p.Info()
+
+	return change.Origin != info.SessionID && info.CanDownload() && info.Subscription.Matches(change.Item.ServerPath)
+}
+
+// membershipChanged will disconnect the client if the membership change is about its user. Returns true if it was
+func (p *Processor) membershipChanged(payload string) bool {
+	change, err := pubsub.DecodeMembershipChange(payload)
+	if err != nil {
+		slog.Error("Error decoding membership change", "error", err)
+		return false
 	}
+
+	if change.UserId != p.WebsocketClient.User.ID.Hex() {
+		return false
+	}
+
+	slog.Info("Membership in the vault changed, disconnecting client", "user", p.WebsocketClient.User.Username, "vault", p.Vault.ID.Hex())
+	p.Disconnect("Membership in the vault changed")
+
+	return true
 }
@@ -0,0 +1,100 @@
+package processor_v1
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/client"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/pubsub"
+	"github.com/Michaelpalacce/gobi/pkg/gobi/session"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/socket"
+)
+
+func newTestProcessor() *Processor {
+	websocketClient := &socket.WebsocketClient{}
+	p := &Processor{
+		WebsocketClient: websocketClient,
+		Session: &session.Session{
+			SessionID: "session",
+			Client:    &websocketClient.Client,
+			User:      &websocketClient.User,
+		},
+	}
+
+	p.storeInfo()
+
+	return p
+}
+
+func TestForwards(t *testing.T) {
+	change := func(origin, path string) *pubsub.ItemChange {
+		return &pubsub.ItemChange{Origin: origin, Item: models.Item{ServerPath: path}}
+	}
+
+	tests := []struct {
+		name          string
+		syncDirection int
+		subscription  client.Subscription
+		change        *pubsub.ItemChange
+		want          bool
+	}{
+		{
+			name:   "change of another session is forwarded",
+			change: change("other", "notes/a.md"),
+			want:   true,
+		},
+		{
+			name:   "change of the session itself is not sent back",
+			change: change("session", "notes/a.md"),
+		},
+		{
+			name:          "upload-only clients get no changes",
+			syncDirection: client.SyncDirectionUploadOnly,
+			change:        change("other", "notes/a.md"),
+		},
+		{
+			name:         "items outside of the subscription are skipped",
+			subscription: client.Subscription{Include: []string{"notes"}},
+			change:       change("other", "archive/a.md"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProcessor()
+			p.WebsocketClient.Client.SyncDirection = tt.syncDirection
+			p.WebsocketClient.Client.Subscription = tt.subscription
+			p.storeInfo()
+
+			if got := p.forwards(tt.change); got != tt.want {
+				t.Errorf("forwards() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestForwardsWhileSessionChanges runs the fan-out check while the read loop changes the session. Run with -race
+func TestForwardsWhileSessionChanges(t *testing.T) {
+	p := newTestProcessor()
+	change := &pubsub.ItemChange{Origin: "other", Item: models.Item{ServerPath: "notes/a.md"}}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 1000; i++ {
+			p.WebsocketClient.Client.SyncDirection = i%3 + 1
+			p.WebsocketClient.Client.Subscription = client.Subscription{Include: []string{"notes"}}
+			p.storeInfo()
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		p.forwards(change)
+	}
+
+	wg.Wait()
+}
@@ -6,7 +6,7 @@ import (
 
 // Init will send a session id message to the client
 func (p *Processor) NewSession() {
-	if err := p.WebsocketClient.SendMessage(rest.NewSessionMessage(p.Session.SessionID)); err != nil {
+	if err := p.WebsocketClient.SendPriorityMessage(rest.NewSessionMessage(p.Session.SessionID)); err != nil {
 		return
 	}
 }
@@ -15,4 +15,5 @@ func (p *Processor) NewSession() {
 // Call this when information stored in the session changes
 func (p *Processor) UpdateSession() {
 	p.Session.Update()
+	p.storeInfo()
 }
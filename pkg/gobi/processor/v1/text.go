@@ -1,19 +1,23 @@
 package processor_v1
 
 import (
-	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strings"
 
+	"github.com/Michaelpalacce/gobi/pkg/client"
+	"github.com/Michaelpalacce/gobi/pkg/iops"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
 	v1 "github.com/Michaelpalacce/gobi/pkg/messages/v1"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/Michaelpalacce/gobi/pkg/storage"
 )
 
 // ProcessServerTextMessage will decide how to process the text message.
+// Errors are classified as *v1.Error where the cause is known, so the client can be told what went wrong
 func (p *Processor) ProcessServerTextMessage(websocketMessage messages.WebsocketMessage) error {
 	if p.WebsocketClient.Client.Version == 0 {
-		return fmt.Errorf("before communications can happen, client must send %s message to specify version to use for responses", messages.VersionType)
+		return v1.NewError(v1.ErrorCodeInvalidMessage, false, fmt.Errorf("before communications can happen, client must send %s message to specify version to use for responses", messages.VersionType))
 	}
 
 	switch websocketMessage.Type {
@@ -27,12 +31,37 @@ func (p *Processor) ProcessServerTextMessage(websocketMessage messages.Websocket
 		if err := p.processSyncStrategyMessage(websocketMessage); err != nil {
 			return err
 		}
+		// The client tells us in which direction it wants to sync
+	case v1.SyncDirectionType:
+		if err := p.processSyncDirectionMessage(websocketMessage); err != nil {
+			return err
+		}
+		// The client tells us which paths it wants to sync
+	case v1.SubscriptionType:
+		if err := p.processSubscriptionMessage(websocketMessage); err != nil {
+			return err
+		}
 	case v1.SyncType:
 		if err := p.processSyncMessage(websocketMessage); err != nil {
 			return err
 		}
+		// The client asks how much storage is used
+	case v1.UsageType:
+		if err := p.sendUsage(); err != nil {
+			return err
+		}
+		// The client tells us that processing one of our messages or syncing an item failed
+	case v1.ErrorType:
+		if err := p.processErrorMessage(websocketMessage); err != nil {
+			return err
+		}
+		// The client tells us how it resolved a conflict
+	case v1.ConflictType:
+		if err := p.processConflictMessage(websocketMessage); err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("unknown websocket message type: %s for version 1", websocketMessage.Type)
+		return fmt.Errorf("%w: %s for version 1", messages.ErrUnknownType, websocketMessage.Type)
 	}
 
 	return nil
@@ -41,7 +70,7 @@ func (p *Processor) ProcessServerTextMessage(websocketMessage messages.Websocket
 func (p *Processor) processSyncStrategyMessage(websocketMessage messages.WebsocketMessage) error {
 	var syncStrategyPayload v1.SyncStrategyPayload
 
-	if err := json.Unmarshal(websocketMessage.Payload, &syncStrategyPayload); err != nil {
+	if err := websocketMessage.Decode(&syncStrategyPayload); err != nil {
 		return err
 	}
 
@@ -54,46 +83,204 @@ func (p *Processor) processSyncStrategyMessage(websocketMessage messages.Websock
 	return nil
 }
 
-// processVaultNameMessage will set the VaultName in the client if when it's sent
-// This is also when the Storage Driver is created
+// processSyncDirectionMessage will store the direction the client wants to sync in.
+// The server will refuse uploads from download-only clients and will not send changes to upload-only clients
+func (p *Processor) processSyncDirectionMessage(websocketMessage messages.WebsocketMessage) error {
+	var syncDirectionPayload v1.SyncDirectionPayload
+
+	if err := websocketMessage.Decode(&syncDirectionPayload); err != nil {
+		return err
+	}
+
+	switch syncDirectionPayload.SyncDirection {
+	case client.SyncDirectionBidirectional, client.SyncDirectionDownloadOnly, client.SyncDirectionUploadOnly:
+		p.WebsocketClient.Client.SyncDirection = syncDirectionPayload.SyncDirection
+	default:
+		return v1.NewError(v1.ErrorCodeInvalidMessage, true, fmt.Errorf("unknown sync direction: %d", syncDirectionPayload.SyncDirection))
+	}
+
+	p.UpdateSession()
+
+	return nil
+}
+
+// processSubscriptionMessage will store the paths the client wants to sync.
+// Only items matching the subscription will be sent to the client, both when syncing and when notifying of changes.
+// If any of the paths is not valid in a vault, the subscription is left as it was
+func (p *Processor) processSubscriptionMessage(websocketMessage messages.WebsocketMessage) error {
+	var subscriptionPayload v1.SubscriptionPayload
+
+	if err := websocketMessage.Decode(&subscriptionPayload); err != nil {
+		return err
+	}
+
+	for _, path := range append(subscriptionPayload.Include, subscriptionPayload.Exclude...) {
+		if _, err := iops.NewVaultPath(strings.Trim(path, "/")); err != nil {
+			return v1.NewItemError(v1.ErrorCodePathRejected, path, err)
+		}
+	}
+
+	p.WebsocketClient.Client.Subscription = client.Subscription{
+		Include: subscriptionPayload.Include,
+		Exclude: subscriptionPayload.Exclude,
+	}
+	p.UpdateSession()
+
+	return nil
+}
+
+// processVaultNameMessage will resolve the vault the client wants to connect to and the role of the user in it.
+// Only members of the vault can connect to it. Vaults are not created on connect, they must be created through the API first.
+// This is also when the Storage Driver is created and the client is subscribed for changes in the vault.
+// Connecting to a vault is audited as a login
 func (p *Processor) processVaultNameMessage(websocketMessage messages.WebsocketMessage) error {
 	var vaultNamePayload v1.VaultNamePayload
 
-	if err := json.Unmarshal(websocketMessage.Payload, &vaultNamePayload); err != nil {
+	if err := websocketMessage.Decode(&vaultNamePayload); err != nil {
 		return err
 	}
 
+	// Nothing can be synced without the vault, so these close the connection
+	if err := iops.ValidateVaultName(vaultNamePayload.VaultName); err != nil {
+		return v1.NewError(v1.ErrorCodeUnknownVault, false, err)
+	}
+
+	vault, role, err := p.VaultResolver.ResolveVault(p.WebsocketClient.User, vaultNamePayload.VaultName)
+	if err != nil {
+		return v1.NewError(v1.ErrorCodeUnknownVault, false, err)
+	}
+
 	p.WebsocketClient.Client.VaultName = vaultNamePayload.VaultName
-	storageDriver, err := storage.NewLocalDriver(vaultNamePayload.VaultName)
+	storageDriver, err := storage.NewLocalDriver(vault.ID.Hex())
 	if err != nil {
 		return err
 	}
 
+	p.Vault = vault
+	p.Role = role
+	p.Session.VaultId = vault.ID.Hex()
+	p.Session.Role = role
 	p.WebsocketClient.StorageDriver = storageDriver
 	p.UpdateSession()
 
+	if p.Auditor != nil {
+		actor := models.ActorOf(p.WebsocketClient.User, p.WebsocketClient.Device, p.Session.SessionID, p.WebsocketClient.Conn.RemoteAddr().String())
+		entry := actor.Entry(models.AuditActionLogin)
+		entry.VaultId = vault.ID.Hex()
+		p.Auditor.Record(entry)
+	}
+
+	p.subscribeToRedis()
+
 	return nil
 }
 
-// processSyncMessage will enqueue items since the last sync and send the metadata to the client
+// processSyncMessage will send the metadata of the items changed since the last sync to the client
 func (p *Processor) processSyncMessage(websocketMessage messages.WebsocketMessage) error {
 	var syncPayload v1.SyncPayload
 
-	if err := json.Unmarshal(websocketMessage.Payload, &syncPayload); err != nil {
+	if err := websocketMessage.Decode(&syncPayload); err != nil {
 		return err
 	}
 
-	p.WebsocketClient.StorageDriver.EnqueueItemsSince(
-		syncPayload.LastSync,
-		p.WebsocketClient.Client.VaultName,
-	)
+	if p.Vault == nil {
+		return v1.NewError(v1.ErrorCodeVaultRequired, true, fmt.Errorf("before syncing, client must send %s message to specify the vault", v1.VaultNameType))
+	}
 
-	items := p.WebsocketClient.StorageDriver.GetAllItems(storage.ConflictModeNo)
+	// Upload-only clients don't want our changes, but still need to know the sync has happened
+	if !p.WebsocketClient.Client.CanDownload() {
+		if err := p.WebsocketClient.SendMessage(v1.NewSyncDataMessage([]models.Item{}, 0)); err != nil {
+			return err
+		}
+
+		return p.sendUsage()
+	}
+
+	// The metadata and the event log are used instead of the storage, so deleted items are sent as well
+	changedItems, sequence, err := p.ItemStore.GetChangesSince(p.Vault.ID.Hex(), syncPayload.Sequence, int64(syncPayload.LastSync))
+	if err != nil {
+		return fmt.Errorf("error fetching items since last sync: %w", err)
+	}
+
+	items := make([]models.Item, 0)
+	for _, item := range changedItems {
+		if p.WebsocketClient.Client.Subscription.Matches(item.ServerPath) {
+			items = append(items, item)
+		}
+	}
+
+	slog.Debug("Items found for sync since last reconcillation", "items", items, "lastSync", syncPayload.LastSync, "sequence", syncPayload.Sequence, "vaultName", p.WebsocketClient.Client.VaultName)
+
+	if err := p.WebsocketClient.SendMessage(v1.NewSyncDataMessage(items, sequence)); err != nil {
+		return err
+	}
+
+	return p.sendUsage()
+}
+
+// sendUsage will tell the client how much storage the vault and its owner use, so it can warn before the quota is full
+func (p *Processor) sendUsage() error {
+	if p.Vault == nil {
+		return v1.NewError(v1.ErrorCodeVaultRequired, true, fmt.Errorf("before asking for usage, client must send %s message to specify the vault", v1.VaultNameType))
+	}
+
+	usage, err := p.ItemStore.GetUsage(p.Vault)
+	if err != nil {
+		return fmt.Errorf("error calculating usage: %w", err)
+	}
+
+	return p.WebsocketClient.SendPriorityMessage(v1.NewUsageMessage(*usage))
+}
 
-	slog.Debug("Items found for sync since last reconcillation", "items", items, "lastSync", syncPayload.LastSync, "vaultName", p.WebsocketClient.Client.VaultName)
+// processErrorMessage will log an error the client ran into. Fatal ones are returned, so the connection is closed
+func (p *Processor) processErrorMessage(websocketMessage messages.WebsocketMessage) error {
+	var errorPayload v1.ErrorPayload
 
-	// @TODO: send items to the client in a new message format
-	// p.WebsocketClient.SendMessage()
+	if err := websocketMessage.Decode(&errorPayload); err != nil {
+		return err
+	}
+
+	slog.Warn("Client reported an error", "code", errorPayload.Code, "path", errorPayload.Path, "error", errorPayload.Message, "recoverable", errorPayload.Recoverable, "sessionId", p.Session.SessionID)
+
+	if !errorPayload.Recoverable {
+		return errorPayload.Err()
+	}
+
+	return nil
+}
+
+// processConflictMessage will record a conflict the client resolved on its own in the audit log
+func (p *Processor) processConflictMessage(websocketMessage messages.WebsocketMessage) error {
+	var conflictPayload v1.ConflictPayload
+
+	if err := websocketMessage.Decode(&conflictPayload); err != nil {
+		return err
+	}
+
+	if p.Vault == nil {
+		return v1.NewError(v1.ErrorCodeInvalidMessage, true, fmt.Errorf("conflicts can only be reported once connected to a vault"))
+	}
+
+	conflictPath, err := iops.NewVaultPath(conflictPayload.Path)
+	if err != nil {
+		return v1.NewError(v1.ErrorCodeInvalidMessage, true, err)
+	}
+
+	if !conflictPayload.Resolution.Valid() {
+		return v1.NewError(v1.ErrorCodeInvalidMessage, true, fmt.Errorf("unknown conflict resolution: %s", conflictPayload.Resolution))
+	}
+
+	if p.Auditor != nil {
+		actor := models.ActorOf(p.WebsocketClient.User, p.WebsocketClient.Device, p.Session.SessionID, p.WebsocketClient.Conn.RemoteAddr().String())
+		entry := actor.Entry(models.AuditActionConflict)
+		entry.VaultId = p.Vault.ID.Hex()
+		entry.Path = conflictPath.String()
+		entry.Details = fmt.Sprintf("resolved on the client: %s", conflictPayload.Resolution)
+		if conflictPayload.Copy != "" {
+			entry.Details += fmt.Sprintf(", local edit saved as %s", conflictPayload.Copy)
+		}
+		p.Auditor.Record(entry)
+	}
 
 	return nil
 }
@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+	"github.com/Michaelpalacce/gobi/pkg/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// ItemChange is published whenever an item in a vault changes.
+// Every server subscribed to the vault will forward it to their connected clients
+type ItemChange struct {
+	// Origin is the session ID that made the change, so it's not sent back to the same client
+	Origin string      `json:"origin"`
+	Item   models.Item `json:"item"`
+}
+
+// MembershipChange is published whenever a member is removed from a vault or their role changes.
+// Every server disconnects the clients of that member, so they have to connect again with their new role, if they still have one
+type MembershipChange struct {
+	UserId string `json:"user_id"`
+}
+
+// VaultChannel returns the name of the Redis channel used for changes in the given vault.
+// Channels are keyed by vault ID, so all members of a shared vault receive the changes
+func VaultChannel(vaultId string) string {
+	return fmt.Sprintf("vault-%s", vaultId)
+}
+
+// MembersChannel returns the name of the Redis channel used for membership changes in the given vault
+func MembersChannel(vaultId string) string {
+	return fmt.Sprintf("vault-members-%s", vaultId)
+}
+
+// PublishItemChange will notify all subscribers of the vault that the item has changed
+func PublishItemChange(vaultId, origin string, item models.Item) error {
+	change, err := json.Marshal(ItemChange{Origin: origin, Item: item})
+	if err != nil {
+		return fmt.Errorf("error marshalling item change: %w", err)
+	}
+
+	return redis.Publish(VaultChannel(vaultId), change)
+}
+
+// PublishMembershipChange will notify all servers that the membership of the user in the vault has changed
+func PublishMembershipChange(vaultId, userId string) error {
+	change, err := json.Marshal(MembershipChange{UserId: userId})
+	if err != nil {
+		return fmt.Errorf("error marshalling membership change: %w", err)
+	}
+
+	return redis.Publish(MembersChannel(vaultId), change)
+}
+
+// SubscribeToVault will subscribe to the item and membership changes of the given vault
+// The caller is responsible for closing the returned PubSub
+func SubscribeToVault(vaultId string) *goredis.PubSub {
+	return redis.Subscribe(VaultChannel(vaultId), MembersChannel(vaultId))
+}
+
+// DecodeItemChange will decode a message received on a vault channel
+func DecodeItemChange(payload string) (*ItemChange, error) {
+	change := &ItemChange{}
+	if err := json.Unmarshal([]byte(payload), change); err != nil {
+		return nil, fmt.Errorf("error unmarshalling item change: %w", err)
+	}
+
+	return change, nil
+}
+
+// DecodeMembershipChange will decode a message received on a members channel
+func DecodeMembershipChange(payload string) (*MembershipChange, error) {
+	change := &MembershipChange{}
+	if err := json.Unmarshal([]byte(payload), change); err != nil {
+		return nil, fmt.Errorf("error unmarshalling membership change: %w", err)
+	}
+
+	return change, nil
+}
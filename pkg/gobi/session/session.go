@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/client"
@@ -18,15 +19,22 @@ type Session struct {
 	SessionID string                 `json:"session_id"`
 	Client    *client.ClientMetadata `json:"client"`
 	User      *models.User           `json:"user"`
+	// VaultId is the ID of the vault the session is connected to
+	VaultId string `json:"vault_id"`
+	// Role is the role of the user in the connected vault
+	Role models.Role `json:"role"`
+	// Device is the User-Agent the client connected with. Only informative, the client chooses it
+	Device string `json:"device,omitempty"`
 }
 
 // NewSession will instantiate a new Session
 // We pass the client and user as a reference, so updates to the client and user will be reflected in the session
-func NewSession(client *client.ClientMetadata, user *models.User) *Session {
+func NewSession(client *client.ClientMetadata, user *models.User, device string) *Session {
 	session := &Session{
 		SessionID: uuid.New().String(),
 		Client:    client,
 		User:      user,
+		Device:    device,
 	}
 
 	session.Update()
@@ -35,8 +43,11 @@ func NewSession(client *client.ClientMetadata, user *models.User) *Session {
 }
 
 // Update will update the session in redis
+// The key is also added to the sessions of the user, so they can all be found without scanning the keys
 func (s *Session) Update() {
-	redis.Set(fmt.Sprintf("%s-%s-%s", s.User.Username, s.Client.VaultName, s.SessionID), s.Encode(), ExpirationTime)
+	redis.Set(s.Key(), s.Encode(), ExpirationTime)
+	redis.SAdd(UserSessionsKey(s.User.Username), s.Key())
+	redis.Expire(UserSessionsKey(s.User.Username), ExpirationTime)
 }
 
 // Encode will encode the session into a string
@@ -66,3 +77,142 @@ func RestoreSession(encoded string) (*Session, error) {
 
 	return &session, nil
 }
+
+// Delete will remove the session from redis, so it can no longer be used
+func (s *Session) Delete() error {
+	return DeleteSession(s.User.Username, s.Client.VaultName, s.SessionID)
+}
+
+// DeleteSession will remove the session with the given ID from redis, so it can no longer be used
+func DeleteSession(username, vaultName, sessionId string) error {
+	key := Key(username, vaultName, sessionId)
+	if err := redis.Del(key); err != nil {
+		return err
+	}
+
+	return redis.SRem(UserSessionsKey(username), key)
+}
+
+// ExtendSession will reset the expiration of the session with the given ID, without changing it
+func ExtendSession(username, vaultName, sessionId string) error {
+	if err := redis.Expire(Key(username, vaultName, sessionId), ExpirationTime); err != nil {
+		return err
+	}
+
+	return redis.Expire(UserSessionsKey(username), ExpirationTime)
+}
+
+// Key returns the key under which the session is stored in redis
+func (s *Session) Key() string {
+	return Key(s.User.Username, s.Client.VaultName, s.SessionID)
+}
+
+// Key returns the key under which a session is stored in redis
+// Sessions are keyed `<user>-<vault>-<id>`
+func Key(username, vaultName, sessionId string) string {
+	return fmt.Sprintf("%s-%s-%s", username, vaultName, sessionId)
+}
+
+// UserSessionsKey returns the key of the set that holds the keys of all sessions of the user
+func UserSessionsKey(username string) string {
+	return fmt.Sprintf("sessions:%s", username)
+}
+
+// GetSession will fetch the session from redis.
+// Returns an error if the session does not exist or has expired
+func GetSession(username, vaultName, sessionId string) (*Session, error) {
+	encoded, err := redis.Get(Key(username, vaultName, sessionId))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching session: %w", err)
+	}
+
+	return RestoreSession(encoded)
+}
+
+// DeleteUserSessions will remove all sessions of the user from redis, including ones of clients that are not connected
+func DeleteUserSessions(username string) error {
+	keys, err := redis.SMembers(UserSessionsKey(username))
+	if err != nil {
+		return fmt.Errorf("error fetching sessions: %w", err)
+	}
+
+	if err := redis.Del(append(keys, UserSessionsKey(username))...); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteVaultSessions will remove all sessions of the user in the vault with the given name from redis.
+func DeleteVaultSessions(username, vaultName string) error {
+	keys, err := vaultSessionKeys(username, vaultName)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := redis.Del(key); err != nil {
+			return fmt.Errorf("error deleting session: %w", err)
+		}
+
+		if err := redis.SRem(UserSessionsKey(username), key); err != nil {
+			return fmt.Errorf("error deleting session: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// HasDownloadOnlySession reports whether the user has a live download-only session in the vault.
+// Used to refuse writes that do not say which session they come from
+func HasDownloadOnlySession(username, vaultName string) (bool, error) {
+	keys, err := vaultSessionKeys(username, vaultName)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		encoded, err := redis.Get(key)
+		if err != nil {
+			continue
+		}
+
+		session, err := RestoreSession(encoded)
+		if err != nil || session.Client == nil {
+			continue
+		}
+
+		if !session.Client.CanUpload() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// vaultSessionKeys returns the keys of all sessions of the user in the vault with the given name.
+// Vault names can contain dashes, so the prefix of the key is not enough and each session is checked as well
+func vaultSessionKeys(username, vaultName string) ([]string, error) {
+	keys, err := redis.SMembers(UserSessionsKey(username))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+
+	var vaultKeys []string
+	prefix := Key(username, vaultName, "")
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if encoded, err := redis.Get(key); err == nil {
+			if session, err := RestoreSession(encoded); err == nil && session.Client != nil && session.Client.VaultName != vaultName {
+				continue
+			}
+		}
+
+		vaultKeys = append(vaultKeys, key)
+	}
+
+	return vaultKeys, nil
+}
@@ -0,0 +1,230 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file, located at the root of the vault.
+// It is synced as a normal file, so all devices share the same rules
+const FileName = ".gobiignore"
+
+// ConfigDir is the directory where the client stores its settings. It can never be synced
+const ConfigDir = ".gobi"
+
+// TempFilePrefix is the prefix of the temporary files items are written to, before they replace the item
+const TempFilePrefix = ".gobi-tmp-"
+
+// DefaultPatterns are always applied before the patterns in the ignore file.
+// They can be negated in the ignore file, with the exception of the ConfigDir, which is always ignored
+var DefaultPatterns = []string{
+	// Editor swap and backup files
+	"*.swp",
+	"*.swo",
+	"*~",
+	".#*",
+	"#*#",
+	// OS junk
+	".DS_Store",
+	"._*",
+	"Thumbs.db",
+	"desktop.ini",
+	// Items that are still being written
+	TempFilePrefix + "*",
+}
+
+// rule is a single parsed line of an ignore file
+type rule struct {
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides if a path should be ignored, following gitignore syntax
+type Matcher struct {
+	rules []rule
+}
+
+// New creates a Matcher from the DefaultPatterns followed by the given patterns.
+// Later patterns take precedence over earlier ones
+func New(patterns []string) *Matcher {
+	m := &Matcher{}
+
+	for _, pattern := range append(append([]string{}, DefaultPatterns...), patterns...) {
+		if r, ok := parseRule(pattern); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+
+	return m
+}
+
+// Load reads the ignore file from the root of the given vault and creates a Matcher from it.
+// A missing ignore file is not an error, only the DefaultPatterns will be used
+func Load(vaultPath string) (*Matcher, error) {
+	file, err := os.Open(filepath.Join(vaultPath, FileName))
+	if os.IsNotExist(err) {
+		return New(nil), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening ignore file: %w", err)
+	}
+	defer file.Close()
+
+	patterns, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(patterns), nil
+}
+
+// Parse reads the patterns from the given reader, one per line
+func Parse(reader io.Reader) ([]string, error) {
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ignore file: %w", err)
+	}
+
+	return patterns, nil
+}
+
+// Match returns true if the given path should be ignored.
+// The path must be relative to the vault root and use forward slashes.
+// A path is ignored if any of its parent directories are ignored, like in git
+func (m *Matcher) Match(path string, isDir bool) bool {
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	if path == "" {
+		return false
+	}
+
+	segments := strings.Split(path, "/")
+	if segments[0] == ConfigDir {
+		return true
+	}
+
+	for i := 1; i < len(segments); i++ {
+		if m.matchSelf(strings.Join(segments[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return m.matchSelf(path, isDir)
+}
+
+// matchSelf applies the rules to the path only, without checking the parent directories. The last matching rule wins
+func (m *Matcher) matchSelf(path string, isDir bool) bool {
+	ignored := false
+
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		if r.regex.MatchString(path) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+// parseRule will convert a single gitignore line to a rule. Returns false for blank lines and comments
+func parseRule(line string) (rule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+
+	r := rule{}
+
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// Escaped leading `!` or `#`
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return rule{}, false
+	}
+
+	// A slash at the beginning or in the middle anchors the pattern to the vault root
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expression := globToRegex(line)
+	if !anchored && !strings.HasPrefix(line, "**/") {
+		expression = "(?:.*/)?" + expression
+	}
+
+	regex, err := regexp.Compile("^" + expression + "$")
+	if err != nil {
+		return rule{}, false
+	}
+
+	r.regex = regex
+
+	return r, true
+}
+
+// globToRegex converts a gitignore glob to a regular expression
+func globToRegex(glob string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			builder.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case c == '*':
+			builder.WriteString("[^/]*")
+		case c == '?':
+			builder.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				builder.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			builder.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return builder.String()
+}
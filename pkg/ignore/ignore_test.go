@@ -0,0 +1,86 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	patterns := []string{
+		"# comment",
+		"",
+		"*.log",
+		"!important.log",
+		"build/",
+		"/root.txt",
+		"docs/*.pdf",
+		"**/cache",
+		"assets/**",
+		"a/**/z",
+		"file[0-9].txt",
+		"!.DS_Store",
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".gobi", true, true},
+		{".gobi/settings.json", false, true},
+		{".gobi/sync.json", false, true},
+		{"notes/.gobi", true, false},
+		{".gobiignore", false, false},
+		{"notes.md.swp", false, true},
+		{"notes.md~", false, true},
+		{"Thumbs.db", false, true},
+		{".DS_Store", false, false},
+		{"debug.log", false, true},
+		{"nested/debug.log", false, true},
+		{"important.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"build/output.bin", false, true},
+		{"src/build/output.bin", false, true},
+		{"root.txt", false, true},
+		{"nested/root.txt", false, false},
+		{"docs/manual.pdf", false, true},
+		{"docs/nested/manual.pdf", false, false},
+		{"cache", true, true},
+		{"deep/nested/cache", true, true},
+		{"deep/nested/cache/file", false, true},
+		{"assets", true, false},
+		{"assets/logo.png", false, true},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"file1.txt", false, true},
+		{"fileA.txt", false, false},
+		{"notes/readme.md", false, false},
+	}
+
+	m := New(patterns)
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := m.Match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	patterns, err := Parse(strings.NewReader("*.log\n\n# comment\n!keep.log\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(patterns) != 4 {
+		t.Errorf("Parse() returned %d patterns, want 4", len(patterns))
+	}
+
+	m := New(patterns)
+	if !m.Match("debug.log", false) || m.Match("keep.log", false) {
+		t.Errorf("parsed patterns did not match as expected")
+	}
+}
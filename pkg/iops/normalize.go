@@ -0,0 +1,59 @@
+package iops
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizePath returns the key used to detect paths that point to the same file on some filesystems.
+// Case-insensitive filesystems treat `Notes.md` and `notes.md` as the same file, while others normalize unicode names,
+// so `é` written as one or two code points is the same name. Both are folded into the same key
+func NormalizePath(p string) string {
+	return strings.ToLower(norm.NFC.String(p))
+}
+
+// Collides returns true if the two paths are different, but would point to the same file on some filesystems
+func Collides(a, b string) bool {
+	return a != b && NormalizePath(a) == NormalizePath(b)
+}
+
+// WithSuffix returns the path with ` (n)` added to the name, before the extension.
+// Used to rename items whose name collides with an existing one
+func (p VaultPath) WithSuffix(n int) (VaultPath, error) {
+	dir, name := path.Split(string(p))
+
+	extension := path.Ext(name)
+	// Hidden files without an extension, like `.gitignore`, keep the whole name
+	if extension == name {
+		extension = ""
+	}
+
+	return NewVaultPath(fmt.Sprintf("%s%s (%d)%s", dir, strings.TrimSuffix(name, extension), n, extension))
+}
+
+// FindCollisions groups the paths that collide with each other. Paths without collisions are not returned
+func FindCollisions(paths []string) [][]string {
+	groups := make(map[string][]string)
+	keys := make([]string, 0)
+
+	for _, p := range paths {
+		key := NormalizePath(p)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], p)
+	}
+
+	collisions := make([][]string, 0)
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			collisions = append(collisions, groups[key])
+		}
+	}
+
+	return collisions
+}
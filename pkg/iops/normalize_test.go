@@ -0,0 +1,69 @@
+package iops
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollides(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"notes.md", "notes.md", false},
+		{"Notes.md", "notes.md", true},
+		{"dir/NOTES.MD", "dir/notes.md", true},
+		{"Dir/notes.md", "dir/notes.md", true},
+		{"caf\u00e9.md", "cafe\u0301.md", true},
+		{"CAF\u00c9.md", "cafe\u0301.md", true},
+		{"notes.md", "notes.txt", false},
+		{"a/notes.md", "b/notes.md", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"|"+tt.b, func(t *testing.T) {
+			if got := Collides(tt.a, tt.b); got != tt.want {
+				t.Errorf("Collides(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithSuffix(t *testing.T) {
+	tests := []struct {
+		path VaultPath
+		n    int
+		want VaultPath
+	}{
+		{"notes.md", 1, "notes (1).md"},
+		{"dir/Notes.md", 2, "dir/Notes (2).md"},
+		{"archive.tar.gz", 1, "archive.tar (1).gz"},
+		{"README", 1, "README (1)"},
+		{".gitignore", 1, ".gitignore (1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path.String(), func(t *testing.T) {
+			got, err := tt.path.WithSuffix(tt.n)
+			if err != nil {
+				t.Fatalf("WithSuffix() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("WithSuffix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCollisions(t *testing.T) {
+	paths := []string{"Notes.md", "todo.md", "notes.md", "caf\u00e9.md", "cafe\u0301.md", "NOTES.md"}
+	want := [][]string{
+		{"Notes.md", "notes.md", "NOTES.md"},
+		{"caf\u00e9.md", "cafe\u0301.md"},
+	}
+
+	if got := FindCollisions(paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindCollisions() = %q, want %q", got, want)
+	}
+}
@@ -3,7 +3,6 @@ package iops
 import (
 	"fmt"
 	"path/filepath"
-	"strings"
 )
 
 // JoinSafe joins the provided path segments and ensures the resulting path is within the base directory.
@@ -13,19 +12,20 @@ func JoinSafe(path ...string) (string, error) {
 		return "", fmt.Errorf("no path provided")
 	}
 
-	base := path[0]
-
-	joinedPath := filepath.Join(path...)
-
-	// Ensure the cleaned path is still within the base directory
-	if !strings.HasPrefix(joinedPath, base) {
-		return "", fmt.Errorf("path is outside of base directory")
+	base, err := filepath.Abs(path[0])
+	if err != nil {
+		return "", fmt.Errorf("error getting absolute path: %w", err)
 	}
 
-	absPath, err := filepath.Abs(joinedPath)
+	absPath, err := filepath.Abs(filepath.Join(path...))
 	if err != nil {
 		return "", fmt.Errorf("error getting absolute path: %w", err)
 	}
 
+	// Ensure the cleaned path is still within the base directory
+	if !Within(base, absPath) {
+		return "", fmt.Errorf("path is outside of base directory")
+	}
+
 	return absPath, nil
 }
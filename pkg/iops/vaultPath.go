@@ -0,0 +1,187 @@
+package iops
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxPathLength is the maximum length of a VaultPath in bytes
+	maxPathLength = 4096
+	// maxNameLength is the maximum length of a single segment of a VaultPath in bytes
+	maxNameLength = 255
+)
+
+// VaultPath is a path relative to the root of a vault, using forward slashes.
+// It can only be created through NewVaultPath, which guarantees it can never point outside of the vault
+// Every path that comes from the network must be converted to a VaultPath before it touches the disk
+type VaultPath string
+
+// NewVaultPath validates the given path and returns it as a VaultPath.
+// Rejects empty and absolute paths, `.` and `..` segments, empty segments and invalid names
+func NewVaultPath(raw string) (VaultPath, error) {
+	if raw == "" {
+		return "", fmt.Errorf("path cannot be empty")
+	}
+
+	if len(raw) > maxPathLength {
+		return "", fmt.Errorf("path is longer than %d bytes", maxPathLength)
+	}
+
+	if strings.HasPrefix(raw, "/") || filepath.IsAbs(raw) || filepath.VolumeName(raw) != "" {
+		return "", fmt.Errorf("path must be relative to the vault: %q", raw)
+	}
+
+	for _, segment := range strings.Split(raw, "/") {
+		if err := ValidateName(segment); err != nil {
+			return "", fmt.Errorf("invalid path %q: %w", raw, err)
+		}
+	}
+
+	return VaultPath(raw), nil
+}
+
+// ValidateName checks that the given name can be used as a single file or directory name
+func ValidateName(name string) error {
+	switch name {
+	case "":
+		return fmt.Errorf("name cannot be empty")
+	case ".", "..":
+		return fmt.Errorf("name cannot be %q", name)
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name is longer than %d bytes", maxNameLength)
+	}
+
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("name must be valid UTF-8")
+	}
+
+	for _, char := range name {
+		if char == '/' || char == '\\' || unicode.IsControl(char) {
+			return fmt.Errorf("name contains an invalid character %q", char)
+		}
+	}
+
+	return nil
+}
+
+// ValidateVaultName checks that the given name can be used as the name of a vault
+// Vault names are used as directory names, so they follow the same rules, but cannot be hidden
+func ValidateVaultName(name string) error {
+	if err := ValidateName(name); err != nil {
+		return fmt.Errorf("invalid vault name: %w", err)
+	}
+
+	if strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid vault name: cannot start with a dot")
+	}
+
+	return nil
+}
+
+// String returns the path with forward slashes
+func (p VaultPath) String() string {
+	return string(p)
+}
+
+// Join returns the absolute path of the VaultPath inside the given base directory.
+// Symlinks in the existing part of the path are resolved, and an error is returned if they point outside of the base
+func (p VaultPath) Join(base string) (string, error) {
+	if _, err := NewVaultPath(string(p)); err != nil {
+		return "", err
+	}
+
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", fmt.Errorf("error getting absolute path: %w", err)
+	}
+
+	joinedPath := filepath.Join(absBase, filepath.FromSlash(string(p)))
+	if !Within(absBase, joinedPath) {
+		return "", fmt.Errorf("path is outside of base directory")
+	}
+
+	resolvedBase, err := filepath.EvalSymlinks(absBase)
+	if err != nil {
+		// The base does not exist yet, so nothing inside of it can be a symlink
+		if os.IsNotExist(err) {
+			return joinedPath, nil
+		}
+
+		return "", fmt.Errorf("error resolving base directory: %w", err)
+	}
+
+	resolvedPath, err := resolveExisting(joinedPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !Within(resolvedBase, resolvedPath) {
+		return "", fmt.Errorf("path escapes the base directory through a symlink")
+	}
+
+	return joinedPath, nil
+}
+
+// ResolveLinkTarget returns the item a symlink at the given path points to.
+// The target must be relative to the directory of the symlink, use forward slashes and stay inside of the vault
+func ResolveLinkTarget(link VaultPath, target string) (VaultPath, error) {
+	if target == "" || strings.HasPrefix(target, "/") || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "", fmt.Errorf("symlink target must be relative: %q", target)
+	}
+
+	if strings.Contains(target, "\\") {
+		return "", fmt.Errorf("symlink target must use forward slashes: %q", target)
+	}
+
+	resolved, err := NewVaultPath(path.Join(path.Dir(string(link)), target))
+	if err != nil {
+		return "", fmt.Errorf("symlink target points outside of the vault: %q", target)
+	}
+
+	return resolved, nil
+}
+
+// Within returns true if the target is the base directory itself or is located inside of it.
+// Both paths must be absolute and clean. Unlike a string prefix check, `/vaults-evil` is not within `/vaults`
+func Within(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel))
+}
+
+// resolveExisting resolves the symlinks of the deepest existing ancestor of the path and appends the rest of the path to it
+// A symlink as the last segment is resolved as well, so a link pointing outside of the base is caught
+func resolveExisting(path string) (string, error) {
+	missing := make([]string, 0)
+	current := path
+
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error resolving path: %w", err)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+
+		missing = append([]string{filepath.Base(current)}, missing...)
+		current = parent
+	}
+}
@@ -0,0 +1,215 @@
+package iops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewVaultPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"notes.md", false},
+		{"nested/dir/notes.md", false},
+		{".obsidian/config", false},
+		{"file..name", false},
+		{"unicode/żółw.md", false},
+		{"", true},
+		{"/etc/passwd", true},
+		{"..", true},
+		{"../escape", true},
+		{"nested/../../escape", true},
+		{"nested/..", true},
+		{"./notes.md", true},
+		{"nested//notes.md", true},
+		{"nested/", true},
+		{"nested\\..\\escape", true},
+		{"null\x00byte", true},
+		{"new\nline", true},
+		{"invalid\xffutf8", true},
+		{strings.Repeat("a", 256), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := NewVaultPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewVaultPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateVaultName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"notes", false},
+		{"My Vault", false},
+		{"", true},
+		{".", true},
+		{"..", true},
+		{".hidden", true},
+		{"a/b", true},
+		{"../vaults-evil", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateVaultName(tt.name); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateVaultName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJoinSafe(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "vaults")
+
+	tests := []struct {
+		parts   []string
+		wantErr bool
+	}{
+		{[]string{base, "vault"}, false},
+		{[]string{base, "vault/nested"}, false},
+		{[]string{base}, false},
+		{[]string{base, "../vaults-evil"}, true},
+		{[]string{base, ".."}, true},
+		{[]string{base, "vault/../../escape"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.parts[1:], ","), func(t *testing.T) {
+			_, err := JoinSafe(tt.parts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("JoinSafe(%v) error = %v, wantErr %v", tt.parts, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVaultPathJoinSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "vault")
+	outside := filepath.Join(root, "outside")
+
+	for _, dir := range []string{filepath.Join(base, "inside"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := os.Symlink(filepath.Join(base, "inside"), filepath.Join(base, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    VaultPath
+		wantErr bool
+	}{
+		{"inside/notes.md", false},
+		{"missing/dir/notes.md", false},
+		{"link/notes.md", false},
+		{"escape", true},
+		{"escape/notes.md", true},
+		{"escape/missing/notes.md", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path.String(), func(t *testing.T) {
+			_, err := tt.path.Join(base)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultPath(%q).Join() error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveLinkTarget(t *testing.T) {
+	tests := []struct {
+		link    VaultPath
+		target  string
+		want    VaultPath
+		wantErr bool
+	}{
+		{"link", "notes.md", "notes.md", false},
+		{"tools/run", "../bin/run.sh", "bin/run.sh", false},
+		{"tools/run", "./run.sh", "tools/run.sh", false},
+		{"link", "", "", true},
+		{"link", "/etc/passwd", "", true},
+		{"link", "../outside", "", true},
+		{"tools/run", "../../outside", "", true},
+		{"tools/run", "..", "", true},
+		{"link", "dir\\file", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link.String()+"->"+tt.target, func(t *testing.T) {
+			got, err := ResolveLinkTarget(tt.link, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveLinkTarget(%q, %q) error = %v, wantErr %v", tt.link, tt.target, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ResolveLinkTarget(%q, %q) = %q, want %q", tt.link, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzNewVaultPath(f *testing.F) {
+	for _, seed := range []string{"notes.md", "a/b/c", "../escape", "/abs", "a/../b", "a//b", "a\\b", ".", ""} {
+		f.Add(seed)
+	}
+
+	base := f.TempDir()
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		vaultPath, err := NewVaultPath(raw)
+		if err != nil {
+			return
+		}
+
+		for _, segment := range strings.Split(vaultPath.String(), "/") {
+			if segment == ".." || segment == "." || segment == "" {
+				t.Fatalf("NewVaultPath(%q) accepted segment %q", raw, segment)
+			}
+		}
+
+		joined, err := vaultPath.Join(base)
+		if err != nil {
+			t.Fatalf("VaultPath(%q).Join() error = %v", raw, err)
+		}
+
+		if !Within(base, joined) || joined == base {
+			t.Fatalf("VaultPath(%q).Join() = %q, which is outside of %q", raw, joined, base)
+		}
+	})
+}
+
+func FuzzJoinSafe(f *testing.F) {
+	for _, seed := range []string{"vault", "../vaults-evil", "a/../../b", "..", "/etc"} {
+		f.Add(seed)
+	}
+
+	base := filepath.Join(f.TempDir(), "vaults")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		joined, err := JoinSafe(base, name)
+		if err != nil {
+			return
+		}
+
+		if !Within(base, joined) {
+			t.Fatalf("JoinSafe(%q, %q) = %q, which is outside of the base", base, name, joined)
+		}
+	})
+}
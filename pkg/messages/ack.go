@@ -0,0 +1,82 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// AckCode tells if a message was processed and if not, why
+type AckCode string
+
+const (
+	AckCodeOK             AckCode = "ok"
+	AckCodeBadRequest     AckCode = "badRequest"
+	AckCodeUnknownType    AckCode = "unknownType"
+	AckCodeUnknownVersion AckCode = "unknownVersion"
+	AckCodeInternal       AckCode = "internal"
+)
+
+// AckPayload is the reply to a message with an ID. Error is only set if the code is not ok
+type AckPayload struct {
+	Code  AckCode `json:"code"`
+	Error string  `json:"error,omitempty"`
+}
+
+// Err returns nil if the message was processed, otherwise an *AckError
+func (p AckPayload) Err() error {
+	if p.Code == AckCodeOK {
+		return nil
+	}
+
+	return &AckError{Code: p.Code, Message: p.Error}
+}
+
+// AckError is returned when the peer replied to a message with an error
+type AckError struct {
+	Code    AckCode
+	Message string
+}
+
+func (e *AckError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Recoverable returns true if the error was caused by the message itself, so the connection can still be used
+func (e *AckError) Recoverable() bool {
+	return e.Code != AckCodeInternal
+}
+
+// AckCodeOf returns the code that describes why processing a message failed
+func AckCodeOf(err error) AckCode {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case err == nil:
+		return AckCodeOK
+	case errors.Is(err, ErrUnknownType):
+		return AckCodeUnknownType
+	case errors.Is(err, ErrUnknownVersion):
+		return AckCodeUnknownVersion
+	case errors.Is(err, ErrMalformedMessage), errors.As(err, &syntaxError), errors.As(err, &typeError):
+		return AckCodeBadRequest
+	default:
+		return AckCodeInternal
+	}
+}
+
+// NewAckMessage will return the reply to the message with the given ID, telling if processing it failed with err
+func NewAckMessage(replyTo string, err error) WebsocketRequest {
+	payload := AckPayload{Code: AckCodeOf(err)}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	return WebsocketRequest{
+		Type:    AckType,
+		Payload: payload,
+		Version: 0,
+		ReplyTo: replyTo,
+	}
+}
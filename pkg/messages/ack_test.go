@@ -0,0 +1,33 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAckCodeOf(t *testing.T) {
+	var payload VersionPayload
+
+	tests := []struct {
+		name string
+		err  error
+		want AckCode
+	}{
+		{name: "no error", err: nil, want: AckCodeOK},
+		{name: "unknown type", err: fmt.Errorf("%w: foo for version 1", ErrUnknownType), want: AckCodeUnknownType},
+		{name: "unknown version", err: fmt.Errorf("%w: 7", ErrUnknownVersion), want: AckCodeUnknownVersion},
+		{name: "invalid json", err: json.Unmarshal([]byte("{"), &payload), want: AckCodeBadRequest},
+		{name: "wrong field type", err: json.Unmarshal([]byte(`{"version": "1"}`), &payload), want: AckCodeBadRequest},
+		{name: "anything else", err: errors.New("database is down"), want: AckCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AckCodeOf(tt.err); got != tt.want {
+				t.Errorf("AckCodeOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
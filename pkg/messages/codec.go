@@ -0,0 +1,133 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Encoding is how messages are encoded on the wire. It is agreed on in the hello
+type Encoding string
+
+const (
+	// EncodingJSON is sent in text frames. Every client supports it, so it is used until the hello is done
+	EncodingJSON Encoding = "json"
+	// EncodingCBOR is sent in binary frames. Smaller and cheaper to decode than JSON, which matters for large manifests
+	EncodingCBOR Encoding = "cbor"
+)
+
+// ErrMalformedMessage is returned for messages and payloads that could not be decoded
+var ErrMalformedMessage = errors.New("malformed websocket message")
+
+// Codec encodes and decodes messages, so processors work the same no matter which encoding was agreed on
+type Codec interface {
+	Encoding() Encoding
+	// Binary is true if the messages are sent in binary frames instead of text frames
+	Binary() bool
+	Marshal(request WebsocketRequest) ([]byte, error)
+	Unmarshal(data []byte) (WebsocketMessage, error)
+	// UnmarshalPayload decodes the payload of a message that was unmarshaled by this codec
+	UnmarshalPayload(payload RawPayload, v any) error
+}
+
+var (
+	// JSON is the codec for EncodingJSON
+	JSON Codec = jsonCodec{}
+	// CBOR is the codec for EncodingCBOR
+	CBOR Codec = cborCodec{}
+)
+
+// CodecFor returns the codec of the encoding. No encoding is JSON, for peers that did not agree on one
+func CodecFor(encoding Encoding) (Codec, bool) {
+	switch encoding {
+	case "", EncodingJSON:
+		return JSON, true
+	case EncodingCBOR:
+		return CBOR, true
+	default:
+		return nil, false
+	}
+}
+
+// RawPayload is a payload that was not decoded yet. Decode it with WebsocketMessage.Decode, which knows its encoding
+type RawPayload []byte
+
+func (p RawPayload) MarshalJSON() ([]byte, error) {
+	if p == nil {
+		return []byte("null"), nil
+	}
+
+	return p, nil
+}
+
+func (p *RawPayload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[0:0], data...)
+	return nil
+}
+
+func (p RawPayload) MarshalCBOR() ([]byte, error) {
+	if p == nil {
+		// CBOR null
+		return []byte{0xf6}, nil
+	}
+
+	return p, nil
+}
+
+func (p *RawPayload) UnmarshalCBOR(data []byte) error {
+	*p = append((*p)[0:0], data...)
+	return nil
+}
+
+// ------------------------------ JSON ------------------------------
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encoding() Encoding { return EncodingJSON }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(request WebsocketRequest) ([]byte, error) {
+	return json.Marshal(request)
+}
+
+func (c jsonCodec) Unmarshal(data []byte) (WebsocketMessage, error) {
+	message := WebsocketMessage{codec: c}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	return message, nil
+}
+
+func (jsonCodec) UnmarshalPayload(payload RawPayload, v any) error {
+	return json.Unmarshal(payload, v)
+}
+
+// ------------------------------ CBOR ------------------------------
+
+// cborCodec uses the json tags of the payloads as keys, so payloads need no extra tags
+type cborCodec struct{}
+
+func (cborCodec) Encoding() Encoding { return EncodingCBOR }
+
+func (cborCodec) Binary() bool { return true }
+
+func (cborCodec) Marshal(request WebsocketRequest) ([]byte, error) {
+	return cbor.Marshal(request)
+}
+
+func (c cborCodec) Unmarshal(data []byte) (WebsocketMessage, error) {
+	message := WebsocketMessage{codec: c}
+	if err := cbor.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	return message, nil
+}
+
+func (cborCodec) UnmarshalPayload(payload RawPayload, v any) error {
+	return cbor.Unmarshal(payload, v)
+}
@@ -0,0 +1,73 @@
+package messages
+
+import (
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	request := WebsocketRequest{
+		Version: 1,
+		Type:    AckType,
+		Payload: AckPayload{Code: AckCodeBadRequest, Error: "path cannot be empty"},
+		ID:      "1",
+		ReplyTo: "2",
+	}
+
+	for _, codec := range []Codec{JSON, CBOR} {
+		t.Run(string(codec.Encoding()), func(t *testing.T) {
+			data, err := codec.Marshal(request)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+
+			message, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			if message.Version != request.Version || message.Type != request.Type || message.ID != request.ID || message.ReplyTo != request.ReplyTo {
+				t.Errorf("Unmarshal() = %+v, want %+v", message, request)
+			}
+
+			var payload AckPayload
+			if err := message.Decode(&payload); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if payload != request.Payload {
+				t.Errorf("Decode() = %+v, want %+v", payload, request.Payload)
+			}
+
+			var wrongType []int
+			if err := message.Decode(&wrongType); AckCodeOf(err) != AckCodeBadRequest {
+				t.Errorf("Decode() into the wrong type error = %v, want a bad request", err)
+			}
+
+			if _, err := codec.Unmarshal([]byte{0xff, 0x00}); AckCodeOf(err) != AckCodeBadRequest {
+				t.Errorf("Unmarshal() of garbage error = %v, want a bad request", err)
+			}
+		})
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		encoding Encoding
+		want     Codec
+		wantOk   bool
+	}{
+		{encoding: "", want: JSON, wantOk: true},
+		{encoding: EncodingJSON, want: JSON, wantOk: true},
+		{encoding: EncodingCBOR, want: CBOR, wantOk: true},
+		{encoding: "xml", want: nil, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.encoding), func(t *testing.T) {
+			got, ok := CodecFor(tt.encoding)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("CodecFor() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
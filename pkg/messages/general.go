@@ -2,24 +2,44 @@ package messages
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
 // Holds different message types
 var (
+	// Client -> Server, the client advertises the versions and capabilities it supports. Sent first, as a request
+	HelloType = "hello"
+	// Server -> Client, the reply to the hello with the version and capabilities both sides agreed on
+	HelloAckType = "helloAck"
+	// Client -> Server, the version the client wants to use. Only sent by clients that predate the hello
 	VersionType = "version"
 	CloseType   = "close"
+	// Server -> Client, the server is stopping and tells the client when to reconnect
+	ServerShutdownType = "serverShutdown"
+	// Both ways, the reply to a message with an ID, telling if it was processed or why not
+	AckType = "ack"
+)
+
+var (
+	// ErrUnknownType is returned for messages of a type the version does not know
+	ErrUnknownType = errors.New("unknown websocket message type")
+	// ErrUnknownVersion is returned for messages of a version that is not supported
+	ErrUnknownVersion = errors.New("unknown websocket version")
 )
 
 // WebsocketRequest is the general WebsocketRequest that all requests will follow.
 // The Payload will be the dynamic element
+// ID is only set if the sender wants a reply, the reply carries it in ReplyTo
 type WebsocketRequest struct {
 	Version int         `json:"version"`
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	ID      string      `json:"id,omitempty"`
+	ReplyTo string      `json:"replyTo,omitempty"`
 }
 
-// Marshal will return the response as a string
+// Marshal will return the response as JSON. Use a Codec to send it in the encoding agreed on with the peer
 func (r WebsocketRequest) Marshal() []byte {
 	data, err := json.Marshal(r)
 	if err != nil {
@@ -30,9 +50,28 @@ func (r WebsocketRequest) Marshal() []byte {
 }
 
 // WebsocketMessage is the general WebsocketMessage that is received by the client or server.
-// The distinction from the WebsocketRequest is that the payload is RawJson and can selectively be Unmarshalled
+// The distinction from the WebsocketRequest is that the payload is raw and can selectively be decoded with Decode
 type WebsocketMessage struct {
-	Version int             `json:"version"`
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	Version int        `json:"version"`
+	Type    string     `json:"type"`
+	Payload RawPayload `json:"payload"`
+	ID      string     `json:"id,omitempty"`
+	ReplyTo string     `json:"replyTo,omitempty"`
+
+	// codec is the codec the message was received with
+	codec Codec
+}
+
+// Decode will decode the payload into v, using the encoding the message was received with
+func (m WebsocketMessage) Decode(v any) error {
+	codec := m.codec
+	if codec == nil {
+		codec = JSON
+	}
+
+	if err := codec.UnmarshalPayload(m.Payload, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,106 @@
+package messages
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Capability is an optional feature of the protocol. It is only used if both sides advertise it in their hello
+type Capability string
+
+const (
+	// CapabilityCompression compresses file transfers with zstd. Messages are compressed by the websocket itself, if both
+	// sides support permessage-deflate
+	CapabilityCompression Capability = "compression"
+	// CapabilityChunking sends large files in chunks that can be resumed
+	CapabilityChunking Capability = "chunking"
+	// CapabilityDeltaSync only sends the parts of a file that changed
+	CapabilityDeltaSync Capability = "deltaSync"
+	// CapabilityEncryption encrypts the contents of items before they leave the client
+	CapabilityEncryption Capability = "encryption"
+)
+
+// Capabilities is a set of capabilities
+type Capabilities []Capability
+
+// Has returns true if the capability is part of the set
+func (c Capabilities) Has(capability Capability) bool {
+	return slices.Contains(c, capability)
+}
+
+// Intersect returns the capabilities that are part of both sets, in the order of this one
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	common := Capabilities{}
+	for _, capability := range c {
+		if other.Has(capability) && !common.Has(capability) {
+			common = append(common, capability)
+		}
+	}
+
+	return common
+}
+
+// HelloPayload is the first message the client sends. It advertises every version and capability the client supports.
+// Encodings are listed in the order the client prefers them. Without any, JSON is used
+type HelloPayload struct {
+	Versions     []int        `json:"versions"`
+	Capabilities Capabilities `json:"capabilities"`
+	Encodings    []Encoding   `json:"encodings,omitempty"`
+}
+
+// HelloAckPayload is the reply of the server to the hello, with the version, capabilities and encoding both sides will use.
+// The hello and its reply are always JSON, every message after the reply uses the encoding
+type HelloAckPayload struct {
+	Version      int          `json:"version"`
+	Capabilities Capabilities `json:"capabilities"`
+	Encoding     Encoding     `json:"encoding,omitempty"`
+}
+
+// NewHelloMessage will return a new hello message. It is always sent as a request, so the reply can be matched to it
+func NewHelloMessage(hello HelloPayload) WebsocketRequest {
+	return WebsocketRequest{
+		Type:    HelloType,
+		Payload: hello,
+		Version: 0,
+	}
+}
+
+// NewHelloAckMessage will return the reply to the hello with the given ID
+func NewHelloAckMessage(replyTo string, helloAck HelloAckPayload) WebsocketRequest {
+	return WebsocketRequest{
+		Type:    HelloAckType,
+		Payload: helloAck,
+		Version: 0,
+		ReplyTo: replyTo,
+	}
+}
+
+// Negotiate agrees on the highest version both sides support and on the capabilities both have.
+// The encoding is the first one of the remote side that is supported locally, falling back to JSON.
+// Returns ErrUnknownVersion if there is no version in common
+func Negotiate(local HelloPayload, remote HelloPayload) (HelloAckPayload, error) {
+	version := 0
+	for _, candidate := range remote.Versions {
+		if candidate > version && slices.Contains(local.Versions, candidate) {
+			version = candidate
+		}
+	}
+
+	if version == 0 {
+		return HelloAckPayload{}, fmt.Errorf("%w: none of %v, supported are %v", ErrUnknownVersion, remote.Versions, local.Versions)
+	}
+
+	encoding := EncodingJSON
+	for _, candidate := range remote.Encodings {
+		if slices.Contains(local.Encodings, candidate) {
+			encoding = candidate
+			break
+		}
+	}
+
+	return HelloAckPayload{
+		Version:      version,
+		Capabilities: local.Capabilities.Intersect(remote.Capabilities),
+		Encoding:     encoding,
+	}, nil
+}
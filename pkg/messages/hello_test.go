@@ -0,0 +1,49 @@
+package messages
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	server := HelloPayload{Versions: []int{1, 2}, Capabilities: Capabilities{CapabilityCompression, CapabilityChunking}, Encodings: []Encoding{EncodingCBOR, EncodingJSON}}
+
+	tests := []struct {
+		name             string
+		client           HelloPayload
+		wantVersion      int
+		wantCapabilities Capabilities
+		wantEncoding     Encoding
+		wantErr          error
+	}{
+		{name: "highest common version", client: HelloPayload{Versions: []int{1, 2, 3}}, wantVersion: 2, wantCapabilities: Capabilities{}, wantEncoding: EncodingJSON},
+		{name: "older client", client: HelloPayload{Versions: []int{1}}, wantVersion: 1, wantCapabilities: Capabilities{}, wantEncoding: EncodingJSON},
+		{name: "unordered versions", client: HelloPayload{Versions: []int{2, 1}}, wantVersion: 2, wantCapabilities: Capabilities{}, wantEncoding: EncodingJSON},
+		{
+			name:             "common capabilities",
+			client:           HelloPayload{Versions: []int{1}, Capabilities: Capabilities{CapabilityEncryption, CapabilityChunking, CapabilityChunking}},
+			wantVersion:      1,
+			wantCapabilities: Capabilities{CapabilityChunking},
+			wantEncoding:     EncodingJSON,
+		},
+		{name: "unknown capabilities are ignored", client: HelloPayload{Versions: []int{2}, Capabilities: Capabilities{"teleport"}}, wantVersion: 2, wantCapabilities: Capabilities{}, wantEncoding: EncodingJSON},
+		{name: "preferred encoding", client: HelloPayload{Versions: []int{1}, Encodings: []Encoding{EncodingJSON, EncodingCBOR}}, wantVersion: 1, wantCapabilities: Capabilities{}, wantEncoding: EncodingJSON},
+		{name: "unknown encodings are skipped", client: HelloPayload{Versions: []int{1}, Encodings: []Encoding{"xml", EncodingCBOR}}, wantVersion: 1, wantCapabilities: Capabilities{}, wantEncoding: EncodingCBOR},
+		{name: "no common version", client: HelloPayload{Versions: []int{3}}, wantErr: ErrUnknownVersion},
+		{name: "no versions", client: HelloPayload{}, wantErr: ErrUnknownVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate(server, tt.client)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Negotiate() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got.Version != tt.wantVersion || !slices.Equal(got.Capabilities, tt.wantCapabilities) || got.Encoding != tt.wantEncoding {
+				t.Errorf("Negotiate() = %v, %v, %v, want %v, %v, %v", got.Version, got.Capabilities, got.Encoding, tt.wantVersion, tt.wantCapabilities, tt.wantEncoding)
+			}
+		})
+	}
+}
@@ -15,6 +15,25 @@ func NewCloseMessage(reason string) WebsocketRequest {
 	}
 }
 
+// ServerShutdownPayload tells the client that the server is stopping.
+// ReconnectAfter is how many seconds the client should wait before reconnecting
+type ServerShutdownPayload struct {
+	Reason         string `json:"reason"`
+	ReconnectAfter int    `json:"reconnectAfter"`
+}
+
+// NewServerShutdownMessage will return a new server shutdown message
+func NewServerShutdownMessage(reason string, reconnectAfter int) WebsocketRequest {
+	return WebsocketRequest{
+		Type: ServerShutdownType,
+		Payload: ServerShutdownPayload{
+			Reason:         reason,
+			ReconnectAfter: reconnectAfter,
+		},
+		Version: 0,
+	}
+}
+
 // VersionPayload is a general payload that won't change.
 // It will specify what version of the websockets API to use
 type VersionPayload struct {
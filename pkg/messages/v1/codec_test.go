@@ -0,0 +1,76 @@
+package v1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+// syncDataMessage returns a manifest like the one sent on the initial sync of a vault with the given number of items
+func syncDataMessage(items int) messages.WebsocketRequest {
+	manifest := make([]models.Item, items)
+	for i := range manifest {
+		sum := sha256.Sum256([]byte(fmt.Sprint(i)))
+		manifest[i] = models.Item{
+			OwnerId:    "65a7f1c2e4b0a1b2c3d4e5f6",
+			VaultId:    "65a7f1c2e4b0a1b2c3d4e5f7",
+			ServerPath: fmt.Sprintf("notes/%d/note-%d.md", i/100, i),
+			SHA256:     hex.EncodeToString(sum[:]),
+			Size:       1024 + i,
+			Sequence:   int64(i),
+		}
+	}
+
+	return NewSyncDataMessage(manifest, int64(items))
+}
+
+func BenchmarkMarshalSyncData(b *testing.B) {
+	request := syncDataMessage(5000)
+
+	for _, codec := range []messages.Codec{messages.JSON, messages.CBOR} {
+		b.Run(string(codec.Encoding()), func(b *testing.B) {
+			var data []byte
+			var err error
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if data, err = codec.Marshal(request); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(len(data)), "bytes/msg")
+		})
+	}
+}
+
+func BenchmarkUnmarshalSyncData(b *testing.B) {
+	request := syncDataMessage(5000)
+
+	for _, codec := range []messages.Codec{messages.JSON, messages.CBOR} {
+		b.Run(string(codec.Encoding()), func(b *testing.B) {
+			data, err := codec.Marshal(request)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				message, err := codec.Unmarshal(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				var payload SyncDataPayload
+				if err := message.Decode(&payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"errors"
+
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+)
+
+// ErrorCode tells the other side what went wrong, so it can react without parsing the message
+type ErrorCode string
+
+const (
+	// ErrorCodeUnknownVault means the vault name is invalid or the vault could not be found
+	ErrorCodeUnknownVault ErrorCode = "unknownVault"
+	// ErrorCodeVaultRequired means the message can only be processed after the vault name was sent
+	ErrorCodeVaultRequired ErrorCode = "vaultRequired"
+	// ErrorCodeQuotaExceeded means the item does not fit in the quota of the vault or its owner
+	ErrorCodeQuotaExceeded ErrorCode = "quotaExceeded"
+	// ErrorCodePathRejected means a path is not valid inside of a vault
+	ErrorCodePathRejected ErrorCode = "pathRejected"
+	// ErrorCodeChecksumMismatch means the contents of an item did not match its SHA256
+	ErrorCodeChecksumMismatch ErrorCode = "checksumMismatch"
+	// ErrorCodeInvalidMessage means the message was malformed, of an unknown type or had invalid values
+	ErrorCodeInvalidMessage ErrorCode = "invalidMessage"
+	// ErrorCodeInternal means something went wrong on the side that sent the error, like the database not responding
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// Error is an error that can be sent to the other side in an error message.
+// Recoverable errors only failed the message or the item, the connection can still be used. Others close the connection
+type Error struct {
+	Code        ErrorCode
+	Message     string
+	Path        string
+	Recoverable bool
+
+	err error
+}
+
+// NewError will classify the error with the given code
+func NewError(code ErrorCode, recoverable bool, err error) *Error {
+	return &Error{
+		Code:        code,
+		Message:     err.Error(),
+		Recoverable: recoverable,
+		err:         err,
+	}
+}
+
+// NewItemError will classify an error that happened while syncing the item at the given path. Those never close the connection
+func NewItemError(code ErrorCode, path string, err error) *Error {
+	itemError := NewError(code, true, err)
+	itemError.Path = path
+
+	return itemError
+}
+
+func (e *Error) Error() string {
+	if e.Path != "" {
+		return string(e.Code) + ": " + e.Path + ": " + e.Message
+	}
+
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// AsError returns the error as an *Error. Malformed messages and unknown types are invalid messages.
+// Anything else that was not classified is internal and recoverable, as it most likely only failed this one message
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var protocolError *Error
+	if errors.As(err, &protocolError) {
+		return protocolError
+	}
+
+	if messages.AckCodeOf(err) != messages.AckCodeInternal {
+		return NewError(ErrorCodeInvalidMessage, true, err)
+	}
+
+	return NewError(ErrorCodeInternal, true, err)
+}
+
+// ErrorOf returns the error carried by the message, or nil if it is not an error message
+func ErrorOf(message *messages.WebsocketMessage) error {
+	if message == nil || message.Type != ErrorType {
+		return nil
+	}
+
+	var errorPayload ErrorPayload
+	if err := message.Decode(&errorPayload); err != nil {
+		return err
+	}
+
+	return errorPayload.Err()
+}
+
+// ------------------------------ Error ------------------------------
+
+type ErrorPayload struct {
+	Code        ErrorCode `json:"code"`
+	Message     string    `json:"message"`
+	Path        string    `json:"path,omitempty"`
+	Recoverable bool      `json:"recoverable"`
+}
+
+// Err returns the error the payload describes
+func (p ErrorPayload) Err() *Error {
+	return &Error{
+		Code:        p.Code,
+		Message:     p.Message,
+		Path:        p.Path,
+		Recoverable: p.Recoverable,
+		err:         errors.New(p.Message),
+	}
+}
+
+// NewErrorMessage will return an error message for the error. ReplyTo is the ID of the message that caused it, if it had one
+func NewErrorMessage(replyTo string, err error) messages.WebsocketRequest {
+	protocolError := AsError(err)
+
+	return messages.WebsocketRequest{
+		Type: ErrorType,
+		Payload: ErrorPayload{
+			Code:        protocolError.Code,
+			Message:     protocolError.Message,
+			Path:        protocolError.Path,
+			Recoverable: protocolError.Recoverable,
+		},
+		Version: Version,
+		ReplyTo: replyTo,
+	}
+}
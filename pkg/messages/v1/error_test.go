@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+)
+
+func TestAsError(t *testing.T) {
+	var payload SyncPayload
+
+	tests := []struct {
+		name            string
+		err             error
+		wantCode        ErrorCode
+		wantRecoverable bool
+	}{
+		{name: "classified", err: NewError(ErrorCodeUnknownVault, false, errors.New("invalid vault name")), wantCode: ErrorCodeUnknownVault, wantRecoverable: false},
+		{name: "wrapped", err: fmt.Errorf("error syncing: %w", NewItemError(ErrorCodePathRejected, "../notes", errors.New("invalid path"))), wantCode: ErrorCodePathRejected, wantRecoverable: true},
+		{name: "unknown type", err: fmt.Errorf("%w: foo for version 1", messages.ErrUnknownType), wantCode: ErrorCodeInvalidMessage, wantRecoverable: true},
+		{name: "invalid json", err: json.Unmarshal([]byte("{"), &payload), wantCode: ErrorCodeInvalidMessage, wantRecoverable: true},
+		{name: "anything else", err: errors.New("database is down"), wantCode: ErrorCodeInternal, wantRecoverable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AsError(tt.err)
+			if got.Code != tt.wantCode || got.Recoverable != tt.wantRecoverable {
+				t.Errorf("AsError() = %v, %v, want %v, %v", got.Code, got.Recoverable, tt.wantCode, tt.wantRecoverable)
+			}
+
+			if !errors.Is(got, tt.err) && !errors.Is(tt.err, got) {
+				t.Errorf("AsError() does not wrap %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestErrorOf(t *testing.T) {
+	sent := NewErrorMessage("42", NewItemError(ErrorCodeChecksumMismatch, "notes/todo.md", errors.New("expected abc, got def")))
+
+	var received messages.WebsocketMessage
+	if err := json.Unmarshal(sent.Marshal(), &received); err != nil {
+		t.Fatalf("error unmarshaling message: %v", err)
+	}
+
+	var protocolError *Error
+	if !errors.As(ErrorOf(&received), &protocolError) {
+		t.Fatalf("ErrorOf() did not return an *Error")
+	}
+
+	if protocolError.Code != ErrorCodeChecksumMismatch || protocolError.Path != "notes/todo.md" || !protocolError.Recoverable || received.ReplyTo != "42" {
+		t.Errorf("ErrorOf() = %+v, replyTo %q", protocolError, received.ReplyTo)
+	}
+
+	if err := ErrorOf(&messages.WebsocketMessage{Type: SyncType}); err != nil {
+		t.Errorf("ErrorOf() = %v for a sync message, want nil", err)
+	}
+}
@@ -7,7 +7,13 @@ var (
 	// Client -> Server, the client tells the server which sync strategy it wants to use
 	SyncStrategyType = "syncStrategy"
 
-	// Client -> Server, the client tells the server when was the last time it synced
+	// Client -> Server, the client tells the server in which direction it wants to sync (bidirectional, download-only, upload-only)
+	SyncDirectionType = "syncDirection"
+
+	// Client -> Server, the client tells the server which paths it wants to sync
+	SubscriptionType = "subscription"
+
+	// Client -> Server, the client tells the server when was the last time it synced and the last event it has seen
 	// Server -> Client, the server tells the client when was the last time it synced
 	// Denotes the start of the sync process
 	SyncType = "sync"
@@ -15,4 +21,14 @@ var (
 	// Server -> Client, the server tells the client what items have been modified since the last sync
 	// Client -> Server, the client tells the server what items have been modified since the last sync
 	SyncData = "syncData"
+
+	// Client -> Server, the client asks how much storage the vault and its owner use
+	// Server -> Client, the server tells the client how much storage is used and what the quotas are. Sent after every sync
+	UsageType = "usage"
+
+	// Both ways, processing a message or syncing an item failed. Tells whether the connection can still be used
+	ErrorType = "error"
+
+	// Client -> Server, the client resolved a conflict on its own, like reverting a local edit. Only recorded in the audit log
+	ConflictType = "conflict"
 )
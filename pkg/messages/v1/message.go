@@ -1,7 +1,9 @@
 package v1
 
 import (
+	"github.com/Michaelpalacce/gobi/pkg/client"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
+	"github.com/Michaelpalacce/gobi/pkg/models"
 )
 
 // ------------------------------ Vault Name ------------------------------
@@ -36,18 +38,148 @@ func NewSyncStrategyMessage(syncStrategy int) messages.WebsocketRequest {
 	}
 }
 
+// ------------------------------ Sync Direction ------------------------------
+
+type SyncDirectionPayload struct {
+	SyncDirection int `json:"syncDirection"`
+}
+
+func NewSyncDirectionMessage(syncDirection int) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: SyncDirectionType,
+		Payload: SyncDirectionPayload{
+			SyncDirection: syncDirection,
+		},
+		Version: Version,
+	}
+}
+
+// ------------------------------ Subscription ------------------------------
+
+type SubscriptionPayload struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+func NewSubscriptionMessage(subscription client.Subscription) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: SubscriptionType,
+		Payload: SubscriptionPayload{
+			Include: subscription.Include,
+			Exclude: subscription.Exclude,
+		},
+		Version: Version,
+	}
+}
+
 // ------------------------------ Sync ------------------------------
 
 type SyncPayload struct {
 	// LastSync is timestamp in UTC
 	LastSync int `json:"lastSync"`
+	// Sequence is the last event of the vault's event log the client has synced. Takes precedence over LastSync
+	Sequence int64 `json:"sequence,omitempty"`
 }
 
-func NewSyncMessage(lastSync int) messages.WebsocketRequest {
+func NewSyncMessage(lastSync int, sequence int64) messages.WebsocketRequest {
 	return messages.WebsocketRequest{
 		Type: SyncType,
 		Payload: SyncPayload{
 			LastSync: lastSync,
+			Sequence: sequence,
+		},
+		Version: Version,
+	}
+}
+
+// ------------------------------ Sync Data ------------------------------
+
+type SyncDataPayload struct {
+	Items []models.Item `json:"items"`
+	// Sequence is the last event of the vault's event log the items cover. Zero for changes sent while connected,
+	// those carry the sequence in each item instead
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+func NewSyncDataMessage(items []models.Item, sequence int64) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: SyncData,
+		Payload: SyncDataPayload{
+			Items:    items,
+			Sequence: sequence,
+		},
+		Version: Version,
+	}
+}
+
+// ------------------------------ Usage ------------------------------
+
+type UsagePayload struct {
+	// Usage is empty when the client asks for it
+	Usage models.QuotaUsage `json:"usage"`
+}
+
+func NewUsageMessage(usage models.QuotaUsage) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: UsageType,
+		Payload: UsagePayload{
+			Usage: usage,
+		},
+		Version: Version,
+	}
+}
+
+// ------------------------------ Conflict ------------------------------
+
+// ConflictResolution is how the client resolved a conflict
+type ConflictResolution string
+
+const (
+	// ConflictResolutionLocalWins means the local version was uploaded over the one on the server
+	ConflictResolutionLocalWins ConflictResolution = "localWins"
+	// ConflictResolutionServerWins means the local edit was reverted to the version on the server
+	ConflictResolutionServerWins ConflictResolution = "serverWins"
+	// ConflictResolutionFlagged means the local edit was kept, but not uploaded, so the user can decide
+	ConflictResolutionFlagged ConflictResolution = "flagged"
+	// ConflictResolutionCopied means the local edit was uploaded as a conflict copy next to the version on the server
+	ConflictResolutionCopied ConflictResolution = "copied"
+)
+
+// Valid returns true if the resolution is one of the known ones
+func (r ConflictResolution) Valid() bool {
+	switch r {
+	case ConflictResolutionLocalWins, ConflictResolutionServerWins, ConflictResolutionFlagged, ConflictResolutionCopied:
+		return true
+	}
+
+	return false
+}
+
+type ConflictPayload struct {
+	Path       string             `json:"path"`
+	Resolution ConflictResolution `json:"resolution"`
+	// Copy is the path the local edit was saved at, for copied conflicts
+	Copy string `json:"copy,omitempty"`
+}
+
+func NewConflictMessage(path string, resolution ConflictResolution) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: ConflictType,
+		Payload: ConflictPayload{
+			Path:       path,
+			Resolution: resolution,
+		},
+		Version: Version,
+	}
+}
+
+func NewConflictCopyMessage(path string, copyPath string) messages.WebsocketRequest {
+	return messages.WebsocketRequest{
+		Type: ConflictType,
+		Payload: ConflictPayload{
+			Path:       path,
+			Resolution: ConflictResolutionCopied,
+			Copy:       copyPath,
 		},
 		Version: Version,
 	}
@@ -0,0 +1,4 @@
+package rest
+
+// QuotaExceededCode is the error code returned with 413 when an upload would go over the quota of the user or the vault
+var QuotaExceededCode = "quotaExceeded"
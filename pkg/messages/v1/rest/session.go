@@ -2,3 +2,6 @@ package rest
 
 // SessionType is the message type sent to the client when the server creates a session for them
 var SessionType = "session"
+
+// SessionHeader is the header the client uses to tell the server which websocket session a REST request belongs to
+var SessionHeader = "X-Gobi-Session"
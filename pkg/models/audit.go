@@ -0,0 +1,80 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AuditAction is what happened in an AuditEntry
+type AuditAction string
+
+const (
+	// Sync events, always belong to a vault
+	AuditActionUpload   AuditAction = "upload"
+	AuditActionDelete   AuditAction = "delete"
+	AuditActionRestore  AuditAction = "restore"
+	AuditActionConflict AuditAction = "conflict"
+	AuditActionRename   AuditAction = "rename"
+
+	// Login events. Logins are recorded when a client connects to a vault, failed ones on every failed request
+	AuditActionLogin       AuditAction = "login"
+	AuditActionLoginFailed AuditAction = "loginFailed"
+
+	// Account events, do not belong to a vault
+	AuditActionUserCreated     AuditAction = "userCreated"
+	AuditActionUserUpdated     AuditAction = "userUpdated"
+	AuditActionUserDeleted     AuditAction = "userDeleted"
+	AuditActionPasswordChanged AuditAction = "passwordChanged"
+	AuditActionPasswordReset   AuditAction = "passwordReset"
+)
+
+// Actor is whoever made a change: the user, the device they used and, if the change came from a synced client, its session
+type Actor struct {
+	UserId     string
+	Username   string
+	Device     string
+	SessionId  string
+	RemoteAddr string
+}
+
+// AuditEntry is a single record in the audit log. Entries are never changed or removed once recorded
+type AuditEntry struct {
+	ID primitive.ObjectID `json:"_id" bson:"_id"`
+	// Time is when it happened, in unix milliseconds
+	Time   int64       `json:"time" bson:"time"`
+	Action AuditAction `json:"action" bson:"action"`
+
+	UserId     string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username   string `json:"username,omitempty" bson:"username,omitempty"`
+	Device     string `json:"device,omitempty" bson:"device,omitempty"`
+	SessionId  string `json:"session_id,omitempty" bson:"session_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty" bson:"remote_addr,omitempty"`
+
+	// VaultId is empty for account events
+	VaultId      string `json:"vault_id,omitempty" bson:"vault_id,omitempty"`
+	Path         string `json:"path,omitempty" bson:"path,omitempty"`
+	SHA256Before string `json:"sha256_before,omitempty" bson:"sha256_before,omitempty"`
+	SHA256After  string `json:"sha256_after,omitempty" bson:"sha256_after,omitempty"`
+	// Details is a human readable description of anything else worth knowing, like what a user was renamed to
+	Details string `json:"details,omitempty" bson:"details,omitempty"`
+}
+
+// Entry returns a new AuditEntry for the given action, made by the actor
+func (a Actor) Entry(action AuditAction) AuditEntry {
+	return AuditEntry{
+		Action:     action,
+		UserId:     a.UserId,
+		Username:   a.Username,
+		Device:     a.Device,
+		SessionId:  a.SessionId,
+		RemoteAddr: a.RemoteAddr,
+	}
+}
+
+// ActorOf returns the Actor for changes made by the given user
+func ActorOf(user User, device string, sessionId string, remoteAddr string) Actor {
+	return Actor{
+		UserId:     user.ID.Hex(),
+		Username:   user.Username,
+		Device:     device,
+		SessionId:  sessionId,
+		RemoteAddr: remoteAddr,
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestActorEntry(t *testing.T) {
+	user := User{ID: primitive.NewObjectID(), Username: "test"}
+	actor := ActorOf(user, "gobi-client", "session", "127.0.0.1")
+
+	entry := actor.Entry(AuditActionUpload)
+
+	want := AuditEntry{
+		Action:     AuditActionUpload,
+		UserId:     user.ID.Hex(),
+		Username:   "test",
+		Device:     "gobi-client",
+		SessionId:  "session",
+		RemoteAddr: "127.0.0.1",
+	}
+
+	if entry != want {
+		t.Errorf("Entry() = %+v, want %+v", entry, want)
+	}
+}
@@ -1,15 +1,104 @@
 package models
 
+import "os"
+
+// ItemKind is the type of an item in a vault
+type ItemKind string
+
+const (
+	ItemKindFile    ItemKind = "file"
+	ItemKindDir     ItemKind = "dir"
+	ItemKindSymlink ItemKind = "symlink"
+)
+
+const (
+	// DefaultFileMode is used for files that were stored without permission bits
+	DefaultFileMode uint32 = 0o644
+	// DefaultDirMode is used for directories that were stored without permission bits
+	DefaultDirMode uint32 = 0o755
+)
+
+// Valid returns true if the kind is one of the known kinds. An empty kind is a file
+func (k ItemKind) Valid() bool {
+	switch k {
+	case "", ItemKindFile, ItemKindDir, ItemKindSymlink:
+		return true
+	default:
+		return false
+	}
+}
+
 type Item struct {
 	// ID primitive.ObjectID `json:"_id" form:"id" bson:"_id"`
-	// OwnerId is the ObjectID of the owner user
-	OwnerId string `json:"owner_id" form:"owner_id" binding:"required"`
+	// OwnerId is the ObjectID of the user that owns the vault the item is in
+	OwnerId string `json:"owner_id" form:"owner_id" binding:"required" bson:"owner_id"`
+	// VaultId is the ObjectID of the vault the item is in
+	VaultId string `json:"vault_id" form:"vault_id" bson:"vault_id"`
 	// ServerPath is the relative to the user vault file path
-	ServerPath string `json:"server_path" form:"server_path" binding:"required"`
+	ServerPath string `json:"server_path" form:"server_path" binding:"required" bson:"server_path"`
+	// NormalizedPath is the case-folded, NFC normalized ServerPath. Items with the same one collide on some filesystems
+	NormalizedPath string `json:"normalized_path,omitempty" form:"normalized_path" bson:"normalized_path,omitempty"`
 	// ServerMTime contains the last time the file has had a change
-	ServerMTime int64 `json:"server_m_time" form:"server_m_time" binding:"required"`
-	// SHA256 contains the server caluclated SHA256 of the file
-	SHA256 string `json:"sha256" form:"sha256" binding:"required"`
+	ServerMTime int64 `json:"server_m_time" form:"server_m_time" binding:"required" bson:"server_m_time"`
+	// SHA256 contains the server caluclated SHA256 of the file. For symlinks, it is the SHA256 of the target
+	SHA256 string `json:"sha256" form:"sha256" binding:"required" bson:"sha256"`
 	// Size contains the bytes size of the file.
-	Size int `json:"size" form:"size" binding:"required"`
+	Size int `json:"size" form:"size" binding:"required" bson:"size"`
+	// Deleted marks the item as a tombstone. The file no longer exists, but the deletion still needs to be synced
+	Deleted bool `json:"deleted" form:"deleted" bson:"deleted"`
+	// Kind is the type of the item. Items stored before kinds were introduced have none and are files
+	Kind ItemKind `json:"kind,omitempty" form:"kind" bson:"kind,omitempty"`
+	// Mode contains the permission bits of the item. Zero means the defaults are used
+	Mode uint32 `json:"mode,omitempty" form:"mode" bson:"mode,omitempty"`
+	// LinkTarget is the target of a symlink, relative to the directory of the symlink and using forward slashes
+	LinkTarget string `json:"link_target,omitempty" form:"link_target" bson:"link_target,omitempty"`
+	// Sequence is the sequence number of the last event in the vault's event log that changed the item
+	Sequence int64 `json:"sequence,omitempty" form:"sequence" bson:"sequence,omitempty"`
+}
+
+// IsFile returns true if the item is a regular file
+func (i Item) IsFile() bool {
+	return i.Kind == "" || i.Kind == ItemKindFile
+}
+
+// IsDir returns true if the item is a directory
+func (i Item) IsDir() bool {
+	return i.Kind == ItemKindDir
+}
+
+// IsSymlink returns true if the item is a symbolic link
+func (i Item) IsSymlink() bool {
+	return i.Kind == ItemKindSymlink
+}
+
+// Permissions returns the permission bits of the item, falling back to the defaults if none were recorded
+func (i Item) Permissions() os.FileMode {
+	if i.Mode != 0 {
+		return os.FileMode(i.Mode).Perm()
+	}
+
+	if i.IsDir() {
+		return os.FileMode(DefaultDirMode)
+	}
+
+	return os.FileMode(DefaultFileMode)
+}
+
+// SameContent returns true if both items are of the same kind and have the same contents and permissions.
+// Permissions are only compared if both items have them, so items stored before modes were recorded still match
+func (i Item) SameContent(other Item) bool {
+	// Tombstones have no contents, so any two of them match
+	if i.Deleted || other.Deleted {
+		return i.Deleted == other.Deleted
+	}
+
+	if i.IsFile() != other.IsFile() || i.IsDir() != other.IsDir() {
+		return false
+	}
+
+	if i.SHA256 != other.SHA256 || i.LinkTarget != other.LinkTarget {
+		return false
+	}
+
+	return i.Mode == 0 || other.Mode == 0 || i.Permissions() == other.Permissions()
 }
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"os"
+	"testing"
+)
+
+func TestItemPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		item Item
+		want os.FileMode
+	}{
+		{"file without mode", Item{}, 0o644},
+		{"dir without mode", Item{Kind: ItemKindDir}, 0o755},
+		{"executable file", Item{Kind: ItemKindFile, Mode: 0o755}, 0o755},
+		{"type bits are dropped", Item{Mode: uint32(os.ModeSetuid) | 0o700}, 0o700},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.item.Permissions(); got != tt.want {
+				t.Errorf("Permissions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItemSameContent(t *testing.T) {
+	file := Item{ServerPath: "script.sh", SHA256: "abc", Mode: 0o644}
+
+	tests := []struct {
+		name  string
+		other Item
+		want  bool
+	}{
+		{"identical", file, true},
+		{"legacy item without kind or mode", Item{SHA256: "abc"}, true},
+		{"different content", Item{SHA256: "def", Mode: 0o644}, false},
+		{"executable bit changed", Item{SHA256: "abc", Mode: 0o755}, false},
+		{"deleted", Item{SHA256: "abc", Mode: 0o644, Deleted: true}, false},
+		{"symlink with same hash", Item{Kind: ItemKindSymlink, SHA256: "abc", LinkTarget: "other.sh"}, false},
+		{"directory", Item{Kind: ItemKindDir, Mode: 0o644}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := file.SameContent(tt.other); got != tt.want {
+				t.Errorf("SameContent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItemSameContentTombstones(t *testing.T) {
+	deletedDir := Item{Kind: ItemKindDir, Deleted: true}
+	deleted := Item{ServerPath: "dir", Deleted: true}
+
+	if !deletedDir.SameContent(deleted) {
+		t.Errorf("SameContent() = false for two tombstones, want true")
+	}
+}
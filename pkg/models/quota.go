@@ -0,0 +1,56 @@
+package models
+
+// Usage contains the bytes used and the quota of a user or a vault
+type Usage struct {
+	UsedBytes int64 `json:"used_bytes"`
+	// QuotaBytes is the maximum number of bytes that can be used. Zero means there is no limit
+	QuotaBytes int64 `json:"quota_bytes"`
+}
+
+// Allows returns true if the given number of bytes can be added without going over the quota
+func (u Usage) Allows(bytes int64) bool {
+	return u.QuotaBytes == 0 || u.UsedBytes+bytes <= u.QuotaBytes
+}
+
+// Remaining returns the number of bytes that can still be used, or -1 if there is no limit
+func (u Usage) Remaining() int64 {
+	if u.QuotaBytes == 0 {
+		return -1
+	}
+
+	if u.UsedBytes >= u.QuotaBytes {
+		return 0
+	}
+
+	return u.QuotaBytes - u.UsedBytes
+}
+
+// Ratio returns how much of the quota is used, between 0 and 1. Returns 0 if there is no limit
+func (u Usage) Ratio() float64 {
+	if u.QuotaBytes == 0 {
+		return 0
+	}
+
+	return float64(u.UsedBytes) / float64(u.QuotaBytes)
+}
+
+// QuotaUsage contains the usage of a vault and of the user that owns it. Uploads count against both
+type QuotaUsage struct {
+	User  Usage `json:"user"`
+	Vault Usage `json:"vault"`
+}
+
+// Allows returns true if the given number of bytes can be added without going over either quota
+func (q QuotaUsage) Allows(bytes int64) bool {
+	return q.User.Allows(bytes) && q.Vault.Allows(bytes)
+}
+
+// Remaining returns the number of bytes that can still be uploaded to the vault, or -1 if there is no limit
+func (q QuotaUsage) Remaining() int64 {
+	user, vault := q.User.Remaining(), q.Vault.Remaining()
+	if user == -1 || (vault != -1 && vault < user) {
+		return vault
+	}
+
+	return user
+}
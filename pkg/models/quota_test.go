@@ -0,0 +1,48 @@
+package models
+
+import "testing"
+
+func TestUsageAllows(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage Usage
+		bytes int64
+		want  bool
+	}{
+		{"unlimited", Usage{UsedBytes: 1 << 40}, 1 << 40, true},
+		{"below quota", Usage{UsedBytes: 10, QuotaBytes: 100}, 50, true},
+		{"exactly at quota", Usage{UsedBytes: 10, QuotaBytes: 100}, 90, true},
+		{"over quota", Usage{UsedBytes: 10, QuotaBytes: 100}, 91, false},
+		{"freeing space", Usage{UsedBytes: 150, QuotaBytes: 100}, -60, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.usage.Allows(tt.bytes); got != tt.want {
+				t.Errorf("Allows(%d) = %v, want %v", tt.bytes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaUsageRemaining(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage QuotaUsage
+		want  int64
+	}{
+		{"unlimited", QuotaUsage{}, -1},
+		{"user limited", QuotaUsage{User: Usage{UsedBytes: 10, QuotaBytes: 100}}, 90},
+		{"vault limited", QuotaUsage{Vault: Usage{UsedBytes: 10, QuotaBytes: 50}}, 40},
+		{"smallest wins", QuotaUsage{User: Usage{UsedBytes: 10, QuotaBytes: 100}, Vault: Usage{UsedBytes: 10, QuotaBytes: 50}}, 40},
+		{"over quota", QuotaUsage{User: Usage{UsedBytes: 200, QuotaBytes: 100}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.usage.Remaining(); got != tt.want {
+				t.Errorf("Remaining() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,12 +6,68 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// UserRole is the role of a user on the server. Not to be confused with the Role a user has in a vault
+type UserRole string
+
+const (
+	UserRoleUser  UserRole = "user"
+	UserRoleAdmin UserRole = "admin"
+)
+
+// Valid returns true if the role is one of the known roles
+func (r UserRole) Valid() bool {
+	return r == UserRoleUser || r == UserRoleAdmin
+}
+
 // User model.
-// Contains basic information about the user
+// Contains basic information about the user. The password hash is only stored in the database and never encoded to JSON.
+// Never bind requests to a User or respond with one, use a dedicated request type and Profile instead
 type User struct {
-	ID       primitive.ObjectID `json:"_id" form:"id" bson:"_id"`
-	Username string             `json:"username" form:"username" binding:"required" bson:"username"`
-	Password string             `json:"password" form:"password" binding:"required" bson:"password"`
+	ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Username string             `json:"username" bson:"username"`
+	Password string             `json:"-" bson:"password"`
+	// Role is the role of the user on the server. Users stored before roles were introduced have none and are users
+	Role UserRole `json:"role,omitempty" form:"role" bson:"role,omitempty"`
+	// Disabled users cannot authenticate
+	Disabled bool `json:"disabled" form:"disabled" bson:"disabled"`
+	// Deleting is set when the user was deleted, but their data is still being removed. They cannot authenticate
+	Deleting bool `json:"deleting,omitempty" bson:"deleting,omitempty"`
+	// CreatedAt is when the user was created, in unix seconds. Users stored before timestamps were introduced have none
+	CreatedAt int64 `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	// UpdatedAt is when the user was last changed, in unix seconds
+	UpdatedAt int64 `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// Profile is the public information about a user. It is safe to send to clients
+type Profile struct {
+	ID        primitive.ObjectID `json:"_id"`
+	Username  string             `json:"username"`
+	Role      UserRole           `json:"role"`
+	Disabled  bool               `json:"disabled"`
+	CreatedAt int64              `json:"created_at,omitempty"`
+	UpdatedAt int64              `json:"updated_at,omitempty"`
+}
+
+// Profile returns the public information about the user, without the password hash
+func (u User) Profile() Profile {
+	role := u.Role
+	if role == "" {
+		role = UserRoleUser
+	}
+
+	return Profile{
+		ID:        u.ID,
+		Username:  u.Username,
+		Role:      role,
+		Disabled:  u.Disabled,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+// IsAdmin returns true if the user can operate the server
+func (u User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
 }
 
 // ValidateUsername checks if the username is valid. A valid username contains only
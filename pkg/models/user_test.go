@@ -1,6 +1,10 @@
 package models
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestValidateUsername(t *testing.T) {
 	tests := []struct {
@@ -28,3 +32,78 @@ func TestValidateUsername(t *testing.T) {
 		})
 	}
 }
+
+func TestUserIsAdmin(t *testing.T) {
+	tests := []struct {
+		role  UserRole
+		valid bool
+		admin bool
+	}{
+		{UserRoleUser, true, false},
+		{UserRoleAdmin, true, true},
+		{"", false, false},
+		{"root", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			if got := tt.role.Valid(); got != tt.valid {
+				t.Errorf("UserRole(%q).Valid() = %v, want %v", tt.role, got, tt.valid)
+			}
+
+			if got := (User{Role: tt.role}).IsAdmin(); got != tt.admin {
+				t.Errorf("User{Role: %q}.IsAdmin() = %v, want %v", tt.role, got, tt.admin)
+			}
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	tests := []struct {
+		user User
+		want UserRole
+	}{
+		{User{Username: "legacy", Password: "hash"}, UserRoleUser},
+		{User{Username: "admin", Password: "hash", Role: UserRoleAdmin}, UserRoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user.Username, func(t *testing.T) {
+			profile := tt.user.Profile()
+			if profile.Role != tt.want {
+				t.Errorf("Profile().Role = %q, want %q", profile.Role, tt.want)
+			}
+
+			encoded, err := json.Marshal(profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(string(encoded), tt.user.Password) {
+				t.Errorf("Profile() leaks the password hash: %s", encoded)
+			}
+		})
+	}
+}
+
+func TestUserJSONOmitsPassword(t *testing.T) {
+	user := User{Username: "test", Password: "secret-hash"}
+
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(encoded), user.Password) {
+		t.Errorf("User leaks the password hash: %s", encoded)
+	}
+
+	var decoded User
+	if err := json.Unmarshal([]byte(`{"username":"test","password":"injected"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Password != "" {
+		t.Errorf("decoded Password = %q, want it to never be read from JSON", decoded.Password)
+	}
+}
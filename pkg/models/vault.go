@@ -0,0 +1,63 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Role is the access level a user has in a vault
+type Role string
+
+const (
+	// RoleOwner can read, write and manage the members of the vault
+	RoleOwner Role = "owner"
+	// RoleEditor can read and write items in the vault
+	RoleEditor Role = "editor"
+	// RoleReadOnly can only read items from the vault
+	RoleReadOnly Role = "read-only"
+)
+
+// Valid returns true if the role is one of the known roles
+func (r Role) Valid() bool {
+	switch r {
+	case RoleOwner, RoleEditor, RoleReadOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanWrite returns true if the role allows modifying items in the vault
+func (r Role) CanWrite() bool {
+	return r == RoleOwner || r == RoleEditor
+}
+
+// CanManage returns true if the role allows managing the members of the vault
+func (r Role) CanManage() bool {
+	return r == RoleOwner
+}
+
+// VaultMember is a user that has access to a vault with the given role
+type VaultMember struct {
+	UserId   string `json:"user_id" bson:"user_id"`
+	Username string `json:"username" form:"username" binding:"required" bson:"username"`
+	Role     Role   `json:"role" form:"role" binding:"required" bson:"role"`
+}
+
+// Vault model.
+// A vault is a collection of items that can be shared between multiple users
+// Vaults are stored by ID, so two users can have vaults with the same name without them colliding
+type Vault struct {
+	ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Name    string             `json:"name" form:"name" binding:"required" bson:"name"`
+	Members []VaultMember      `json:"members" bson:"members"`
+}
+
+// RoleOf returns the role the given user has in the vault.
+// If the user is not a member, false is returned
+func (v Vault) RoleOf(userId string) (Role, bool) {
+	for _, member := range v.Members {
+		if member.UserId == userId {
+			return member.Role, true
+		}
+	}
+
+	return "", false
+}
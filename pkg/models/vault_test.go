@@ -0,0 +1,54 @@
+package models
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role      Role
+		valid     bool
+		canWrite  bool
+		canManage bool
+	}{
+		{RoleOwner, true, true, true},
+		{RoleEditor, true, true, false},
+		{RoleReadOnly, true, false, false},
+		{Role("admin"), false, false, false},
+		{Role(""), false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			if got := tt.role.Valid(); got != tt.valid {
+				t.Errorf("Valid() = %v, want %v", got, tt.valid)
+			}
+			if got := tt.role.CanWrite(); got != tt.canWrite {
+				t.Errorf("CanWrite() = %v, want %v", got, tt.canWrite)
+			}
+			if got := tt.role.CanManage(); got != tt.canManage {
+				t.Errorf("CanManage() = %v, want %v", got, tt.canManage)
+			}
+		})
+	}
+}
+
+func TestVaultRoleOf(t *testing.T) {
+	vault := Vault{
+		Name: "notes",
+		Members: []VaultMember{
+			{UserId: "1", Username: "alice", Role: RoleOwner},
+			{UserId: "2", Username: "bob", Role: RoleReadOnly},
+		},
+	}
+
+	if role, ok := vault.RoleOf("1"); !ok || role != RoleOwner {
+		t.Errorf("RoleOf(1) = %v, %v, want %v, true", role, ok, RoleOwner)
+	}
+
+	if role, ok := vault.RoleOf("2"); !ok || role != RoleReadOnly {
+		t.Errorf("RoleOf(2) = %v, %v, want %v, true", role, ok, RoleReadOnly)
+	}
+
+	if _, ok := vault.RoleOf("3"); ok {
+		t.Errorf("RoleOf(3) should not be a member")
+	}
+}
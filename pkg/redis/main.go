@@ -2,10 +2,12 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -59,6 +61,28 @@ func Expire(key string, expiration time.Duration) error {
 	return rdb.Expire(ctx, key, expiration).Err()
 }
 
+// Incr will increment the integer stored at the key by one and return the new value
+// If the key does not exist, it is set to 0 before incrementing
+func Incr(key string) (int64, error) {
+	return rdb.Incr(ctx, key).Result()
+}
+
+// IncrBy will increment the integer stored at the key by the given value and return the new value
+// If the key does not exist, it is set to 0 before incrementing
+func IncrBy(key string, value int64) (int64, error) {
+	return rdb.IncrBy(ctx, key, value).Result()
+}
+
+// DecrBy will decrement the integer stored at the key by the given value and return the new value
+func DecrBy(key string, value int64) (int64, error) {
+	return rdb.DecrBy(ctx, key, value).Result()
+}
+
+// TTL returns how long until the key expires. Returns a negative duration if the key does not exist or never expires
+func TTL(key string) (time.Duration, error) {
+	return rdb.TTL(ctx, key).Result()
+}
+
 func Exists(key string) (bool, error) {
 	result, err := rdb.Exists(ctx, key).Result()
 	if err != nil {
@@ -72,6 +96,21 @@ func Keys(pattern string) ([]string, error) {
 	return rdb.Keys(ctx, pattern).Result()
 }
 
+// SAdd will add the members to the set stored at the key, creating it if needed
+func SAdd(key string, members ...interface{}) error {
+	return rdb.SAdd(ctx, key, members...).Err()
+}
+
+// SRem will remove the members from the set stored at the key
+func SRem(key string, members ...interface{}) error {
+	return rdb.SRem(ctx, key, members...).Err()
+}
+
+// SMembers returns all members of the set stored at the key. Returns an empty slice if the key does not exist
+func SMembers(key string) ([]string, error) {
+	return rdb.SMembers(ctx, key).Result()
+}
+
 func FlushAll() error {
 	return rdb.FlushAll(ctx).Err()
 }
@@ -84,14 +123,58 @@ func Subscribe(channels ...string) *redis.PubSub {
 	return rdb.Subscribe(ctx, channels...)
 }
 
-func Lock(key string, expiration time.Duration) (bool, error) {
-	return rdb.SetNX(ctx, key, true, expiration).Result()
+// ErrLockLost is returned when renewing a lock that expired or was taken over by somebody else
+var ErrLockLost = errors.New("lock is no longer held")
+
+// unlockScript deletes the lock only if it still holds the token of the caller
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// renewScript extends the lock only if it still holds the token of the caller
+var renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock will try to take the lock at the key until it expires. Returns the token of the lock, which is needed to unlock
+// and renew it, or an empty string if somebody else holds the lock
+func Lock(key string, expiration time.Duration) (string, error) {
+	token := uuid.New().String()
+
+	locked, err := rdb.SetNX(ctx, key, token, expiration).Result()
+	if err != nil || !locked {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Renew will extend the lock at the key. Returns ErrLockLost if the lock expired and somebody else may have taken it
+func Renew(key string, token string, expiration time.Duration) error {
+	renewed, err := renewScript.Run(ctx, rdb, []string{key}, token, expiration.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+
+	if renewed == 0 {
+		return ErrLockLost
+	}
+
+	return nil
 }
 
-func Renew(key string, expiration time.Duration) error {
-	return Expire(key, expiration)
+// Unlock will release the lock at the key, unless it expired and is held by somebody else by now
+func Unlock(key string, token string) error {
+	return unlockScript.Run(ctx, rdb, []string{key}, token).Err()
 }
 
-func Unlock(key string) error {
-	return Del(key)
+// Close closes the connection to redis. Nothing can be done with redis after that
+func Close() error {
+	return rdb.Close()
 }
@@ -0,0 +1,120 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultWriteWait is how long a single write may take, before the peer is considered gone
+const defaultWriteWait = 10 * time.Second
+
+// Heartbeat configures how peers that went silent are detected, like sleeping laptops that left a half-open connection behind
+type Heartbeat struct {
+	// PingInterval is how often a ping is sent. Zero disables pings and read deadlines
+	PingInterval time.Duration
+	// PongWait is how long we wait to hear anything from the peer, before closing the connection
+	PongWait time.Duration
+	// WriteWait is how long a single write may take. Zero means writes never time out
+	WriteWait time.Duration
+}
+
+// NewHeartbeat returns a Heartbeat that pings at the given interval and gives up on the peer after missing two pongs
+func NewHeartbeat(pingInterval time.Duration) Heartbeat {
+	return Heartbeat{
+		PingInterval: pingInterval,
+		PongWait:     2*pingInterval + pingInterval/2,
+		WriteWait:    defaultWriteWait,
+	}
+}
+
+// IsTimeout returns true if the error happened because the peer did not respond in time
+func IsTimeout(err error) bool {
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
+
+// StartHeartbeat will ping the peer at the configured interval and refresh the read deadline whenever it answers.
+// If the peer stays silent, the read deadline passes and the read loop stops with a timeout error.
+// Call the returned function once the read loop stopped
+func (c *WebsocketClient) StartHeartbeat(heartbeat Heartbeat) (stop func()) {
+	c.heartbeat = heartbeat
+
+	if heartbeat.PingInterval <= 0 {
+		return func() {}
+	}
+
+	c.ExtendReadDeadline()
+
+	c.Conn.SetPongHandler(func(string) error {
+		c.ExtendReadDeadline()
+		return nil
+	})
+
+	c.Conn.SetPingHandler(func(message string) error {
+		c.ExtendReadDeadline()
+
+		err := c.Conn.WriteControl(websocket.PongMessage, []byte(message), c.writeDeadline())
+		if err != nil && !errors.Is(err, websocket.ErrCloseSent) && !IsTimeout(err) {
+			return err
+		}
+
+		return nil
+	})
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(heartbeat.PingInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.Conn.WriteControl(websocket.PingMessage, nil, c.writeDeadline()); err != nil {
+					slog.Warn("Error sending ping, closing the connection", "error", err)
+					_ = c.Conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// SendPong answers a ping. Control messages can be written next to the writer goroutine, so this is safe to call from any goroutine
+func (c *WebsocketClient) SendPong() error {
+	if err := c.Conn.WriteControl(websocket.PongMessage, []byte(""), c.writeDeadline()); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	return nil
+}
+
+// ExtendReadDeadline gives the peer another PongWait to send something. Call this before every read, as control messages
+// are only handled while reading and processing a message can take longer than that
+func (c *WebsocketClient) ExtendReadDeadline() {
+	if c.heartbeat.PingInterval <= 0 {
+		return
+	}
+
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.heartbeat.PongWait))
+}
+
+// writeDeadline returns the deadline for a write starting now, or no deadline if writes never time out
+func (c *WebsocketClient) writeDeadline() time.Time {
+	if c.heartbeat.WriteWait <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(c.heartbeat.WriteWait)
+}
@@ -0,0 +1,84 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Michaelpalacce/gobi/pkg/messages"
+	"github.com/google/uuid"
+)
+
+// ErrRequestTimeout is returned when the peer did not reply to a request in time
+var ErrRequestTimeout = errors.New("timed out waiting for a reply")
+
+// Request sends the message with a new ID and waits up to timeout for the peer to reply to it.
+// If the reply is an ack with an error code, the reply is returned together with a *messages.AckError
+func (c *WebsocketClient) Request(message messages.WebsocketRequest, timeout time.Duration) (*messages.WebsocketMessage, error) {
+	message.ID = uuid.New().String()
+	reply := make(chan messages.WebsocketMessage, 1)
+
+	c.pendingMutex.Lock()
+	if c.pending == nil {
+		c.pending = make(map[string]chan messages.WebsocketMessage)
+	}
+	c.pending[message.ID] = reply
+	c.pendingMutex.Unlock()
+
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, message.ID)
+		c.pendingMutex.Unlock()
+	}()
+
+	if err := c.SendMessage(message); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-reply:
+		if response.Type != messages.AckType {
+			return &response, nil
+		}
+
+		var ackPayload messages.AckPayload
+		if err := response.Decode(&ackPayload); err != nil {
+			return &response, fmt.Errorf("error while unmarshaling ack: %w", err)
+		}
+
+		return &response, ackPayload.Err()
+	case <-c.closing:
+		return nil, ErrClosed
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: %s", ErrRequestTimeout, message.Type)
+	}
+}
+
+// Resolve hands a reply to the request waiting for it. Returns true if the message is a reply and needs no more processing.
+// Replies that arrive after their request timed out are dropped
+func (c *WebsocketClient) Resolve(message messages.WebsocketMessage) bool {
+	if message.ReplyTo == "" {
+		return false
+	}
+
+	c.pendingMutex.Lock()
+	reply, ok := c.pending[message.ReplyTo]
+	c.pendingMutex.Unlock()
+
+	if !ok {
+		slog.Debug("Dropping reply to an unknown request", "replyTo", message.ReplyTo, "type", message.Type)
+		return true
+	}
+
+	// Only the first reply counts, the channel has room for exactly one
+	select {
+	case reply <- message:
+	default:
+	}
+
+	return true
+}
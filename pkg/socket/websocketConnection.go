@@ -1,8 +1,11 @@
 package socket
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/client"
 	"github.com/Michaelpalacce/gobi/pkg/messages"
@@ -11,6 +14,22 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// closeTimeout is how long we wait for the queued messages and the close message to be sent
+	closeTimeout = 5 * time.Second
+	// sendQueueSize is how many messages can wait to be written, before senders have to wait for the peer
+	sendQueueSize = 64
+	// sendTimeout is how long a sender waits for room in a full queue, before the peer is considered too slow
+	sendTimeout = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned when sending a message to a closed websocket
+	ErrClosed = errors.New("cannot send a message to closed websocket")
+	// ErrSlowPeer is returned when the peer does not read the messages we send fast enough. The connection is closed
+	ErrSlowPeer = errors.New("peer is not reading messages fast enough")
+)
+
 // WebsocketClient contains the connection as well as metadata for a client
 // Used by both the server and client
 // This is mainly a transport layer connection
@@ -19,36 +38,277 @@ type WebsocketClient struct {
 	StorageDriver storage.Driver
 	Client        client.ClientMetadata
 	User          models.User
+	// Device identifies the device of the client, like its User-Agent. Only set on the server
+	Device string
 
-	closed      bool
 	InitialSync bool
+
+	// Capabilities are the optional features both sides agreed on in the hello
+	Capabilities messages.Capabilities
+
+	// heartbeat is set by StartHeartbeat and also holds the write deadline of every send
+	heartbeat Heartbeat
+
+	// Messages are written by a single writer goroutine, as gorilla/websocket does not allow concurrent writes.
+	// Priority messages are written before anything in the queue. Both are bounded, so senders wait for a slow peer
+	writerOnce sync.Once
+	queue      chan frame
+	priority   chan frame
+	closing    chan struct{}
+	flushed    chan struct{}
+	// senders counts the sends that got past the closed check. The writer keeps reading until they returned, so no message
+	// is queued after the queues were drained
+	senders sync.WaitGroup
+
+	// mutex guards the fields below
+	mutex    sync.Mutex
+	closed   bool
+	writeErr error
+	// codec encodes the messages we send. JSON until another encoding is agreed on in the hello
+	codec messages.Codec
+
+	// pending contains the requests waiting for a reply, keyed by message ID
+	pending      map[string]chan messages.WebsocketMessage
+	pendingMutex sync.Mutex
+}
+
+// frame is an encoded message, waiting to be written
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+// SetCodec changes the encoding of the messages sent from now on. Messages that are already queued keep theirs
+func (c *WebsocketClient) SetCodec(codec messages.Codec) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.codec = codec
+}
+
+// Decode will decode a message received in a frame of the given type. Text frames carry JSON and binary frames CBOR, so
+// messages can be decoded no matter if the peer already switched to the encoding agreed on in the hello
+func Decode(messageType int, data []byte) (messages.WebsocketMessage, error) {
+	if messageType == websocket.BinaryMessage {
+		return messages.CBOR.Unmarshal(data)
+	}
+
+	return messages.JSON.Unmarshal(data)
 }
 
 // Close will gracefully close the connection. If an error ocurrs during closing, it will be ignored.
 // It will set the WebsocketClient as closed and will NOT send a CLose Message if the connection is closed already
+// Messages that are already queued are written first, for up to closeTimeout. Safe to call from any goroutine
 func (c *WebsocketClient) Close(msg string) {
+	c.mutex.Lock()
 	if c.closed {
+		c.mutex.Unlock()
 		return
 	}
 
 	c.closed = true
+	c.mutex.Unlock()
+
+	c.startWriter()
+	close(c.closing)
+
+	select {
+	case <-c.flushed:
+	case <-time.After(closeTimeout):
+		slog.Warn("Timed out writing the queued messages before closing")
+	}
+
 	payload := messages.NewCloseMessage(msg)
 
 	// Close the WebSocket connection gracefully
-	_ = c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, string(payload.Marshal())))
+	_ = c.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, string(payload.Marshal())),
+		time.Now().Add(closeTimeout),
+	)
 }
 
-// sendMessage enforces a uniform style in sending data
+// SendMessage queues the message to be written after the ones queued before it.
+// If the queue is full, it waits for room up to sendTimeout and then closes the connection, as the peer is too slow
 func (c *WebsocketClient) SendMessage(message messages.WebsocketRequest) error {
-	if c.closed {
-		return fmt.Errorf("cannot send a message to closed websocket")
+	c.startWriter()
+
+	return c.enqueue(c.queue, message)
+}
+
+// SendPriorityMessage queues the message to be written before any message sent with SendMessage.
+// Use this for small messages that should not wait for bulk data, like the session or a shutdown notice
+func (c *WebsocketClient) SendPriorityMessage(message messages.WebsocketRequest) error {
+	c.startWriter()
+
+	return c.enqueue(c.priority, message)
+}
+
+// startWriter creates the queues and starts the writer goroutine, the first time it is called
+func (c *WebsocketClient) startWriter() {
+	c.writerOnce.Do(func() {
+		c.queue = make(chan frame, sendQueueSize)
+		c.priority = make(chan frame, sendQueueSize)
+		c.closing = make(chan struct{})
+		c.flushed = make(chan struct{})
+
+		go c.write()
+	})
+}
+
+// enqueue encodes the message and adds it to the given queue, waiting for room up to sendTimeout
+func (c *WebsocketClient) enqueue(queue chan<- frame, message messages.WebsocketRequest) error {
+	c.mutex.Lock()
+	closed, writeErr, codec := c.closed, c.writeErr, c.codec
+	if writeErr == nil && !closed {
+		c.senders.Add(1)
 	}
-	messageBytes := message.Marshal()
-	slog.Debug("Sending message", "message", string(messageBytes))
-	err := c.Conn.WriteMessage(websocket.TextMessage, messageBytes)
+	c.mutex.Unlock()
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if closed {
+		return ErrClosed
+	}
+
+	defer c.senders.Done()
+
+	if codec == nil {
+		codec = messages.JSON
+	}
+
+	data, err := codec.Marshal(message)
 	if err != nil {
+		return fmt.Errorf("error while marshaling %s message: %w", message.Type, err)
+	}
+
+	messageFrame := frame{messageType: websocket.TextMessage, data: data}
+	if codec.Binary() {
+		messageFrame.messageType = websocket.BinaryMessage
+	}
+
+	select {
+	case <-c.closing:
+		return ErrClosed
+	case <-c.flushed:
+		return ErrClosed
+	case queue <- messageFrame:
+		return nil
+	default:
+	}
+
+	slog.Debug("Send queue is full, waiting for the peer", "type", message.Type)
+
+	timer := time.NewTimer(sendTimeout)
+	defer timer.Stop()
+
+	select {
+	case queue <- messageFrame:
+		return nil
+	case <-c.closing:
+		return ErrClosed
+	case <-c.flushed:
+		return ErrClosed
+	case <-timer.C:
+		slog.Warn("Peer is too slow, closing the connection", "type", message.Type)
+		c.fail(ErrSlowPeer)
+
+		return ErrSlowPeer
+	}
+}
+
+// write is the only goroutine that writes messages to the connection. Priority messages go first.
+// Once the connection is closing, the remaining messages are written and flushed is closed
+func (c *WebsocketClient) write() {
+	defer close(c.flushed)
+
+	for {
+		var messageFrame frame
+
+		select {
+		case messageFrame = <-c.priority:
+		default:
+			select {
+			case messageFrame = <-c.priority:
+			case messageFrame = <-c.queue:
+			case <-c.closing:
+				c.drain()
+				return
+			}
+		}
+
+		if err := c.writeMessage(messageFrame); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// drain writes the messages that are still queued and the ones of senders that were already past the closed check.
+// Once all of them returned, whatever is left is written, priority messages first
+func (c *WebsocketClient) drain() {
+	sendersDone := make(chan struct{})
+	go func() {
+		c.senders.Wait()
+		close(sendersDone)
+	}()
+
+	for {
+		var messageFrame frame
+
+		select {
+		case messageFrame = <-c.priority:
+		case messageFrame = <-c.queue:
+		case <-sendersDone:
+			c.writeQueued()
+			return
+		}
+
+		if err := c.writeMessage(messageFrame); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// writeQueued writes the messages that are left in the queues, priority ones first
+func (c *WebsocketClient) writeQueued() {
+	// Nobody sends anymore and only the writer receives from the queues, so this never blocks
+	for _, queue := range []chan frame{c.priority, c.queue} {
+		for len(queue) > 0 {
+			if err := c.writeMessage(<-queue); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// writeMessage writes a single message with the write deadline
+func (c *WebsocketClient) writeMessage(messageFrame frame) error {
+	if messageFrame.messageType == websocket.TextMessage {
+		slog.Debug("Sending message", "message", string(messageFrame.data))
+	} else {
+		slog.Debug("Sending binary message", "bytes", len(messageFrame.data))
+	}
+
+	_ = c.Conn.SetWriteDeadline(c.writeDeadline())
+	if err := c.Conn.WriteMessage(messageFrame.messageType, messageFrame.data); err != nil {
 		return fmt.Errorf("error sending message: %w", err)
 	}
 
 	return nil
 }
+
+// fail records why messages can no longer be sent and closes the connection, so the read loop stops as well
+func (c *WebsocketClient) fail(err error) {
+	c.mutex.Lock()
+	if c.writeErr == nil {
+		c.writeErr = err
+	}
+	c.mutex.Unlock()
+
+	_ = c.Conn.Close()
+}
@@ -6,16 +6,14 @@ import (
 	"github.com/Michaelpalacce/gobi/pkg/models"
 )
 
-// Event holds information about a file operation.
-// Events could be deletes,updates,creations
-type Event struct{}
-
 // Driver interface holds the structure that all storage drivers must adhere to
 // Storage Drivers are responsible for storing what needs to be pushed/pulled and doing requests to sync what is needed
 // Storage Drivers are also responsible for handling the actual file operations
 // Conflicts are files changed on both the server and the client
 type Driver interface {
-	Enqueue(items []models.Item)
+	Enqueue(items []models.Item, known VersionLookup)
+
+	Requeue(items []models.Item)
 
 	HasItemsToProcess(conflictMode bool) bool
 
@@ -29,17 +27,36 @@ type Driver interface {
 
 	GetReader(i models.Item) (io.ReadCloser, error)
 
-	GetWriter(i models.Item) (io.WriteCloser, error)
+	GetWriter(i models.Item) (ItemWriter, error)
+
+	Apply(i models.Item) error
 
 	Exists(i models.Item) bool
 
+	Stat(i models.Item) (models.Item, error)
+
 	Touch(i models.Item) error
 
+	Delete(i models.Item) error
+
+	Rename(from models.Item, to models.Item) error
+
 	CalculateSHA256(i models.Item) string
 
-	WatchVault(vaultName string, changeChan chan<- *models.Item) error
+	WatchVault(vaultName string, changeChan chan<- *models.Item, done <-chan struct{}) error
 }
 
+// ItemWriter writes the contents of an item. Nothing is replaced until Commit is called, so check the contents before
+// committing them. Close must always be called and discards the contents if they were not committed
+type ItemWriter interface {
+	io.WriteCloser
+
+	Commit() error
+}
+
+// VersionLookup returns the version of the item at the given path that was last synced with the server, if there is one
+type VersionLookup func(path string) (models.Item, bool)
+
 const (
 	ConflictModeNo  bool = false
 	ConflictModeYes bool = true
@@ -1,3 +1,112 @@
 package storage
 
-// Take a look here: https://github.com/EventStore/EventStore-Client-Go/blob/master/samples/quickstart.go
+import (
+	"sort"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+// EventType is the kind of change an Event records
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+	EventRename EventType = "rename"
+)
+
+// Event holds information about a file operation.
+// Every change to a vault is appended to its event log with the next sequence number, so clients can ask for everything
+// that happened after the last event they saw, instead of relying on timestamps of machines with different clocks
+type Event struct {
+	VaultId string `json:"vault_id" bson:"vault_id"`
+	// Sequence is increasing per vault. Sequences can have gaps, but are never reused
+	Sequence int64     `json:"sequence" bson:"sequence"`
+	Type     EventType `json:"type" bson:"type"`
+	// Item is the metadata of the item after the change. For deletes it is the tombstone, for renames the item at its new path
+	Item models.Item `json:"item" bson:"item"`
+	// From is the path the item had before it was renamed
+	From string `json:"from,omitempty" bson:"from,omitempty"`
+	// Time is when the event was recorded, in unix milliseconds. Only informative, never used for ordering
+	Time int64 `json:"time" bson:"time"`
+}
+
+// EventStore holds the event log of every vault
+type EventStore interface {
+	// Append will record the change with the next sequence number of the vault and return the recorded event
+	Append(event Event) (*Event, error)
+
+	// EventsSince returns up to limit events of the vault with a sequence bigger than the given one, in order.
+	// Fewer events than the limit means there are no more
+	EventsSince(vaultId string, sequence int64, limit int64) ([]Event, error)
+
+	// LastSequence returns the sequence of the last event of the vault, or 0 if there are none
+	LastSequence(vaultId string) (int64, error)
+}
+
+// Replay squashes the events into the latest state of every item they touched, so each item is sent only once:
+//   - created and then modified is created with the latest changes
+//   - modified and then deleted is deleted
+//   - deleted and then created is created
+//   - created and then deleted is skipped, the item never existed as far as the client knows
+//
+// A rename deletes the item at its old path and creates it at the new one. Items are returned sorted by sequence
+func Replay(events []Event) []models.Item {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Sequence < events[j].Sequence
+	})
+
+	latest := make(map[string]models.Item)
+	// createdInRange contains the paths whose first event was a creation, so the client cannot know of them yet
+	createdInRange := make(map[string]bool)
+
+	record := func(item models.Item, created bool) {
+		if _, seen := latest[item.ServerPath]; !seen {
+			createdInRange[item.ServerPath] = created
+		}
+
+		latest[item.ServerPath] = item
+	}
+
+	for _, event := range events {
+		item := event.Item
+		item.Sequence = event.Sequence
+
+		switch event.Type {
+		case EventRename:
+			tombstone := item
+			tombstone.ServerPath = event.From
+			tombstone.NormalizedPath = ""
+			tombstone.Deleted = true
+			tombstone.SHA256 = ""
+			tombstone.Size = 0
+
+			record(tombstone, false)
+			record(item, true)
+		case EventCreate:
+			record(item, true)
+		default:
+			record(item, false)
+		}
+	}
+
+	items := make([]models.Item, 0, len(latest))
+	for path, item := range latest {
+		if item.Deleted && createdInRange[path] {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Sequence != items[j].Sequence {
+			return items[i].Sequence < items[j].Sequence
+		}
+
+		return items[i].ServerPath < items[j].ServerPath
+	})
+
+	return items
+}
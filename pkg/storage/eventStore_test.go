@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+func TestReplay(t *testing.T) {
+	file := func(path string, sha string) models.Item {
+		return models.Item{ServerPath: path, SHA256: sha}
+	}
+
+	tombstone := func(path string) models.Item {
+		return models.Item{ServerPath: path, Deleted: true}
+	}
+
+	type result struct {
+		path     string
+		deleted  bool
+		sha      string
+		sequence int64
+	}
+
+	tests := []struct {
+		name   string
+		events []Event
+		want   []result
+	}{
+		{
+			name:   "no events",
+			events: []Event{},
+			want:   []result{},
+		},
+		{
+			name: "created and modified",
+			events: []Event{
+				{Sequence: 1, Type: EventCreate, Item: file("a.md", "1")},
+				{Sequence: 2, Type: EventUpdate, Item: file("a.md", "2")},
+			},
+			want: []result{{"a.md", false, "2", 2}},
+		},
+		{
+			name: "modified and deleted",
+			events: []Event{
+				{Sequence: 1, Type: EventUpdate, Item: file("a.md", "2")},
+				{Sequence: 2, Type: EventDelete, Item: tombstone("a.md")},
+			},
+			want: []result{{"a.md", true, "", 2}},
+		},
+		{
+			name: "created and deleted",
+			events: []Event{
+				{Sequence: 1, Type: EventCreate, Item: file("a.md", "1")},
+				{Sequence: 2, Type: EventDelete, Item: tombstone("a.md")},
+			},
+			want: []result{},
+		},
+		{
+			name: "deleted and created",
+			events: []Event{
+				{Sequence: 1, Type: EventDelete, Item: tombstone("a.md")},
+				{Sequence: 2, Type: EventCreate, Item: file("a.md", "1")},
+			},
+			want: []result{{"a.md", false, "1", 2}},
+		},
+		{
+			name: "out of order",
+			events: []Event{
+				{Sequence: 3, Type: EventUpdate, Item: file("a.md", "3")},
+				{Sequence: 2, Type: EventUpdate, Item: file("a.md", "2")},
+				{Sequence: 1, Type: EventUpdate, Item: file("b.md", "1")},
+			},
+			want: []result{{"b.md", false, "1", 1}, {"a.md", false, "3", 3}},
+		},
+		{
+			name: "renamed",
+			events: []Event{
+				{Sequence: 4, Type: EventRename, Item: file("b.md", "1"), From: "a.md"},
+			},
+			want: []result{{"a.md", true, "", 4}, {"b.md", false, "1", 4}},
+		},
+		{
+			name: "created and renamed",
+			events: []Event{
+				{Sequence: 1, Type: EventCreate, Item: file("a.md", "1")},
+				{Sequence: 2, Type: EventRename, Item: file("b.md", "1"), From: "a.md"},
+			},
+			want: []result{{"b.md", false, "1", 2}},
+		},
+		{
+			name: "renamed and deleted",
+			events: []Event{
+				{Sequence: 1, Type: EventRename, Item: file("b.md", "1"), From: "a.md"},
+				{Sequence: 2, Type: EventDelete, Item: tombstone("b.md")},
+			},
+			want: []result{{"a.md", true, "", 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Replay(tt.events)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Replay() returned %d items, want %d: %+v", len(got), len(tt.want), got)
+			}
+
+			for i, want := range tt.want {
+				item := got[i]
+				if item.ServerPath != want.path || item.Deleted != want.deleted || item.SHA256 != want.sha || item.Sequence != want.sequence {
+					t.Errorf("Replay()[%d] = %+v, want %+v", i, item, want)
+				}
+			}
+		})
+	}
+}
@@ -6,17 +6,15 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/Michaelpalacce/gobi/pkg/digest"
+	"github.com/Michaelpalacce/gobi/pkg/ignore"
 	"github.com/Michaelpalacce/gobi/pkg/iops"
 	"github.com/Michaelpalacce/gobi/pkg/models"
 	"github.com/fsnotify/fsnotify"
 )
 
-// @TODO: IMPORTANT! Make sure we don't allow any paths that are outside of the vaults path and that we don't allow any paths that are not in the vault path
-
 var localVaultsLocation = os.Getenv("LOCAL_VAULTS_LOCATION")
 
 // LocalDriver is a storage driver that stores files locally on the disk.
@@ -24,10 +22,16 @@ type LocalDriver struct {
 	VaultPath string
 	queue     []models.Item
 	conflicts []models.Item
+	ignore    *ignore.Matcher
 }
 
 // NewLocalDriver creates a new LocalDriver for the given Vault
+// The vault name must be a valid vault name, so it cannot point outside of the vaults location
 func NewLocalDriver(vaultName string) (*LocalDriver, error) {
+	if err := iops.ValidateVaultName(vaultName); err != nil {
+		return nil, err
+	}
+
 	path, err := iops.JoinSafe(localVaultsLocation, vaultName)
 	slog.Debug("LocalDriver", "path", path)
 
@@ -46,9 +50,120 @@ func NewLocalDriver(vaultName string) (*LocalDriver, error) {
 		return nil, fmt.Errorf("error ensuring that the vault exists: %w", err)
 	}
 
+	err = storageDriver.ReloadIgnore()
+	if err != nil {
+		return nil, fmt.Errorf("error loading ignore rules: %w", err)
+	}
+
 	return storageDriver, nil
 }
 
+// RemoveVault will delete the directory of the given vault with everything in it.
+// Does nothing if the vault does not exist, so it is safe to call more than once
+func RemoveVault(vaultName string) error {
+	if err := iops.ValidateVaultName(vaultName); err != nil {
+		return err
+	}
+
+	path, err := iops.JoinSafe(localVaultsLocation, vaultName)
+	if err != nil {
+		return fmt.Errorf("error getting vault path: %w", err)
+	}
+
+	slog.Info("Removing vault directory", "path", path)
+
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("error removing vault directory: %w", err)
+	}
+
+	return nil
+}
+
+// ListVaults returns the names of all vault directories in the vaults location
+func ListVaults() ([]string, error) {
+	entries, err := os.ReadDir(localVaultsLocation)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing vaults: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// VaultHasContents returns true if the directory of the vault exists and is not empty
+func VaultHasContents(vaultName string) (bool, error) {
+	path, err := iops.JoinSafe(localVaultsLocation, vaultName)
+	if err != nil {
+		return false, fmt.Errorf("error getting vault path: %w", err)
+	}
+
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error reading vault directory: %w", err)
+	}
+
+	return len(entries) > 0, nil
+}
+
+// MoveVault will rename the directory of a vault. If a directory already exists at the new name, it is only replaced
+// if it is empty, so the contents of two vaults are never mixed
+func MoveVault(from string, to string) error {
+	fromPath, err := iops.JoinSafe(localVaultsLocation, from)
+	if err != nil {
+		return fmt.Errorf("error getting vault path: %w", err)
+	}
+
+	toPath, err := iops.JoinSafe(localVaultsLocation, to)
+	if err != nil {
+		return fmt.Errorf("error getting vault path: %w", err)
+	}
+
+	// Fails if the directory is not empty and does nothing if it does not exist
+	if err := os.Remove(toPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot move vault %s to %s, it already has contents: %w", from, to, err)
+	}
+
+	slog.Info("Moving vault directory", "from", fromPath, "to", toPath)
+
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return fmt.Errorf("error moving vault directory: %w", err)
+	}
+
+	return nil
+}
+
+// ReloadIgnore will read the ignore file from the root of the vault again.
+// Call this when the ignore file changes
+func (d *LocalDriver) ReloadIgnore() error {
+	matcher, err := ignore.Load(d.VaultPath)
+	if err != nil {
+		return err
+	}
+
+	d.ignore = matcher
+
+	return nil
+}
+
+// IsIgnored returns true if the given path, relative to the vault, should not be synced
+func (d *LocalDriver) IsIgnored(path string, isDir bool) bool {
+	return d.ignore.Match(path, isDir)
+}
+
 // EnsureVault will make sure that the vault exists on the disk
 func (d *LocalDriver) EnsureVault() error {
 	_, err := os.Stat(d.VaultPath)
@@ -65,23 +180,76 @@ func (d *LocalDriver) EnsureVault() error {
 }
 
 // Enqueue adds the given items array to the queue for later processing.
-// Will not add items that are already in the local storage, based on filePath and SHA256
-func (d *LocalDriver) Enqueue(items []models.Item) {
+// Will not add items that are already in the local storage, based on filePath and SHA256, items that are ignored,
+// items with a path that is not valid or items that collide with a local item whose name only differs in case.
+// Items that were changed locally since the version we last synced, as returned by known, are conflicts.
+// Items older than that version were already superseded and are skipped
+func (d *LocalDriver) Enqueue(items []models.Item, known VersionLookup) {
 	for _, item := range items {
-		if ok := d.checkIfLocalMatch(item); !ok {
-			fileInfo, err := os.Stat(d.getFilePath(item))
-			if err == nil && fileInfo.ModTime().Unix() > item.ServerMTime {
-				d.conflicts = append(d.conflicts, item)
-				continue
-			}
+		if d.IsIgnored(item.ServerPath, item.IsDir()) {
+			continue
+		}
 
+		filePath, err := d.getFilePath(item)
+		if err != nil {
+			slog.Warn("Skipping item with invalid path", "path", item.ServerPath, "error", err)
+			continue
+		}
+
+		// On case-insensitive filesystems, writing the item would overwrite a different local item
+		if localName, collides := d.collidesLocally(filePath); collides {
+			slog.Warn("Skipping item that collides with a local item", "path", item.ServerPath, "local", localName)
+			continue
+		}
+
+		base, hasBase := known(item.ServerPath)
+		if hasBase && base.Sequence > item.Sequence && item.Sequence > 0 {
+			slog.Debug("Skipping item older than the synced version", "path", item.ServerPath, "sequence", item.Sequence, "synced", base.Sequence)
+			continue
+		}
+
+		local, err := d.itemFromPath(filePath)
+		if err != nil {
 			d.queue = append(d.queue, item)
+			continue
+		}
+
+		if local.SameContent(item) {
+			continue
+		}
+
+		if changedLocally(local, item, base, hasBase) {
+			d.conflicts = append(d.conflicts, item)
+			continue
 		}
+
+		d.queue = append(d.queue, item)
 	}
 }
 
+// changedLocally returns true if the local item was changed since the version we last synced with the server.
+// Items synced before versions were recorded have no base, for them the modification times are compared instead
+func changedLocally(local models.Item, remote models.Item, base models.Item, hasBase bool) bool {
+	if !hasBase {
+		return local.ServerMTime > remote.ServerMTime
+	}
+
+	return !local.SameContent(base)
+}
+
+// Requeue adds the given items to the queue without checking the local storage.
+// Use this when the remote version of the items should win, even if they were changed locally
+func (d *LocalDriver) Requeue(items []models.Item) {
+	d.queue = append(d.queue, items...)
+}
+
 func (d *LocalDriver) GetMTime(i models.Item) int64 {
-	fileInfo, err := os.Stat(d.getFilePath(i))
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return 0
+	}
+
+	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return 0
 	}
@@ -106,23 +274,6 @@ func (d *LocalDriver) GetNext(conflictMode bool) *models.Item {
 	return nil
 }
 
-// CheckIfLocalMatch will build up the correct filePath based on the item and check if what we have locally matches.
-// Checks by filePath and SHA256
-func (d *LocalDriver) checkIfLocalMatch(i models.Item) bool {
-	absFilePath := d.getFilePath(i)
-	_, err := os.Stat(absFilePath)
-	if err != nil {
-		return false
-	}
-
-	sha256, err := digest.FileSHA256(absFilePath)
-	if err != nil {
-		return false
-	}
-
-	return sha256 == i.SHA256
-}
-
 // HasItemsToProcess will return true if there is more than one item in the queue
 func (d *LocalDriver) HasItemsToProcess(conflictMode bool) bool {
 	if conflictMode {
@@ -149,7 +300,16 @@ func (d *LocalDriver) GetAllItems(conflictMode bool) []models.Item {
 // It returns an error if the file cannot be opened.
 // The caller is responsible for closing the reader.
 func (d *LocalDriver) GetReader(i models.Item) (io.ReadCloser, error) {
-	file, err := os.Open(d.getFilePath(i))
+	if !i.IsFile() {
+		return nil, fmt.Errorf("only files have contents: %s is a %s", i.ServerPath, i.Kind)
+	}
+
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %w", err)
 	}
@@ -158,16 +318,103 @@ func (d *LocalDriver) GetReader(i models.Item) (io.ReadCloser, error) {
 }
 
 // Touch will update the mtime of the given item to the server mtime
+// Symlinks are skipped, as changing their mtime would change the mtime of the target
 func (d *LocalDriver) Touch(i models.Item) error {
+	if i.IsSymlink() {
+		return nil
+	}
+
 	// int64 to time.Time
 	t := time.Unix(i.ServerMTime, 0)
 
-	return os.Chtimes(d.getFilePath(i), t, t)
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(filePath, t, t)
+}
+
+// Apply will create the item if it is a directory or a symlink and set its permissions.
+// Symlinks must point to an item inside of the vault. The contents of files are written with GetWriter instead
+func (d *LocalDriver) Apply(i models.Item) error {
+	path, err := d.getFilePath(i)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case i.IsDir():
+		if err := d.removeIfKindChanged(path, i); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(path, i.Permissions()); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+	case i.IsSymlink():
+		if _, err := iops.ResolveLinkTarget(iops.VaultPath(i.ServerPath), i.LinkTarget); err != nil {
+			return err
+		}
+
+		if target, err := os.Readlink(path); err == nil && filepath.ToSlash(target) == i.LinkTarget {
+			return nil
+		}
+
+		if err := d.removeIfKindChanged(path, i); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+
+		if err := os.Symlink(filepath.FromSlash(i.LinkTarget), path); err != nil {
+			return fmt.Errorf("error creating symlink: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := os.Chmod(path, i.Permissions()); err != nil {
+		return fmt.Errorf("error setting permissions: %w", err)
+	}
+
+	return nil
+}
+
+// removeIfKindChanged will remove whatever is at the path if it is not of the same kind as the item.
+// Symlinks are always removed, so they can be replaced. Directories are only removed if empty
+func (d *LocalDriver) removeIfKindChanged(path string, i models.Item) error {
+	fileInfo, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error checking path: %w", err)
+	}
+
+	if fileInfo.Mode().IsRegular() && i.IsFile() || fileInfo.IsDir() && i.IsDir() {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error replacing %s with a %s: %w", i.ServerPath, i.Kind, err)
+	}
+
+	return nil
 }
 
 // GetWriter should be used to get a writer for the given item, when you want to save it
-func (d *LocalDriver) GetWriter(i models.Item) (io.WriteCloser, error) {
-	path := d.getFilePath(i)
+// The contents are written to a temporary file next to the item, which only replaces it on Commit.
+// Readers and the watcher never see a partially written item and a failed write leaves the item as it was
+func (d *LocalDriver) GetWriter(i models.Item) (ItemWriter, error) {
+	path, err := d.getFilePath(i)
+	if err != nil {
+		return nil, err
+	}
+
 	dirPath := filepath.Dir(path)
 	absPath, err := filepath.Abs(dirPath)
 	if err != nil {
@@ -180,36 +427,271 @@ func (d *LocalDriver) GetWriter(i models.Item) (io.WriteCloser, error) {
 		return nil, fmt.Errorf("error creating directory: %w", err)
 	}
 
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+	file, err := os.CreateTemp(absPath, ignore.TempFilePrefix+"*")
 	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
 	}
 
-	return file, nil
+	// Temporary files are only readable by the owner, so set the mode of the item explicitly
+	if err := file.Chmod(i.Permissions()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("error setting permissions: %w", err)
+	}
+
+	return &localWriter{driver: d, item: i, path: path, file: file}, nil
+}
+
+// localWriter writes to a temporary file and renames it over the item on Commit
+type localWriter struct {
+	driver    *LocalDriver
+	item      models.Item
+	path      string
+	file      *os.File
+	committed bool
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Commit will flush the contents to disk and replace the item with them
+func (w *localWriter) Commit() error {
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("error flushing file: %w", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+
+	// Renaming over a symlink replaces the link itself, but a directory in the way has to be removed first
+	if err := w.driver.removeIfKindChanged(w.path, w.item); err != nil {
+		return err
+	}
+
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		return fmt.Errorf("error replacing file: %w", err)
+	}
+
+	w.committed = true
+
+	return nil
+}
+
+// Close will remove the temporary file, unless it was committed
+func (w *localWriter) Close() error {
+	if w.committed {
+		return nil
+	}
+
+	w.file.Close()
+
+	if err := os.Remove(w.file.Name()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing temporary file: %w", err)
+	}
+
+	return nil
+}
+
+// Delete will remove the given item from the local storage. If it does not exist, does nothing
+// Directories are only removed if they are empty, as their contents are synced as separate items
+func (d *LocalDriver) Delete(i models.Item) error {
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return err
+	}
+
+	// On case-insensitive filesystems, the path could point to a different item, which must not be deleted
+	if _, collides := d.collidesLocally(filePath); collides {
+		return nil
+	}
+
+	if fileInfo, err := os.Lstat(filePath); err == nil && fileInfo.IsDir() {
+		if entries, err := os.ReadDir(filePath); err != nil || len(entries) > 0 {
+			slog.Debug("Not deleting directory that is not empty", "path", i.ServerPath)
+			return nil
+		}
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting file: %w", err)
+	}
+
+	return nil
+}
+
+// Rename will move the item to the path of the other item, creating any missing directories.
+// Fails if something already exists at the new path
+func (d *LocalDriver) Rename(from models.Item, to models.Item) error {
+	fromPath, err := d.getFilePath(from)
+	if err != nil {
+		return err
+	}
+
+	toPath, err := d.getFilePath(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(toPath); err == nil {
+		return fmt.Errorf("cannot rename %s, %s already exists", from.ServerPath, to.ServerPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(toPath), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return fmt.Errorf("error renaming item: %w", err)
+	}
+
+	return nil
+}
+
+// collidesLocally returns true if something exists at the path, but under a name that differs in case or unicode
+// normalization. This happens on case-insensitive or normalizing filesystems. Returns the name found on the disk
+func (d *LocalDriver) collidesLocally(filePath string) (string, bool) {
+	if _, err := os.Lstat(filePath); err != nil {
+		return "", false
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		return "", false
+	}
+
+	name := filepath.Base(filePath)
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return "", false
+		}
+	}
+
+	for _, entry := range entries {
+		if iops.NormalizePath(entry.Name()) == iops.NormalizePath(name) {
+			return entry.Name(), true
+		}
+	}
+
+	return "", false
 }
 
 func (d *LocalDriver) Exists(i models.Item) bool {
-	_, err := os.Stat(d.getFilePath(i))
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Lstat(filePath)
 	return err == nil
 }
 
-// getFilePath will return the absolute path to the file
-func (d *LocalDriver) getFilePath(i models.Item) string {
-	return filepath.Join(d.VaultPath, i.ServerPath)
+// Stat returns the local version of the item at the path of the given one, with its SHA256 and size
+func (d *LocalDriver) Stat(i models.Item) (models.Item, error) {
+	filePath, err := d.getFilePath(i)
+	if err != nil {
+		return models.Item{}, err
+	}
+
+	return d.itemFromPath(filePath)
+}
+
+// getFilePath will return the absolute path to the file.
+// Returns an error if the path of the item is not a valid VaultPath or it escapes the vault through a symlink
+func (d *LocalDriver) getFilePath(i models.Item) (string, error) {
+	vaultPath, err := iops.NewVaultPath(i.ServerPath)
+	if err != nil {
+		return "", err
+	}
+
+	return vaultPath.Join(d.VaultPath)
 }
 
-// CalculateSHA256 will return the SHA256 of the given item
+// getServerPath will return the path relative to the vault, using forward slashes
+func (d *LocalDriver) getServerPath(absPath string) (string, error) {
+	vaultPath, err := filepath.Abs(d.VaultPath)
+	if err != nil {
+		return "", err
+	}
+
+	relPath, err := filepath.Rel(vaultPath, absPath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(relPath), nil
+}
+
+// itemFromPath will build the item for the given absolute path, without following symlinks.
+// Returns an error for symlinks that point outside of the vault, as they cannot be synced
+func (d *LocalDriver) itemFromPath(absPath string) (models.Item, error) {
+	fileInfo, err := os.Lstat(absPath)
+	if err != nil {
+		return models.Item{}, err
+	}
+
+	serverPath, err := d.getServerPath(absPath)
+	if err != nil {
+		return models.Item{}, err
+	}
+
+	item := models.Item{
+		ServerPath:  serverPath,
+		ServerMTime: fileInfo.ModTime().Unix(),
+		Mode:        uint32(fileInfo.Mode().Perm()),
+	}
+
+	switch {
+	case fileInfo.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return models.Item{}, fmt.Errorf("error reading symlink: %w", err)
+		}
+
+		item.Kind = models.ItemKindSymlink
+		item.Mode = 0
+		item.LinkTarget = filepath.ToSlash(target)
+		if _, err := iops.ResolveLinkTarget(iops.VaultPath(serverPath), item.LinkTarget); err != nil {
+			return models.Item{}, err
+		}
+
+		item.SHA256 = digest.SHA256(item.LinkTarget)
+		item.Size = len(item.LinkTarget)
+	case fileInfo.IsDir():
+		item.Kind = models.ItemKindDir
+	case fileInfo.Mode().IsRegular():
+		item.Kind = models.ItemKindFile
+		item.Size = int(fileInfo.Size())
+		if item.SHA256, err = digest.FileSHA256(absPath); err != nil {
+			return models.Item{}, err
+		}
+	default:
+		return models.Item{}, fmt.Errorf("unsupported file type: %s", fileInfo.Mode().Type())
+	}
+
+	return item, nil
+}
+
+// CalculateSHA256 will return the SHA256 of the given item. For symlinks, this is the SHA256 of the target
 func (d *LocalDriver) CalculateSHA256(i models.Item) string {
-	digest, err := digest.FileSHA256(d.getFilePath(i))
+	filePath, err := d.getFilePath(i)
 	if err != nil {
 		slog.Error("Error calculating SHA256", "error", err)
 		return ""
 	}
 
-	return digest
+	item, err := d.itemFromPath(filePath)
+	if err != nil {
+		slog.Debug("Error calculating SHA256", "error", err)
+		return ""
+	}
+
+	return item.SHA256
 }
 
 // EnqueueItemsSince will add all items that have been modified since the given lastSyncTime to the queue
+// Directories and symlinks are items as well, symlinks are not followed. Ignored items are skipped. The ignore file is reloaded first, in case it was changed while we were not watching
 func (d *LocalDriver) EnqueueItemsSince(lastSyncTime int, vaultName string) {
 	vaultPath, err := filepath.Abs(d.VaultPath)
 	if err != nil {
@@ -217,40 +699,51 @@ func (d *LocalDriver) EnqueueItemsSince(lastSyncTime int, vaultName string) {
 		return
 	}
 
+	if err := d.ReloadIgnore(); err != nil {
+		slog.Error("Error reloading ignore rules", "error", err)
+	}
+
 	filepath.WalkDir(vaultPath, func(path string, info os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
-		}
-
-		fileInfo, err := os.Stat(path)
+		serverPath, err := d.getServerPath(path)
 		if err != nil {
 			return err
 		}
 
-		if fileInfo.ModTime().Unix() < int64(lastSyncTime) {
+		if d.IsIgnored(serverPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// The root of the vault is not an item
+		if path == vaultPath {
 			return nil
 		}
 
-		item := models.Item{
-			ServerPath:  strings.Replace(path, vaultPath+"/", "", 1),
-			Size:        int(fileInfo.Size()),
-			ServerMTime: fileInfo.ModTime().Unix(),
+		item, err := d.itemFromPath(path)
+		if err != nil {
+			slog.Warn("Skipping item", "path", serverPath, "error", err)
+			return nil
 		}
 
-		item.SHA256 = d.CalculateSHA256(item)
+		if item.ServerMTime < int64(lastSyncTime) {
+			return nil
+		}
 
 		d.queue = append(d.queue, item)
 		return nil
 	})
 }
 
-// WatchVault will watch the given vault for changes and add them to the queue
-// @TODO: Deletions. Send a message to the server that the file was deleted
-func (d *LocalDriver) WatchVault(vaultName string, changeChan chan<- *models.Item) error {
+// WatchVault will watch the given vault for changes and send them to the changeChan until done is closed
+// Removed files are sent as deleted items. New directories and symlinks and permission changes are sent as well. Ignored items are skipped. Changes to the ignore file reload the rules
+func (d *LocalDriver) WatchVault(vaultName string, changeChan chan<- *models.Item, done <-chan struct{}) error {
 	// Create new watcher.
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -267,26 +760,53 @@ func (d *LocalDriver) WatchVault(vaultName string, changeChan chan<- *models.Ite
 					return
 				}
 
-				if event.Has(fsnotify.Write) {
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					serverPath, err := d.getServerPath(event.Name)
+					if err != nil || d.IsIgnored(serverPath, false) {
+						continue
+					}
 
-					if err != nil {
+					slog.Debug("File removed", "path", serverPath)
+					select {
+					case changeChan <- &models.Item{ServerPath: serverPath, Deleted: true}:
+					case <-done:
 						return
 					}
+				}
 
-					fileInfo, err := os.Stat(event.Name)
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Chmod) {
+					item, err := d.itemFromPath(event.Name)
 					if err != nil {
-						return
+						continue
 					}
 
-					item := models.Item{
-						ServerPath: strings.Replace(event.Name, d.VaultPath, "", 1),
-						Size:       int(fileInfo.Size()),
+					// Files are sent when they are written to, so the creation of an empty file is not sent twice
+					if event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && item.IsFile() {
+						continue
+					}
+
+					if d.IsIgnored(item.ServerPath, item.IsDir()) {
+						continue
+					}
+
+					if item.IsDir() && event.Has(fsnotify.Create) {
+						if err := d.addRecursiveWatchers(watcher, event.Name, false); err != nil {
+							slog.Error("Error watching new directory", "path", item.ServerPath, "error", err)
+						}
+					}
+
+					if item.ServerPath == ignore.FileName {
+						if err := d.ReloadIgnore(); err != nil {
+							slog.Error("Error reloading ignore rules", "error", err)
+						}
 					}
 
-					item.SHA256 = d.CalculateSHA256(item)
 					slog.Debug("File changed", "item", item)
-					changeChan <- &item
-					// d.Enqueue([]models.Item{item})
+					select {
+					case changeChan <- &item:
+					case <-done:
+						return
+					}
 				}
 
 			case err, ok := <-watcher.Errors:
@@ -294,7 +814,7 @@ func (d *LocalDriver) WatchVault(vaultName string, changeChan chan<- *models.Ite
 					return
 				}
 
-				slog.Error("error:", err)
+				slog.Error("Error watching vault", "error", err)
 			}
 		}
 	}()
@@ -305,8 +825,8 @@ func (d *LocalDriver) WatchVault(vaultName string, changeChan chan<- *models.Ite
 	if err != nil {
 		return err
 	}
-	// Block main goroutine forever.
-	<-make(chan struct{})
+	// Block until we are told to stop
+	<-done
 	return nil
 }
 
@@ -317,6 +837,10 @@ func (d *LocalDriver) addRecursiveWatchers(watcher *fsnotify.Watcher, path strin
 			return err
 		}
 		if fi.IsDir() {
+			if serverPath, err := d.getServerPath(walkPath); err == nil && d.IsIgnored(serverPath, true) {
+				return filepath.SkipDir
+			}
+
 			if unWatch {
 				if err = watcher.Remove(walkPath); err != nil {
 					return err
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Michaelpalacce/gobi/pkg/models"
+)
+
+func TestChangedLocally(t *testing.T) {
+	file := func(sha string, mtime int64) models.Item {
+		return models.Item{ServerPath: "a.md", SHA256: sha, ServerMTime: mtime}
+	}
+
+	tests := []struct {
+		name    string
+		local   models.Item
+		remote  models.Item
+		base    models.Item
+		hasBase bool
+		want    bool
+	}{
+		{
+			name:    "local matches the synced version",
+			local:   file("1", 200),
+			remote:  file("2", 100),
+			base:    file("1", 50),
+			hasBase: true,
+			want:    false,
+		},
+		{
+			name:    "local differs from the synced version",
+			local:   file("3", 100),
+			remote:  file("2", 200),
+			base:    file("1", 50),
+			hasBase: true,
+			want:    true,
+		},
+		{
+			name:    "local edit of a deleted version",
+			local:   file("3", 100),
+			remote:  file("2", 200),
+			base:    models.Item{ServerPath: "a.md", Deleted: true},
+			hasBase: true,
+			want:    true,
+		},
+		{
+			name:   "no synced version and local is newer",
+			local:  file("1", 200),
+			remote: file("2", 100),
+			want:   true,
+		},
+		{
+			name:   "no synced version and remote is newer",
+			local:  file("1", 100),
+			remote: file("2", 200),
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedLocally(tt.local, tt.remote, tt.base, tt.hasBase); got != tt.want {
+				t.Errorf("changedLocally() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveVault(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, to string)
+		wantErr bool
+	}{
+		{
+			name:    "nothing at the new name",
+			prepare: func(t *testing.T, to string) {},
+		},
+		{
+			name: "empty directory at the new name",
+			prepare: func(t *testing.T, to string) {
+				if err := os.Mkdir(to, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "directory with contents at the new name",
+			prepare: func(t *testing.T, to string) {
+				if err := os.Mkdir(to, 0o755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(filepath.Join(to, "b.md"), []byte("b"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := t.TempDir()
+			previous := localVaultsLocation
+			localVaultsLocation = location
+			t.Cleanup(func() { localVaultsLocation = previous })
+
+			if err := os.MkdirAll(filepath.Join(location, "notes"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(location, "notes", "a.md"), []byte("a"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.prepare(t, filepath.Join(location, "id"))
+
+			err := MoveVault("notes", "id")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveVault() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			_, statErr := os.Stat(filepath.Join(location, "id", "a.md"))
+			if moved := statErr == nil; moved == tt.wantErr {
+				t.Errorf("MoveVault() moved = %v, want %v", moved, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit bool
+		want   string
+	}{
+		{
+			name:   "committed contents replace the item",
+			commit: true,
+			want:   "new",
+		},
+		{
+			name: "contents that were not committed are discarded",
+			want: "old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := t.TempDir()
+			previous := localVaultsLocation
+			localVaultsLocation = location
+			t.Cleanup(func() { localVaultsLocation = previous })
+
+			driver, err := NewLocalDriver("notes")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(location, "notes", "a.md")
+			if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			writer, err := driver.GetWriter(models.Item{ServerPath: "a.md", Kind: models.ItemKindFile})
+			if err != nil {
+				t.Fatalf("GetWriter() error = %v", err)
+			}
+
+			if _, err := writer.Write([]byte("new")); err != nil {
+				t.Fatal(err)
+			}
+
+			if contents, _ := os.ReadFile(path); string(contents) != "old" {
+				t.Errorf("contents before commit = %q, want %q", contents, "old")
+			}
+
+			if tt.commit {
+				if err := writer.Commit(); err != nil {
+					t.Fatalf("Commit() error = %v", err)
+				}
+			}
+
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if contents, _ := os.ReadFile(path); string(contents) != tt.want {
+				t.Errorf("contents = %q, want %q", contents, tt.want)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(location, "notes"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 1 {
+				t.Errorf("vault contains %d entries, want only the item", len(entries))
+			}
+		})
+	}
+}